@@ -0,0 +1,76 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/api"
+    "github.com/ifruncillo/idlenet-agent/internal/bench"
+    "github.com/ifruncillo/idlenet-agent/internal/idle"
+)
+
+const (
+    benchmarkCheck = 10 * time.Minute // How often to see whether a benchmark is due
+    benchmarkIdle  = 30 * time.Second // How long the user must be away; a run is a few seconds on one core
+)
+
+// benchmarker keeps the device's benchmark profile current and tells the API
+// client about it, so it goes out at registration and in heartbeats
+type benchmarker struct {
+    client  *api.Client
+    dataDir string
+    profile *bench.Profile // Last measured, possibly on another version or machine
+}
+
+// newBenchmarker reports the cached profile if it still applies; if not,
+// nothing is reported until maybeRun has measured again
+func newBenchmarker(client *api.Client, dataDir string) *benchmarker {
+    b := &benchmarker{client: client, dataDir: dataDir, profile: bench.Load(dataDir)}
+    if bench.Stale(b.profile, version, bench.DetectHardware()) == "" {
+        client.SetCapabilities(capabilities(b.profile))
+    }
+    return b
+}
+
+// maybeRun measures the device if the profile is missing or out of date and
+// the user has been away long enough not to notice a few busy seconds
+func (b *benchmarker) maybeRun(ctx context.Context) {
+    reason := bench.Stale(b.profile, version, bench.DetectHardware())
+    if reason == "" {
+        return
+    }
+    if isIdle, err := idle.IsIdle(benchmarkIdle); err != nil || !isIdle {
+        return
+    }
+    
+    slog.Info("Benchmarking device", "reason", reason)
+    profile, err := bench.Run(ctx, version)
+    if err != nil {
+        slog.Warn("Benchmark failed", "error", err)
+        return
+    }
+    if err := bench.Save(b.dataDir, profile); err != nil {
+        slog.Warn("Benchmark not cached", "error", err)
+    }
+    b.profile = profile
+    b.client.SetCapabilities(capabilities(profile))
+    
+    scores := profile.Scores
+    slog.Info("Benchmark complete", "hash_mbps", round(scores.HashMBps, 0), "float_mflops", round(scores.FloatMflops, 0),
+        "memory_mbps", round(scores.MemoryMBps, 0), "wasm_mips", round(scores.WASMMips, 0))
+}
+
+// capabilities is how a profile is reported to the server
+func capabilities(p *bench.Profile) *api.Capabilities {
+    return &api.Capabilities{
+        AgentVersion: p.AgentVersion,
+        MeasuredAt:   p.MeasuredAt,
+        CPUModel:     p.Hardware.CPUModel,
+        MemoryMB:     p.Hardware.MemoryMB,
+        HashMBps:     p.Scores.HashMBps,
+        FloatMflops:  p.Scores.FloatMflops,
+        MemoryMBps:   p.Scores.MemoryMBps,
+        WASMMips:     p.Scores.WASMMips,
+    }
+}
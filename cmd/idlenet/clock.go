@@ -0,0 +1,51 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/api"
+)
+
+// skewWarning is how far our clock may drift from the server's before we say
+// so; signed requests start failing at five minutes
+const skewWarning = time.Minute
+
+// stampServerTimes adds the server-clock view of a result's timestamps
+func stampServerTimes(apiClient *api.Client, result *api.JobResult) {
+    offset, ok := apiClient.ClockOffset()
+    if !ok {
+        return
+    }
+    started, _ := apiClient.ServerTime(result.StartedAt)
+    finished, _ := apiClient.ServerTime(result.FinishedAt)
+    result.ServerStartedAt = &started
+    result.ServerFinishedAt = &finished
+    result.ClockOffsetMs = offset.Milliseconds()
+}
+
+// skewWatch warns when the local clock is well off the server's, once per episode
+type skewWatch struct {
+    warned bool
+}
+
+func (w *skewWatch) check(apiClient *api.Client) {
+    offset, ok := apiClient.ClockOffset()
+    if !ok {
+        return
+    }
+    
+    off := offset > skewWarning || offset < -skewWarning
+    if off && !w.warned {
+        direction := "behind"
+        if offset < 0 {
+            direction = "ahead of"
+        }
+        slog.Warn(fmt.Sprintf("This computer's clock is %v %s the server's; check its time settings",
+            offset.Abs().Round(time.Second), direction), "offset", offset.Round(time.Millisecond))
+    } else if !off && w.warned {
+        slog.Info("Clock back in step with the server")
+    }
+    w.warned = off
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log/slog"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/api"
+    "github.com/ifruncillo/idlenet-agent/internal/config"
+    "github.com/ifruncillo/idlenet-agent/internal/identity"
+)
+
+// enroll registers the device and records which key the server now knows
+// It is used on first run, after a key change, and when a session is revoked
+func enroll(ctx context.Context, apiClient *api.Client, cfg *config.Config, deviceKey *identity.Identity) error {
+    regCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    
+    if err := apiClient.Register(regCtx, cfg.Referral); err != nil {
+        return err
+    }
+    
+    cfg.Registered = true
+    cfg.RegisteredKey = deviceKey.Fingerprint()
+    return config.Save(cfg)
+}
+
+// reenrollIfRevoked registers again when err says the server dropped our session
+func reenrollIfRevoked(ctx context.Context, err error, apiClient *api.Client, cfg *config.Config, deviceKey *identity.Identity) {
+    if !errors.Is(err, api.ErrSessionRevoked) {
+        return
+    }
+    
+    slog.Warn("Session revoked, re-registering")
+    
+    cfg.Registered = false
+    if err := enroll(ctx, apiClient, cfg, deviceKey); err != nil {
+        slog.Error("Re-registration failed", "error", err)
+        return
+    }
+    slog.Info("Re-registered with server")
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strconv"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/config"
+    "github.com/ifruncillo/idlenet-agent/internal/ledger"
+    "github.com/ifruncillo/idlenet-agent/internal/metrics"
+)
+
+// exportRow is one job as exported, joining the ledger's estimate and credit
+// with whatever detail the job history still keeps
+type exportRow struct {
+    JobID           string     `json:"job_id"`
+    JobType         string     `json:"job_type"`
+    Status          string     `json:"status"`
+    Started         *time.Time `json:"started,omitempty"`
+    Finished        time.Time  `json:"finished"`
+    ElapsedSeconds  float64    `json:"elapsed_seconds"`
+    CPUSeconds      float64    `json:"cpu_seconds"`
+    MemoryMB        int        `json:"memory_mb,omitempty"`
+    CreditedUnits   float64    `json:"credited_units"`
+    RateCardVersion int64      `json:"rate_card_version"`
+    Estimate        float64    `json:"estimate"`
+    Confirmed       bool       `json:"confirmed"`
+    Credited        float64    `json:"credited"` // What the server paid, 0 until Confirmed
+    CreditedAt      *time.Time `json:"credited_at,omitempty"`
+}
+
+// exportMonth is one month of the monthly summary
+type exportMonth struct {
+    Month      string
+    Jobs       int
+    Succeeded  int
+    Failed     int
+    CPUSeconds float64
+    Units      float64
+    Estimated  float64
+    Credited   float64
+    Pending    float64
+}
+
+// exportFilter narrows the export; zero values match everything
+type exportFilter struct {
+    from    time.Time // Inclusive, local midnight
+    to      time.Time // Exclusive, the local midnight after the last day
+    jobType string
+    status  string // "ok", "failed" or a raw status such as "skipped"
+}
+
+func (f exportFilter) matches(row exportRow) bool {
+    if !f.from.IsZero() && row.Finished.Before(f.from) {
+        return false
+    }
+    if !f.to.IsZero() && !row.Finished.Before(f.to) {
+        return false
+    }
+    if f.jobType != "" && row.JobType != f.jobType {
+        return false
+    }
+    switch f.status {
+    case "":
+    case "failed":
+        return row.Status != "ok"
+    default:
+        return row.Status == f.status
+    }
+    return true
+}
+
+// runExport is the export subcommand:
+//
+//   idlenet export [-format csv|jsonl|monthly] [-from 2024-01-01] [-to 2024-12-31]
+//                  [-type wasm] [-status ok|failed] [-o earnings.csv]
+//
+// It only reads the ledger and job history, so it's safe while the agent runs
+func runExport(args []string) int {
+    flags := flag.NewFlagSet("export", flag.ContinueOnError)
+    format := flags.String("format", "csv", "csv, jsonl or monthly")
+    from := flags.String("from", "", "first day to include, YYYY-MM-DD local time")
+    to := flags.String("to", "", "last day to include, YYYY-MM-DD local time")
+    jobType := flags.String("type", "", "only jobs of this type")
+    status := flags.String("status", "", "ok, failed, or a job status such as skipped")
+    output := flags.String("o", "", "write to this file instead of stdout")
+    if err := flags.Parse(args); err != nil {
+        return 2
+    }
+    
+    filter := exportFilter{jobType: *jobType, status: *status}
+    var err error
+    if *from != "" {
+        if filter.from, err = time.ParseInLocation("2006-01-02", *from, time.Local); err != nil {
+            fmt.Fprintf(os.Stderr, "export: invalid -from %q, want YYYY-MM-DD\n", *from)
+            return 2
+        }
+    }
+    if *to != "" {
+        last, err := time.ParseInLocation("2006-01-02", *to, time.Local)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "export: invalid -to %q, want YYYY-MM-DD\n", *to)
+            return 2
+        }
+        filter.to = last.AddDate(0, 0, 1)
+    }
+    switch *format {
+    case "csv", "jsonl", "monthly":
+    default:
+        fmt.Fprintf(os.Stderr, "export: unknown -format %q, want csv, jsonl or monthly\n", *format)
+        return 2
+    }
+    
+    dataDir, err := config.DataDir()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "export: failed to open data directory: %v\n", err)
+        return 1
+    }
+    rows, err := loadExportRows(dataDir)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "export: %v\n", err)
+        return 1
+    }
+    
+    var w io.Writer = os.Stdout
+    if *output != "" {
+        file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "export: %v\n", err)
+            return 1
+        }
+        defer file.Close()
+        w = file
+    }
+    
+    var selected []exportRow
+    for _, row := range rows {
+        if filter.matches(row) {
+            selected = append(selected, row)
+        }
+    }
+    
+    switch *format {
+    case "csv":
+        err = writeExportCSV(w, selected)
+    case "jsonl":
+        err = writeExportJSONL(w, selected)
+    case "monthly":
+        err = writeExportMonthly(w, selected)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "export: %v\n", err)
+        return 1
+    }
+    if *output != "" {
+        fmt.Fprintf(os.Stderr, "Exported %d jobs to %s\n", len(selected), *output)
+    }
+    return 0
+}
+
+// loadExportRows joins the ledger, which has every job and its credit, with
+// the job history, which has detail for jobs still inside retention
+func loadExportRows(dataDir string) ([]exportRow, error) {
+    book, _, err := ledger.Open(dataDir)
+    if err != nil {
+        return nil, err
+    }
+    defer book.Close()
+    
+    rows := make(map[string]*exportRow)
+    for _, entry := range book.Entries() {
+        row := &exportRow{
+            JobID:           entry.JobID,
+            JobType:         entry.JobType,
+            Status:          entry.Status,
+            Finished:        entry.Finished,
+            CPUSeconds:      entry.CPUSeconds,
+            CreditedUnits:   entry.Units,
+            RateCardVersion: entry.RateCard,
+            Estimate:        entry.Estimate,
+            Confirmed:       entry.Confirmed,
+            Credited:        entry.Credited,
+        }
+        if entry.Confirmed && !entry.CreditedAt.IsZero() {
+            creditedAt := entry.CreditedAt
+            row.CreditedAt = &creditedAt
+        }
+        rows[entry.JobID] = row
+    }
+    
+    history, err := metrics.OpenHistory(metrics.HistoryDir(dataDir), metrics.DefaultRetention)
+    if err != nil {
+        return nil, err
+    }
+    err = history.Jobs(func(job *metrics.JobMetrics) {
+        row, ok := rows[job.JobID]
+        if !ok {
+            // Finished but never reached the ledger, e.g. it failed to write
+            status := "ok"
+            if !job.Success {
+                status = "error"
+            }
+            row = &exportRow{
+                JobID:           job.JobID,
+                Status:          status,
+                Finished:        job.EndTime,
+                CPUSeconds:      job.CPUSeconds,
+                CreditedUnits:   job.CreditedUnits,
+                RateCardVersion: job.RateCardVersion,
+                Estimate:        job.Earnings,
+            }
+            rows[job.JobID] = row
+        }
+        if row.JobType == "" {
+            row.JobType = job.JobType
+        }
+        started := job.StartTime
+        row.Started = &started
+        row.ElapsedSeconds = job.ElapsedSeconds
+        row.MemoryMB = job.MemoryMB
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    sorted := make([]exportRow, 0, len(rows))
+    for _, row := range rows {
+        sorted = append(sorted, *row)
+    }
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i].Finished.Before(sorted[j].Finished) })
+    return sorted, nil
+}
+
+func writeExportCSV(w io.Writer, rows []exportRow) error {
+    out := csv.NewWriter(w)
+    out.Write([]string{
+        "job_id", "job_type", "status", "started", "finished", "elapsed_seconds", "cpu_seconds",
+        "memory_mb", "credited_units", "rate_card_version", "estimate", "confirmed", "credited", "credited_at",
+    })
+    for _, row := range rows {
+        out.Write([]string{
+            row.JobID,
+            row.JobType,
+            row.Status,
+            formatExportTime(row.Started),
+            formatExportTime(&row.Finished),
+            formatExportFloat(row.ElapsedSeconds),
+            formatExportFloat(row.CPUSeconds),
+            strconv.Itoa(row.MemoryMB),
+            formatExportFloat(row.CreditedUnits),
+            strconv.FormatInt(row.RateCardVersion, 10),
+            formatExportFloat(row.Estimate),
+            strconv.FormatBool(row.Confirmed),
+            formatExportFloat(row.Credited),
+            formatExportTime(row.CreditedAt),
+        })
+    }
+    out.Flush()
+    return out.Error()
+}
+
+func writeExportJSONL(w io.Writer, rows []exportRow) error {
+    encoder := json.NewEncoder(w)
+    for _, row := range rows {
+        if err := encoder.Encode(row); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// writeExportMonthly totals the rows by local calendar month as CSV
+func writeExportMonthly(w io.Writer, rows []exportRow) error {
+    var months []*exportMonth
+    byMonth := make(map[string]*exportMonth)
+    for _, row := range rows {
+        key := row.Finished.Local().Format("2006-01")
+        month, ok := byMonth[key]
+        if !ok {
+            month = &exportMonth{Month: key}
+            byMonth[key] = month
+            months = append(months, month)
+        }
+        month.Jobs++
+        if row.Status == "ok" {
+            month.Succeeded++
+        } else {
+            month.Failed++
+        }
+        month.CPUSeconds += row.CPUSeconds
+        month.Units += row.CreditedUnits
+        month.Estimated += row.Estimate
+        if row.Confirmed {
+            month.Credited += row.Credited
+        } else {
+            month.Pending += row.Estimate
+        }
+    }
+    
+    out := csv.NewWriter(w)
+    out.Write([]string{"month", "jobs", "succeeded", "failed", "cpu_seconds", "credited_units", "estimated", "credited", "pending"})
+    for _, month := range months {
+        out.Write([]string{
+            month.Month,
+            strconv.Itoa(month.Jobs),
+            strconv.Itoa(month.Succeeded),
+            strconv.Itoa(month.Failed),
+            formatExportFloat(month.CPUSeconds),
+            formatExportFloat(month.Units),
+            formatExportFloat(month.Estimated),
+            formatExportFloat(month.Credited),
+            formatExportFloat(month.Pending),
+        })
+    }
+    out.Flush()
+    return out.Error()
+}
+
+func formatExportTime(t *time.Time) string {
+    if t == nil || t.IsZero() {
+        return ""
+    }
+    return t.Local().Format(time.RFC3339)
+}
+
+func formatExportFloat(f float64) string {
+    return strconv.FormatFloat(f, 'f', -1, 64)
+}
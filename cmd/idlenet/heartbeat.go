@@ -0,0 +1,186 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "runtime"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/api"
+    "github.com/ifruncillo/idlenet-agent/internal/cache"
+    "github.com/ifruncillo/idlenet-agent/internal/idle"
+    "github.com/ifruncillo/idlenet-agent/internal/metrics"
+    "github.com/ifruncillo/idlenet-agent/internal/remoteconfig"
+    "github.com/ifruncillo/idlenet-agent/internal/resource"
+    "github.com/ifruncillo/idlenet-agent/internal/updater"
+)
+
+// controls holds the knobs the server can turn through heartbeat directives
+// and remote config
+type controls struct {
+    heartbeat   *time.Ticker
+    interval    time.Duration // Heartbeat interval before any backoff
+    jobTicker   *time.Ticker
+    jobInterval time.Duration // Job poll interval without a push channel
+    metrics     *time.Ticker
+    artifacts   *cache.Cache
+    updates     *updater.Orchestrator
+    tracker     *metrics.Tracker
+    resources   *resource.Manager
+    settings    *remoteconfig.Manager
+    push        *pushLink
+    current     remoteconfig.Effective
+    paused      bool
+    pausedUntil time.Time // Zero while paused means until resumed
+}
+
+// applySettings puts the effective remote/user/policy settings into force
+func (c *controls) applySettings() {
+    eff := c.settings.Effective()
+    c.current = eff
+    
+    heartbeat := time.Duration(eff.HeartbeatSeconds) * time.Second
+    if heartbeat >= 5*time.Second && heartbeat != c.interval {
+        c.interval = heartbeat
+        c.heartbeat.Reset(c.interval)
+    }
+    
+    jobs := time.Duration(eff.JobPollSeconds) * time.Second
+    if jobs >= 5*time.Second && jobs != c.jobInterval {
+        c.jobInterval = jobs
+        c.jobTicker.Reset(c.jobInterval)
+    }
+    
+    if sample := time.Duration(eff.MetricsSeconds) * time.Second; sample >= time.Minute {
+        c.metrics.Reset(sample)
+    }
+    
+    c.tracker.SetEarningsRate(eff.EarningsPerCPUSecond)
+    c.resources.SetCPUCeiling(eff.MaxCPUPercent)
+    c.push.set(eff.Feature("push"))
+}
+
+// jobAllowed reports whether a claimed job fits the current settings, and why not
+func (c *controls) jobAllowed(job *api.Job) (bool, string) {
+    if !c.current.AllowsJobType(job.Type) {
+        return false, "job type disabled on this device"
+    }
+    if c.current.MaxMemoryMB > 0 && job.MemoryMB > c.current.MaxMemoryMB {
+        return false, fmt.Sprintf("needs %dMB, limit is %dMB", job.MemoryMB, c.current.MaxMemoryMB)
+    }
+    return true, ""
+}
+
+// isPaused reports whether the server has asked us to stop taking jobs
+func (c *controls) isPaused() bool {
+    if c.paused && !c.pausedUntil.IsZero() && time.Now().After(c.pausedUntil) {
+        c.paused = false
+        c.pausedUntil = time.Time{}
+    }
+    return c.paused
+}
+
+// apply carries out a single directive from a heartbeat response
+func (c *controls) apply(d api.Directive) {
+    switch d.Type {
+    case api.DirectivePause:
+        c.paused = true
+        c.pausedUntil = time.Time{}
+        if d.Seconds > 0 {
+            c.pausedUntil = time.Now().Add(time.Duration(d.Seconds) * time.Second)
+        }
+        slog.Info("Server paused job processing", "seconds", d.Seconds)
+    
+    case api.DirectiveResume:
+        c.paused = false
+        c.pausedUntil = time.Time{}
+        slog.Info("Server resumed job processing")
+    
+    case api.DirectiveSetInterval:
+        if d.Seconds < 5 {
+            slog.Warn("Ignoring heartbeat interval", "seconds", d.Seconds)
+            return
+        }
+        c.interval = time.Duration(d.Seconds) * time.Second
+        c.heartbeat.Reset(c.interval)
+        slog.Info("Heartbeat interval set", "seconds", d.Seconds)
+    
+    case api.DirectiveDropCache:
+        if c.artifacts == nil {
+            return
+        }
+        if err := c.artifacts.Clear(); err != nil {
+            slog.Error("Failed to drop cache", "error", err)
+        } else {
+            slog.Info("Cache dropped")
+        }
+    
+    case api.DirectiveUpdateNow:
+        if c.updates == nil {
+            return
+        }
+        slog.Info("Server requested an update")
+        c.updates.UpdateNow()
+    
+    default:
+        slog.Warn("Ignoring unknown directive", "directive", d.Type)
+    }
+}
+
+// buildHeartbeat gathers the agent's live state for the scheduler
+func buildHeartbeat(resourceMgr *resource.Manager, tracker *metrics.Tracker, ctl *controls, dataDir string) *api.Heartbeat {
+    cpuLimit, memLimit := resourceMgr.GetLimits()
+    
+    hb := &api.Heartbeat{
+        Cores:        runtime.NumCPU(),
+        AllowedCores: resourceMgr.GetCoreCount(),
+        Limits: api.Limits{
+            CPUPercent:    cpuLimit,
+            MemoryPercent: memLimit,
+        },
+        Idle: api.IdleState{
+            Paused: ctl.isPaused(),
+        },
+        RunningJobs:   tracker.RunningJobIDs(),
+        JobTypes:      ctl.current.JobTypes,
+        Cache:         []api.CacheEntry{},
+        Update:        updateStatus(ctl.updates),
+        ConfigVersion: ctl.settings.Version(),
+        RateCard:      tracker.RateCard().Version,
+    }
+    
+    if idleTime, err := idle.GetIdleTime(); err == nil {
+        hb.Idle.IdleSeconds = idleTime.Seconds()
+    }
+    if level, err := idle.GetActivityLevel(); err == nil {
+        hb.Idle.ActivityLevel = level
+    }
+    if free, err := resource.FreeDiskBytes(dataDir); err == nil {
+        hb.FreeDisk = free
+    }
+    if ctl.artifacts != nil {
+        if entries, err := ctl.artifacts.Entries(); err == nil {
+            for _, e := range entries {
+                hb.Cache = append(hb.Cache, api.CacheEntry{Key: e.Key, Size: e.Size})
+            }
+        }
+    }
+    
+    return hb
+}
+
+// updateStatus converts the orchestrator's state into the heartbeat's update report
+func updateStatus(updates *updater.Orchestrator) *api.UpdateStatus {
+    if updates == nil {
+        return nil
+    }
+    
+    status := updates.Status()
+    return &api.UpdateStatus{
+        State:            string(status.State),
+        CurrentVersion:   status.CurrentVersion,
+        AvailableVersion: status.AvailableVersion,
+        Progress:         status.Progress,
+        Error:            status.Error,
+    }
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+    "log/slog"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/config"
+    "github.com/ifruncillo/idlenet-agent/internal/metrics"
+)
+
+// historyMaintain is how often old job history is compressed and rolled up
+const historyMaintain = 24 * time.Hour
+
+// openHistory opens the job history in the data directory, bringing over any
+// days an older agent left under ~/.idlenet
+func openHistory(cfg *config.Config, dataDir string) (*metrics.History, error) {
+    retention := metrics.DefaultRetention
+    if cfg.MetricsRetentionDays > 0 {
+        retention.DetailDays = cfg.MetricsRetentionDays
+    }
+    
+    history, err := metrics.OpenHistory(metrics.HistoryDir(dataDir), retention)
+    if err != nil {
+        return nil, err
+    }
+    if n, err := history.MigrateFrom(metrics.LegacyHistoryDir()); err != nil {
+        slog.Warn("Job history only partly moved", "dir", history.Dir(), "error", err)
+    } else if n > 0 {
+        slog.Info("Moved job history into the data directory", "days", n, "dir", history.Dir())
+    }
+    return history, nil
+}
+
+// maintainHistory applies the retention policy, reporting rather than
+// failing on errors since the next pass will retry
+func maintainHistory(history *metrics.History) {
+    if err := history.Maintain(time.Now()); err != nil {
+        slog.Warn("Job history maintenance failed", "error", err)
+    }
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "sync"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/runner"
+)
+
+// runningJobs lets a pushed cancellation stop a job while it runs
+type runningJobs struct {
+    mu        sync.Mutex
+    cancels   map[string]context.CancelFunc
+    cancelled map[string]bool
+}
+
+func newRunningJobs() *runningJobs {
+    return &runningJobs{
+        cancels:   make(map[string]context.CancelFunc),
+        cancelled: make(map[string]bool),
+    }
+}
+
+// run executes a job, reporting "cancelled" if the server called it off
+func (r *runningJobs) run(ctx context.Context, id, jobType string, args json.RawMessage, maxSeconds int) runner.Result {
+    jobCtx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    
+    r.mu.Lock()
+    r.cancels[id] = cancel
+    r.mu.Unlock()
+    
+    res := runner.RunJob(jobCtx, jobType, args, maxSeconds)
+    
+    r.mu.Lock()
+    delete(r.cancels, id)
+    if r.cancelled[id] {
+        delete(r.cancelled, id)
+        res.Status = "cancelled"
+        res.Error = "cancelled by server"
+    }
+    r.mu.Unlock()
+    
+    return res
+}
+
+// cancel stops id if it is running and reports whether it was
+func (r *runningJobs) cancel(id string) bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    
+    cancel, ok := r.cancels[id]
+    if !ok {
+        return false
+    }
+    r.cancelled[id] = true
+    cancel()
+    return true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/api"
+    "github.com/ifruncillo/idlenet-agent/internal/ledger"
+)
+
+// ledgerReconcile is how often to fetch the server's credits
+const ledgerReconcile = time.Hour
+
+// maxCreditPages bounds one reconciliation so a long backlog is fetched over several
+const maxCreditPages = 20
+
+// reconcileLedger fetches credits the ledger hasn't seen and reports how many changed it
+func reconcileLedger(ctx context.Context, apiClient *api.Client, book *ledger.Ledger) (int, error) {
+    changed := 0
+    for page := 0; page < maxCreditPages; page++ {
+        fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+        credits, cursor, err := apiClient.GetCredits(fetchCtx, book.Cursor())
+        cancel()
+        if err != nil {
+            return changed, err
+        }
+    
+        batch := make([]ledger.Credit, 0, len(credits))
+        for _, c := range credits {
+            batch = append(batch, ledger.Credit{JobID: c.JobID, Amount: c.Amount, CreditedAt: c.CreditedAt})
+        }
+        before := book.Cursor()
+        n, err := book.ApplyCredits(batch, cursor)
+        changed += n
+        if err != nil {
+            return changed, err
+        }
+    
+        // Caught up once a page brings nothing new
+        if len(credits) == 0 || cursor == before {
+            break
+        }
+    }
+    return changed, nil
+}
+
+// describeLedger prints earnings totals from the ledger
+func describeLedger(book *ledger.Ledger) {
+    s := book.Summary(time.Now())
+    slog.Info("Estimated earnings", "today", round(s.Today.Estimated, 4), "week", round(s.Week.Estimated, 4),
+        "month", round(s.Month.Estimated, 4), "lifetime", round(s.Lifetime.Estimated, 4), "jobs", s.Lifetime.Jobs)
+    slog.Info("Confirmed earnings", "confirmed", round(s.Lifetime.Confirmed, 4), "pending", round(s.Lifetime.Pending, 4))
+}
+
+// reviewList remembers which discrepancies the user has already been shown
+type reviewList struct {
+    shown map[string]string // Job ID -> discrepancy text
+}
+
+// report prints discrepancies that are new or have changed since last time
+func (r *reviewList) report(book *ledger.Ledger) {
+    if r.shown == nil {
+        r.shown = make(map[string]string)
+    }
+    
+    var fresh []string
+    for _, d := range book.Discrepancies(time.Now()) {
+        text := d.String()
+        if r.shown[d.Entry.JobID] != text {
+            r.shown[d.Entry.JobID] = text
+            fresh = append(fresh, text)
+        }
+    }
+    if len(fresh) == 0 {
+        return
+    }
+    
+    slog.Warn("Ledger has jobs to review against your account", "jobs", len(fresh))
+    for i, text := range fresh {
+        if i == 10 {
+            slog.Warn("More jobs to review", "jobs", len(fresh)-i)
+            break
+        }
+        slog.Warn("Review: " + text)
+    }
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "io"
+    "log/slog"
+    "math"
+    "os"
+    "path/filepath"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/config"
+    "github.com/ifruncillo/idlenet-agent/internal/logging"
+)
+
+// setupLogging sends logs to the console and to rotating files under the
+// data directory, tagged with the device so fleet logs can be told apart
+func setupLogging(cfg *config.Config, dataDir string) (io.Closer, error) {
+    level, err := logging.ParseLevel(cfg.LogLevel)
+    if err != nil {
+        return nil, err
+    }
+    logger, closer, err := logging.Setup(logging.Options{
+        Level:   level,
+        Format:  cfg.LogFormat,
+        Dir:     filepath.Join(dataDir, "logs"),
+        Console: os.Stdout,
+    })
+    if err != nil {
+        return nil, err
+    }
+    slog.SetDefault(logger.With("device_id", cfg.DeviceID))
+    return closer, nil
+}
+
+// round keeps logged amounts readable
+func round(f float64, places int) float64 {
+    scale := math.Pow(10, float64(places))
+    return math.Round(f*scale) / scale
+}
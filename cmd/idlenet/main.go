@@ -2,28 +2,51 @@ package main
 
 import (
     "context"
+    "encoding/json"
     "fmt"
+    "log/slog"
     "os"
     "os/signal"
+    "path/filepath"
+    "sync"
+    "sync/atomic"
     "syscall"
     "time"
     
+    "go.opentelemetry.io/otel/attribute"
+    oteltrace "go.opentelemetry.io/otel/trace"
+    
     "github.com/ifruncillo/idlenet-agent/internal/api"
+    "github.com/ifruncillo/idlenet-agent/internal/cache"
+    "github.com/ifruncillo/idlenet-agent/internal/clock"
     "github.com/ifruncillo/idlenet-agent/internal/config"
+    "github.com/ifruncillo/idlenet-agent/internal/identity"
     "github.com/ifruncillo/idlenet-agent/internal/idle"
+    "github.com/ifruncillo/idlenet-agent/internal/ledger"
+    "github.com/ifruncillo/idlenet-agent/internal/logging"
     "github.com/ifruncillo/idlenet-agent/internal/metrics"
+    "github.com/ifruncillo/idlenet-agent/internal/outbox"
+    "github.com/ifruncillo/idlenet-agent/internal/remoteconfig"
     "github.com/ifruncillo/idlenet-agent/internal/resource"
+    "github.com/ifruncillo/idlenet-agent/internal/secrets"
+    "github.com/ifruncillo/idlenet-agent/internal/tracing"
+    "github.com/ifruncillo/idlenet-agent/internal/updater"
 )
 
 const version = "v1.0.0"
 
 func main() {
-    fmt.Printf("IdleNet Agent %s\n", version)
-    fmt.Println("========================================")
+    // Subcommands run and exit without starting the agent
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+        case "export":
+            os.Exit(runExport(os.Args[2:]))
+        }
+    }
     
     cfg, err := config.Load()
     if err != nil {
-        fmt.Printf("Failed to load configuration: %v\n", err)
+        fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
         os.Exit(1)
     }
     
@@ -37,9 +60,34 @@ func main() {
         config.Save(cfg)
     }
     
-    fmt.Printf("Email: %s\n", cfg.Email)
-    fmt.Printf("Device ID: %s\n", cfg.DeviceID)
-    fmt.Printf("Resource Mode: %s\n", cfg.ResourceMode)
+    dataDir, err := config.DataDir()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to open data directory: %v\n", err)
+        os.Exit(1)
+    }
+    
+    // Set once an update is installed; deferred first so it runs last, after
+    // the outbox, ledger and logs have been closed
+    var relaunch func() error
+    defer func() {
+        if relaunch == nil {
+            return
+        }
+        if err := relaunch(); err != nil {
+            fmt.Fprintf(os.Stderr, "Failed to restart into the update: %v\n", err)
+            os.Exit(1)
+        }
+    }()
+    
+    // Everything from here on is logged, with emails and credentials redacted
+    logCloser, err := setupLogging(cfg, dataDir)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Failed to set up logging: %v\n", err)
+        os.Exit(1)
+    }
+    defer logCloser.Close()
+    
+    slog.Info("IdleNet Agent starting", "version", version, "email", cfg.Email, "resource_mode", cfg.ResourceMode)
     
     // Initialize metrics tracker
     metricsTracker := metrics.NewTracker()
@@ -47,40 +95,195 @@ func main() {
     
     idleTime, err := idle.GetIdleTime()
     if err == nil {
-        fmt.Printf("Current idle time: %v\n", idleTime)
+        slog.Info("Current idle time", "idle", idleTime)
     }
     
     resourceMgr := resource.NewManager(cfg.ResourceMode)
     cpuLimit, memLimit := resourceMgr.GetLimits()
-    fmt.Printf("Resource limits: CPU=%d%%, Memory=%d%%\n", cpuLimit, memLimit)
+    slog.Info("Resource limits", "cpu_percent", cpuLimit, "memory_percent", memLimit)
     
-    apiClient := api.NewClient(cfg.APIBase, cfg.Email, cfg.DeviceID)
+    // Credentials live in the OS keyring when there is one, never in config.json
+    vault, err := secrets.Open(dataDir)
+    if err != nil {
+        logging.Fatal("Failed to open secrets store", "error", err)
+    }
+    if err := config.MigrateSecrets(cfg, vault); err != nil {
+        slog.Warn("Failed to migrate credentials", "error", err)
+    }
     
-    if !cfg.Registered {
-        fmt.Print("Registering with server... ")
-        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-        err := apiClient.Register(ctx, cfg.Referral, version)
-        cancel()
-        
-        if err != nil {
-            fmt.Printf("Failed: %v\n", err)
+    deviceKey, err := identity.LoadOrCreate(vault)
+    if err != nil {
+        logging.Fatal("Failed to load device key", "error", err)
+    }
+    slog.Info("Device key loaded", "fingerprint", deviceKey.Fingerprint())
+    
+    // Proxy, CA, client certificate and pinning settings apply to every connection
+    httpTransport, err := newHTTPTransport(cfg, vault)
+    if err != nil {
+        logging.Fatal("Invalid network settings", "error", err)
+    }
+    
+    apiClient := api.NewClient(cfg.APIBase, version, cfg.Email, cfg.DeviceID)
+    apiClient.SetHTTPTransport(httpTransport)
+    apiClient.SetDeviceKey(deviceKey.PrivateKey())
+    apiClient.SetTokenStore(secrets.TokenStore{Store: vault})
+    
+    // A bypass token given in the environment is remembered for later runs
+    if token := os.Getenv("IDLENET_BYPASS_TOKEN"); token != "" {
+        if err := vault.Set(secrets.BypassToken, token); err != nil {
+            slog.Warn("Failed to store bypass token", "error", err)
+        }
+        apiClient.SetBypassToken(token)
+    } else if token, err := vault.Get(secrets.BypassToken); err == nil {
+        apiClient.SetBypassToken(token)
+    }
+    
+    // The device's benchmark profile, if it's been measured, goes out with registration
+    benchmarks := newBenchmarker(apiClient, dataDir)
+    
+    // Re-register when the server hasn't seen this key yet, e.g. after upgrading from an unsigned agent
+    if !cfg.Registered || cfg.RegisteredKey != deviceKey.Fingerprint() {
+        if err := enroll(context.Background(), apiClient, cfg, deviceKey); err != nil {
+            slog.Error("Registration failed", "error", err)
         } else {
-            fmt.Println("Success!")
-            cfg.Registered = true
-            config.Save(cfg)
+            slog.Info("Registered with server")
         }
     }
     
     // jobExecutor temporarily disabled for testing    }
     
-    fmt.Println("========================================")
-    
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
     
+    // One job or benchmark runs at a time, outside the loop so heartbeats,
+    // cancellations and directives keep flowing while it does. A job holds
+    // the slot from before it's asked for, so an update drain counts it.
+    var busy atomic.Bool
+    var busyDone sync.WaitGroup
+    
+    // Updates download in the background and only apply between jobs
+    var updates *updater.Orchestrator
+    if updateMgr, err := updater.NewUpdateManager(version); err != nil {
+        slog.Warn("Auto-update disabled", "error", err)
+    } else {
+        updateMgr.SetHTTPTransport(httpTransport)
+        window, err := updater.ParseMaintenanceWindow(cfg.UpdateWindow)
+        if err != nil {
+            slog.Warn("Ignoring update window", "error", err)
+        }
+        busyJobs := func() int {
+            if busy.Load() {
+                return 1
+            }
+            return 0
+        }
+        updates = updater.NewOrchestrator(updateMgr, updater.OrchestratorOptions{Window: window},
+            busyJobs, idle.IsIdle)
+        go updates.Run(ctx)
+    }
+    
+    artifacts, err := cache.New(filepath.Join(dataDir, "cache"))
+    if err != nil {
+        slog.Warn("Artifact cache disabled", "error", err)
+    }
+    
+    // Opt-in Prometheus endpoint for operators watching a fleet
+    stats := startMetricsEndpoint(ctx, cfg.MetricsListen, artifacts, updates)
+    if stats != nil {
+        apiClient.SetObserver(stats.apiRequest)
+    }
+    
+    // Opt-in job traces, for seeing where a slow or failed job's time went
+    stopTracing := startTracing(cfg)
+    defer stopTracing()
+    
+    // Results and telemetry go through a durable outbox so nothing is lost while offline
+    // Results are sent straight away; telemetry is batched per outbox.DefaultBatch
+    out, err := outbox.Open(filepath.Join(dataDir, "outbox"), outbox.DefaultMaxBytes)
+    if err != nil {
+        logging.Fatal("Failed to open outbox", "error", err)
+    }
+    defer out.Close()
+    if pending := out.Len(); pending > 0 {
+        slog.Info("Outbox has records from a previous run waiting to be sent", "pending", pending)
+    }
+    outDone := make(chan struct{})
+    go func() {
+        defer close(outDone)
+        out.Run(ctx, deliverOutbox(apiClient), func() time.Duration {
+            return apiClient.Pace(30 * time.Second)
+        })
+    }()
+    
     sigChan := make(chan os.Signal, 1)
     signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
     
+    // Intervals, job types, ceilings and feature flags come from compiled
+    // defaults, the server's signed config, config.json and any admin policy
+    settings, err := remoteconfig.NewManager(dataDir, cfg.DeviceID, builtinSettings(), userSettings(cfg))
+    if err != nil {
+        logging.Fatal("Failed to load settings", "error", err)
+    }
+    // Retry sooner than the usual refresh if the server couldn't be asked
+    const configRetry = time.Minute
+    
+    if !remoteconfig.Enabled() {
+        slog.Info("Remote config off: this build has no key to verify it with")
+    }
+    configWait := configRetry
+    if _, err := refreshRemoteConfig(ctx, apiClient, settings); err != nil {
+        slog.Warn("Remote config unavailable", "error", err)
+    } else {
+        // Now that the server's config is in, it says how often to ask again
+        configWait = settings.RefreshInterval()
+    }
+    
+    // Earnings are estimated with the server's rate card, cached across restarts
+    metricsTracker.SetRateCard(metrics.LoadRateCard(dataDir))
+    rateCardWait := rateCardRefresh
+    if _, err := refreshRateCard(ctx, apiClient, metricsTracker, dataDir); err != nil {
+        slog.Warn("Rate card unavailable", "error", err)
+        rateCardWait = configRetry
+    }
+    
+    // Finished jobs are kept in detail for a while, then only as monthly totals
+    history, err := openHistory(cfg, dataDir)
+    if err != nil {
+        logging.Fatal("Failed to open job history", "error", err)
+    }
+    metricsTracker.SetHistory(history)
+    
+    // The ledger keeps every job's estimate and the server's credit for it across restarts
+    book, existed, err := ledger.Open(dataDir)
+    if err != nil {
+        logging.Fatal("Failed to open earnings ledger", "error", err)
+    }
+    defer book.Close()
+    if !existed {
+        if n, err := book.ImportJobFiles(history.Dir()); err != nil {
+            slog.Warn("Job history only partly imported into the ledger", "error", err)
+        } else if n > 0 {
+            slog.Info("Ledger imported jobs from earlier history", "jobs", n)
+        }
+    }
+    ledgerWait := ledgerReconcile
+    if _, err := reconcileLedger(ctx, apiClient, book); err != nil {
+        slog.Warn("Credits unavailable", "error", err)
+        ledgerWait = configRetry
+    }
+    var review reviewList
+    
+    // Compressing and rolling up only after the ledger has seen every job
+    maintainHistory(history)
+    
+    // While the push channel is up the server tells us about work, so polling
+    // is only a safety net for missed offers
+    const pushedJobInterval = 2 * time.Minute
+    
+    // Started by applySettings below if the "push" feature is on
+    jobs := newRunningJobs()
+    push := newPushLink(ctx, apiClient, jobs)
+    
     heartbeatTicker := time.NewTicker(30 * time.Second)
     defer heartbeatTicker.Stop()
     
@@ -93,87 +296,368 @@ func main() {
     metricsTicker := time.NewTicker(5 * time.Minute)
     defer metricsTicker.Stop()
     
-    fmt.Println("Agent running. Press Ctrl+C to stop.")
+    configTicker := time.NewTicker(configWait)
+    defer configTicker.Stop()
+    
+    rateCardTicker := time.NewTicker(rateCardWait)
+    defer rateCardTicker.Stop()
+    
+    ledgerTicker := time.NewTicker(ledgerWait)
+    defer ledgerTicker.Stop()
+    
+    historyTicker := time.NewTicker(historyMaintain)
+    defer historyTicker.Stop()
+    
+    benchmarkTicker := time.NewTicker(benchmarkCheck)
+    defer benchmarkTicker.Stop()
+    
+    ctl := &controls{
+        heartbeat:   heartbeatTicker,
+        interval:    30 * time.Second,
+        jobTicker:   jobTicker,
+        jobInterval: 20 * time.Second,
+        metrics:     metricsTicker,
+        artifacts:   artifacts,
+        updates:     updates,
+        tracker:     metricsTracker,
+        resources:   resourceMgr,
+        settings:    settings,
+        push:        push,
+    }
+    ctl.applySettings()
+    stats.state(cpuLimit, memLimit, ctl.isPaused())
+    describeSettings(ctl.current)
+    describeRateCard(metricsTracker.RateCard())
+    describeLedger(book)
+    review.report(book)
+    
+    // runJob executes a claimed job and records its metrics, ledger entry and result
+    runJob := func(traceCtx context.Context, jobSpan oteltrace.Span, job *api.Job, jobLog *slog.Logger) {
+        defer jobSpan.End()
+    
+        // Execute job, timed on the monotonic clock
+        runCtx, run := tracing.Tracer().Start(traceCtx, "job.execute")
+        span := clock.Start()
+        res := jobs.run(runCtx, job.ID, job.Type, job.Args, job.MaxSeconds)
+        timing := span.Stop()
+        run.SetAttributes(attribute.String("job.status", res.Status),
+            attribute.Float64("job.suspended_seconds", timing.Suspended.Seconds()),
+            attribute.Float64("job.cpu_seconds", res.Usage.CPU().Seconds()),
+            attribute.Int("job.peak_memory_mb", res.Usage.PeakMemoryMB()))
+        if res.Status != "ok" {
+            tracing.Fail(run, res.Error)
+        }
+        run.End()
+        jobSpan.SetAttributes(attribute.String("job.status", res.Status))
+        if timing.Suspended > 0 || timing.Jump != 0 {
+            jobLog.Info("Job spanned a suspend or clock step",
+                "suspended", timing.Suspended.Round(time.Second), "clock_step", timing.Jump.Round(time.Second), "credited", timing.Elapsed.Round(time.Second))
+        }
+    
+        // Metrics, ledger and outbox are local disk; a span shows when they're slow or fail
+        _, record := tracing.Tracer().Start(traceCtx, "job.record")
+        var recordErr error
+    
+        jobMetrics := &metrics.JobMetrics{
+            JobID:            job.ID,
+            JobType:          job.Type,
+            DeviceID:         cfg.DeviceID,
+            StartTime:        timing.Started,
+            EndTime:          timing.Finished,
+            Elapsed:          timing.Elapsed,
+            SuspendedSeconds: timing.Suspended.Seconds(),
+        }
+        jobMetrics.Success = res.Status == "ok"
+        jobMetrics.ErrorMessage = res.Error
+        jobMetrics.UserCPUSeconds = res.Usage.UserCPU.Seconds()
+        jobMetrics.SystemCPUSeconds = res.Usage.SystemCPU.Seconds()
+        jobMetrics.CPUSeconds = res.Usage.CPU().Seconds()
+        jobMetrics.MemoryMB = res.Usage.PeakMemoryMB()
+        jobMetrics.ReadBytes = res.Usage.ReadBytes
+        jobMetrics.WriteBytes = res.Usage.WriteBytes
+        jobMetrics.NetRxBytes = res.Usage.NetRxBytes
+        jobMetrics.NetTxBytes = res.Usage.NetTxBytes
+        jobMetrics.UsageSource = res.Usage.Source
+    
+        if err := metricsTracker.RecordJobComplete(jobMetrics); err != nil {
+            jobLog.Error("Job metrics not saved", "error", err)
+            recordErr = err
+        }
+        stats.job(job.Type, res.Status, timing.Elapsed, jobMetrics.CPUSeconds)
+        err := book.Record(ledger.Entry{
+            JobID:      job.ID,
+            JobType:    job.Type,
+            Status:     res.Status,
+            Finished:   timing.Finished,
+            CPUSeconds: jobMetrics.CPUSeconds,
+            Units:      jobMetrics.CreditedUnits,
+            Estimate:   jobMetrics.Earnings,
+            RateCard:   jobMetrics.RateCardVersion,
+        })
+        if err != nil {
+            jobLog.Error("Job not recorded in the ledger", "error", err)
+            recordErr = err
+        }
+    
+        result := &api.JobResult{
+            JobID:            job.ID,
+            Status:           res.Status,
+            Error:            res.Error,
+            StartedAt:        timing.Started,
+            FinishedAt:       timing.Finished,
+            ElapsedSeconds:   timing.Elapsed.Seconds(),
+            SuspendedSeconds: timing.Suspended.Seconds(),
+            ClockJumpSeconds: timing.Jump.Seconds(),
+            CPUSeconds:       jobMetrics.CPUSeconds,
+            UserCPUSeconds:   jobMetrics.UserCPUSeconds,
+            SystemCPUSeconds: jobMetrics.SystemCPUSeconds,
+            MemoryMB:         jobMetrics.MemoryMB,
+            ReadBytes:        jobMetrics.ReadBytes,
+            WriteBytes:       jobMetrics.WriteBytes,
+            NetRxBytes:       jobMetrics.NetRxBytes,
+            NetTxBytes:       jobMetrics.NetTxBytes,
+            UsageSource:      jobMetrics.UsageSource,
+            RateCardVersion:  jobMetrics.RateCardVersion,
+        }
+        result.TraceParent, result.TraceState = tracing.Inject(traceCtx)
+        stampServerTimes(apiClient, result)
+    
+        err = queueJob(out, result, jobMetrics)
+        if err != nil {
+            recordErr = err
+        }
+        tracing.Finish(record, recordErr)
+    
+        if err != nil {
+            jobLog.Error("Job result not saved", "error", err)
+        } else {
+            jobLog.Info("Job finished", "status", res.Status, "wall_seconds", round(jobMetrics.ElapsedSeconds, 1),
+                "cpu_seconds", round(jobMetrics.CPUSeconds, 1), "peak_mb", jobMetrics.MemoryMB, "units", round(jobMetrics.CreditedUnits, 2),
+                "est_earnings", round(jobMetrics.Earnings, 4))
+        }
+    }
+    
+    // checkForJob claims the next job and starts it, if we're able to take one
+    checkForJob := func() {
+        // Claim the slot before AcceptingJobs: a drain stops accepting before
+        // it counts jobs, so either it sees this claim or we see the drain
+        if !busy.CompareAndSwap(false, true) {
+            return
+        }
+        started := false
+        defer func() {
+            if !started {
+                busy.Store(false)
+            }
+        }()
+    
+        if !resourceMgr.ShouldRunJob() {
+            return
+        }
+        if updates != nil && !updates.AcceptingJobs() {
+            return
+        }
+        if ctl.isPaused() {
+            return
+        }
+    
+        pollCtx, poll := tracing.Tracer().Start(ctx, "job.poll")
+        jobCtx, jobCancel := context.WithTimeout(pollCtx, 5*time.Second)
+        job, err := apiClient.GetNextJob(jobCtx)
+        jobCancel()
+        poll.SetAttributes(attribute.Bool("job.offered", job != nil))
+        tracing.Finish(poll, err)
+    
+        if err != nil {
+            slog.Warn("Job check failed", "error", err)
+            reenrollIfRevoked(ctx, err, apiClient, cfg, deviceKey)
+        } else if job != nil {
+            jobLog := slog.With("job_id", job.ID, "job_type", job.Type)
+    
+            // The job's span continues the server's trace from the offer, linked to our poll
+            traceCtx, jobSpan := tracing.Tracer().Start(tracing.Extract(ctx, job.TraceParent, job.TraceState), "job",
+                oteltrace.WithLinks(oteltrace.LinkFromContext(pollCtx)),
+                oteltrace.WithAttributes(attribute.String("job.id", job.ID), attribute.String("job.type", job.Type)))
+    
+            if ok, reason := ctl.jobAllowed(job); !ok {
+                jobLog.Info("Skipping job", "reason", reason)
+                jobSpan.SetAttributes(attribute.String("job.status", "skipped"), attribute.String("job.skip_reason", reason))
+                now := time.Now().Round(0)
+                result := &api.JobResult{JobID: job.ID, Status: "skipped", Error: reason, StartedAt: now, FinishedAt: now}
+                result.TraceParent, result.TraceState = tracing.Inject(traceCtx)
+                stampServerTimes(apiClient, result)
+                err := out.Add(outbox.KindResult, "result:"+job.ID, result)
+                if err != nil {
+                    jobLog.Error("Job result not saved", "error", err)
+                }
+                jobSpan.End()
+                return
+            }
+    
+            jobLog.Info("Got job")
+            metricsTracker.RecordJobStart(job.ID)
+    
+            started = true
+            busyDone.Add(1)
+            go func() {
+                defer busyDone.Done()
+                defer busy.Store(false)
+                runJob(traceCtx, jobSpan, job, jobLog)
+            }()
+        }
+    }
+    
+    var skew skewWatch
+    
+    // Nil without an updater, which blocks forever in the select below
+    var restart <-chan struct{}
+    if updates != nil {
+        restart = updates.Restart()
+    }
+    
+    slog.Info("Agent running. Press Ctrl+C to stop.")
     
     for {
         select {
         case <-ctx.Done():
-            fmt.Println("\nShutting down...")
-            completed, failed, cpuTime, earnings := metricsTracker.GetStats()
-            fmt.Printf("Session stats: %d completed, %d failed, CPU time: %v, Earnings: $%.4f\n", 
-                completed, failed, cpuTime, earnings)
+            slog.Info("Shutting down")
+            // A running job or benchmark sees the same cancellation; wait for it to wrap up
+            busyDone.Wait()
+            <-outDone
+            completed, failed, _, _ := metricsTracker.GetStats()
+            usage := metricsTracker.Usage()
+            slog.Info("Session stats", "completed", completed, "failed", failed,
+                "wall_time", usage.WallTime.Round(time.Second), "cpu_time", usage.CPUTime.Round(time.Second),
+                "units", round(usage.CreditedUnits, 2), "est_earnings", round(usage.Earnings, 4))
             return
-            
+    
         case <-sigChan:
-            fmt.Println("\nShutdown signal received")
+            slog.Info("Shutdown signal received")
+            cancel()
+    
+        case <-restart:
+            // Shut down as for a signal, then exec the new binary on the way out
+            slog.Info("Update installed, restarting")
+            relaunch = updates.Relaunch
             cancel()
-            
+    
         case <-heartbeatTicker.C:
-            timestamp := time.Now().Format("15:04:05")
             beatCtx, beatCancel := context.WithTimeout(ctx, 5*time.Second)
-            err := apiClient.Beat(beatCtx)
+            response, err := apiClient.Beat(beatCtx, buildHeartbeat(resourceMgr, metricsTracker, ctl, dataDir))
             beatCancel()
-            
+            stats.heartbeat(err)
+    
             if err != nil {
-                fmt.Printf("[%s] Heartbeat failed: %v\n", timestamp, err)
+                slog.Warn("Heartbeat failed", "error", err)
+                reenrollIfRevoked(ctx, err, apiClient, cfg, deviceKey)
             } else {
-                fmt.Printf("[%s] Heartbeat OK\n", timestamp)
+                slog.Debug("Heartbeat OK")
+                skew.check(apiClient)
+                for _, directive := range response.Directives {
+                    ctl.apply(directive)
+                }
             }
-            
+    
+            // Back off while the API is unhealthy instead of beating in lockstep
+            heartbeatTicker.Reset(apiClient.Pace(ctl.interval))
+    
         case <-jobTicker.C:
-            if !resourceMgr.ShouldRunJob() {
-                continue
+            checkForJob()
+            if push.connected() {
+                jobTicker.Reset(apiClient.Pace(pushedJobInterval))
+            } else {
+                jobTicker.Reset(apiClient.Pace(ctl.jobInterval))
             }
-            
-            timestamp := time.Now().Format("15:04:05")
-            
-            jobCtx, jobCancel := context.WithTimeout(ctx, 5*time.Second)
-            job, err := apiClient.GetNextJob(jobCtx)
-            jobCancel()
-            
-            if err != nil {
-                fmt.Printf("[%s] Job check failed: %v\n", timestamp, err)
-            } else if job != nil {
-                fmt.Printf("[%s] Got job %s\n", timestamp, job.ID)
-                metricsTracker.RecordJobStart(job.ID)
-                
-                // Execute job
-                jobMetrics := &metrics.JobMetrics{
-                    JobID:     job.ID,
-                    DeviceID:  cfg.DeviceID,
-                    StartTime: time.Now(),
+    
+        case event := <-push.events:
+            switch event.Type {
+            case api.EventJobOffer:
+                checkForJob()
+            case api.EventDirective:
+                if event.Directive != nil {
+                    ctl.apply(*event.Directive)
                 }
-                
-                // Simulate job execution (replace with actual execution)
-                time.Sleep(2 * time.Second)
-                jobMetrics.EndTime = time.Now()
-                jobMetrics.Success = true
-                jobMetrics.CPUSeconds = 2.0
-                jobMetrics.MemoryMB = 256
-                
-                metricsTracker.RecordJobComplete(jobMetrics)
-                
-                fmt.Printf("[%s] Job %s completed, earned: $%.4f\n", 
-                    timestamp, job.ID, jobMetrics.Earnings)
             }
-            
+    
         case <-statusTicker.C:
-            timestamp := time.Now().Format("15:04:05")
             idleTime, _ := idle.GetIdleTime()
             cpuLimit, memLimit := resourceMgr.GetLimits()
-            
+            stats.state(cpuLimit, memLimit, ctl.isPaused())
+    
             currentMetrics := metricsTracker.GetCurrentMetrics()
-            fmt.Printf("[%s] Status: Idle=%v, Limits=CPU:%d%% MEM:%d%%, Jobs=%d, Earnings=$%.4f\n", 
-                timestamp, idleTime, cpuLimit, memLimit, 
-                currentMetrics.TotalJobs, currentMetrics.Earnings)
-                
+            slog.Info("Status", "idle", idleTime.Round(time.Second), "cpu_percent", cpuLimit, "memory_percent", memLimit,
+                "jobs", currentMetrics.TotalJobs, "est_earnings", round(currentMetrics.Earnings, 4))
+    
+        case <-configTicker.C:
+            changed, err := refreshRemoteConfig(ctx, apiClient, settings)
+            if err != nil {
+                slog.Warn("Remote config refresh failed", "error", err)
+                reenrollIfRevoked(ctx, err, apiClient, cfg, deviceKey)
+                configTicker.Reset(apiClient.Pace(configRetry))
+                continue
+            }
+            if changed {
+                slog.Info("Remote config applied", "version", settings.Version())
+                ctl.applySettings()
+                describeSettings(ctl.current)
+            }
+            configTicker.Reset(settings.RefreshInterval())
+    
+        case <-rateCardTicker.C:
+            changed, err := refreshRateCard(ctx, apiClient, metricsTracker, dataDir)
+            if err != nil {
+                slog.Warn("Rate card refresh failed", "error", err)
+                rateCardTicker.Reset(apiClient.Pace(configRetry))
+                continue
+            }
+            if changed {
+                describeRateCard(metricsTracker.RateCard())
+            }
+            rateCardTicker.Reset(rateCardRefresh)
+    
+        case <-ledgerTicker.C:
+            changed, err := reconcileLedger(ctx, apiClient, book)
+            if err != nil {
+                slog.Warn("Credits fetch failed", "error", err)
+                ledgerTicker.Reset(apiClient.Pace(configRetry))
+                continue
+            }
+            if changed > 0 {
+                describeLedger(book)
+            }
+            review.report(book)
+            ledgerTicker.Reset(ledgerReconcile)
+    
+        case <-historyTicker.C:
+            maintainHistory(history)
+    
+        case <-benchmarkTicker.C:
+            // Takes the job slot, so a benchmark never shares the CPU with a job
+            if resourceMgr.ShouldRunJob() && !ctl.isPaused() && !busy.Load() {
+                busy.Store(true)
+                busyDone.Add(1)
+                go func() {
+                    defer busyDone.Done()
+                    defer busy.Store(false)
+                    benchmarks.maybeRun(ctx)
+                }()
+            }
+    
         case <-metricsTicker.C:
             // Sample performance and check system health
             sample := perfMonitor.Sample()
             if !perfMonitor.IsSystemHealthy() {
-                fmt.Println("Warning: System performance impact detected")
+                slog.Warn("System performance impact detected")
+            }
+    
+            data, _ := json.Marshal(sample)
+            key := fmt.Sprintf("perf:%d", sample.Timestamp.UnixNano())
+            err := out.Add(outbox.KindTelemetry, key, api.TelemetryEvent{
+                ID: key, Kind: "performance", Timestamp: sample.Timestamp, Data: data,
+            })
+            if err != nil {
+                slog.Error("Telemetry not saved", "error", err)
             }
-            _ = sample // Use sample data as needed
         }
     }
-}
\ No newline at end of file
+}
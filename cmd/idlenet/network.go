@@ -0,0 +1,43 @@
+package main
+
+import (
+    "net/http"
+    "net/url"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/config"
+    "github.com/ifruncillo/idlenet-agent/internal/netconf"
+    "github.com/ifruncillo/idlenet-agent/internal/secrets"
+)
+
+// newHTTPTransport builds the transport shared by the API client and the
+// updater from the network settings in cfg
+func newHTTPTransport(cfg *config.Config, vault secrets.Store) (*http.Transport, error) {
+    return netconf.NewTransport(netconf.Options{
+        Proxy:      proxyWithPassword(cfg.Proxy, vault),
+        NoProxy:    cfg.NoProxy,
+        CABundles:  cfg.CABundles,
+        ClientCert: cfg.ClientCert,
+        ClientKey:  cfg.ClientKey,
+        PinHost:    netconf.HostOf(cfg.APIBase),
+        Pins:       cfg.APIPins,
+    })
+}
+
+// proxyWithPassword fills in the proxy password kept in the secrets store,
+// since config.json only carries the username
+func proxyWithPassword(proxy string, vault secrets.Store) string {
+    parsed, err := url.Parse(proxy)
+    if err != nil || parsed.User == nil {
+        return proxy
+    }
+    if _, set := parsed.User.Password(); set {
+        return proxy
+    }
+    
+    password, err := vault.Get(secrets.ProxyPassword)
+    if err != nil {
+        return proxy
+    }
+    parsed.User = url.UserPassword(parsed.User.Username(), password)
+    return parsed.String()
+}
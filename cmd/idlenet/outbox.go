@@ -0,0 +1,104 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "time"
+    
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/api"
+    "github.com/ifruncillo/idlenet-agent/internal/outbox"
+    "github.com/ifruncillo/idlenet-agent/internal/tracing"
+)
+
+// rejectedResultRetry is how long a result the server turned away waits
+// before it's offered again
+const rejectedResultRetry = 30 * time.Minute
+
+// deliverOutbox sends one result, or one batch of telemetry, to the API
+func deliverOutbox(apiClient *api.Client) func(context.Context, []outbox.Entry) error {
+    return func(ctx context.Context, batch []outbox.Entry) error {
+        sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+        defer cancel()
+    
+        var err error
+        switch batch[0].Kind {
+        case outbox.KindResult:
+            var result api.JobResult
+            if err := json.Unmarshal(batch[0].Payload, &result); err != nil {
+                return outbox.Permanent(err)
+            }
+            // Uploaded under the job's span, so the server's trace picks the result back up
+            uploadCtx, upload := tracing.Tracer().Start(tracing.Extract(sendCtx, result.TraceParent, result.TraceState),
+                "job.upload", trace.WithAttributes(attribute.String("job.id", result.JobID)))
+            err = apiClient.SubmitResult(uploadCtx, &result)
+            tracing.Finish(upload, err)
+    
+        case outbox.KindTelemetry:
+            events := make([]api.TelemetryEvent, 0, len(batch))
+            for _, entry := range batch {
+                var event api.TelemetryEvent
+                if err := json.Unmarshal(entry.Payload, &event); err != nil {
+                    // Don't let one bad record take the rest of the batch with it
+                    slog.Warn("Outbox dropping unreadable telemetry", "key", entry.Key, "error", err)
+                    continue
+                }
+                events = append(events, event)
+            }
+            if len(events) == 0 {
+                return nil
+            }
+            err = apiClient.SendTelemetry(sendCtx, events)
+    
+        default:
+            return outbox.Permanent(fmt.Errorf("unknown outbox entry kind %q", batch[0].Kind))
+        }
+    
+        var apiErr *api.APIError
+        if !errors.As(err, &apiErr) || apiErr.Temporary() {
+            return err
+        }
+        switch apiErr.StatusCode {
+        case http.StatusConflict:
+            // Already stored, e.g. our earlier attempt landed but the response didn't
+            return nil
+        case http.StatusUnauthorized, http.StatusForbidden:
+            // Fixed by re-enrolling, so keep the entry
+            return err
+        }
+        if apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 {
+            // Losing telemetry is fine, but a result is someone's pay: keep it
+            // in case the server was wrong and ask again much later
+            if batch[0].Kind == outbox.KindTelemetry {
+                return outbox.Permanent(err)
+            }
+            return outbox.Hold(err, rejectedResultRetry)
+        }
+        return err
+    }
+}
+
+// queueJob records a finished job's result and metrics for delivery
+func queueJob(out *outbox.Outbox, result *api.JobResult, jobMetrics interface{}) error {
+    if err := out.Add(outbox.KindResult, "result:"+result.JobID, result); err != nil {
+        return err
+    }
+    
+    data, err := json.Marshal(jobMetrics)
+    if err != nil {
+        return err
+    }
+    key := "job:" + result.JobID
+    return out.Add(outbox.KindTelemetry, key, api.TelemetryEvent{
+        ID:        key,
+        Kind:      "job",
+        Timestamp: result.FinishedAt,
+        Data:      data,
+    })
+}
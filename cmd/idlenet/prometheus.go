@@ -0,0 +1,162 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "strconv"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/cache"
+    "github.com/ifruncillo/idlenet-agent/internal/idle"
+    "github.com/ifruncillo/idlenet-agent/internal/prom"
+    "github.com/ifruncillo/idlenet-agent/internal/updater"
+)
+
+// jobBuckets cover jobs from a few seconds to a couple of hours
+var jobBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200}
+
+// agentMetrics is what the opt-in /metrics endpoint reports
+// A nil *agentMetrics ignores everything, so callers don't check whether it's on
+type agentMetrics struct {
+    registry    *prom.Registry
+    jobs        *prom.CounterVec
+    jobDuration *prom.HistogramVec
+    jobCPU      *prom.CounterVec
+    limits      *prom.GaugeVec
+    paused      *prom.GaugeVec
+    heartbeats  *prom.CounterVec
+    apiLatency  *prom.HistogramVec
+    updateState *prom.GaugeVec
+}
+
+// startMetricsEndpoint serves /metrics on listen until ctx is done
+// It returns nil without listening when listen is empty
+func startMetricsEndpoint(ctx context.Context, listen string, artifacts *cache.Cache, updates *updater.Orchestrator) *agentMetrics {
+    if listen == "" {
+        return nil
+    }
+    addr, loopback, err := prom.ListenAddr(listen)
+    if err != nil {
+        slog.Warn("Metrics endpoint disabled", "error", err)
+        return nil
+    }
+    if !loopback {
+        slog.Warn("Metrics endpoint is reachable from other machines", "addr", addr)
+    }
+    
+    m := newAgentMetrics(artifacts, updates)
+    go func() {
+        if err := prom.Serve(ctx, addr, m.registry); err != nil {
+            slog.Error("Metrics endpoint stopped", "error", err)
+        }
+    }()
+    slog.Info("Metrics endpoint listening", "url", "http://"+addr+"/metrics")
+    return m
+}
+
+func newAgentMetrics(artifacts *cache.Cache, updates *updater.Orchestrator) *agentMetrics {
+    r := prom.NewRegistry()
+    m := &agentMetrics{
+        registry: r,
+        jobs: r.Counter("idlenet_jobs_total",
+            "Jobs finished, by type and status", "type", "status"),
+        jobDuration: r.Histogram("idlenet_job_duration_seconds",
+            "Wall time of finished jobs, excluding suspend", jobBuckets, "type"),
+        jobCPU: r.Counter("idlenet_job_cpu_seconds_total",
+            "CPU time used by finished jobs", "type"),
+        limits: r.Gauge("idlenet_resource_limit_percent",
+            "Current resource limits from the resource mode", "resource"),
+        paused: r.Gauge("idlenet_paused",
+            "1 while the server has paused the agent"),
+        heartbeats: r.Counter("idlenet_heartbeats_total",
+            "Heartbeats sent, by result", "result"),
+        apiLatency: r.Histogram("idlenet_api_request_duration_seconds",
+            "API request attempts, by route and status code, 0 for no response", prom.DefBuckets, "method", "route", "code"),
+    }
+    
+    r.GaugeFunc("idlenet_user_idle_seconds", "How long since the user last gave input", func() float64 {
+        idleTime, err := idle.GetIdleTime()
+        if err != nil {
+            return 0
+        }
+        return idleTime.Seconds()
+    })
+    
+    if artifacts != nil {
+        r.CounterFunc("idlenet_cache_hits_total", "Artifact cache lookups that found the artifact", func() float64 {
+            hits, _ := artifacts.Stats()
+            return float64(hits)
+        })
+        r.CounterFunc("idlenet_cache_misses_total", "Artifact cache lookups that had to download", func() float64 {
+            _, misses := artifacts.Stats()
+            return float64(misses)
+        })
+        r.GaugeFunc("idlenet_cache_bytes", "Size of the artifact cache", func() float64 {
+            entries, _ := artifacts.Entries()
+            var size int64
+            for _, entry := range entries {
+                size += entry.Size
+            }
+            return float64(size)
+        })
+    }
+    
+    if updates != nil {
+        m.updateState = r.Gauge("idlenet_update_state",
+            "1 for the auto-updater's current state", "state", "available_version")
+        r.GaugeFunc("idlenet_update_progress_ratio", "Download progress of a pending update", func() float64 {
+            return updates.Status().Progress
+        })
+        r.OnScrape(func() {
+            status := updates.Status()
+            m.updateState.Reset()
+            m.updateState.Set(1, string(status.State), status.AvailableVersion)
+        })
+    }
+    
+    return m
+}
+
+// job records a finished job
+func (m *agentMetrics) job(jobType, status string, elapsed time.Duration, cpuSeconds float64) {
+    if m == nil {
+        return
+    }
+    m.jobs.Inc(jobType, status)
+    m.jobDuration.Observe(elapsed.Seconds(), jobType)
+    m.jobCPU.Add(cpuSeconds, jobType)
+}
+
+// heartbeat records whether a heartbeat got through
+func (m *agentMetrics) heartbeat(err error) {
+    if m == nil {
+        return
+    }
+    if err != nil {
+        m.heartbeats.Inc("error")
+    } else {
+        m.heartbeats.Inc("ok")
+    }
+}
+
+// apiRequest is the api.Observer for request latency
+func (m *agentMetrics) apiRequest(method, route string, status int, elapsed time.Duration) {
+    if m == nil {
+        return
+    }
+    m.apiLatency.Observe(elapsed.Seconds(), method, route, strconv.Itoa(status))
+}
+
+// state records what's read from the main loop, where the resource manager lives
+func (m *agentMetrics) state(cpuLimit, memLimit int, paused bool) {
+    if m == nil {
+        return
+    }
+    m.limits.Set(float64(cpuLimit), "cpu")
+    m.limits.Set(float64(memLimit), "memory")
+    if paused {
+        m.paused.Set(1)
+    } else {
+        m.paused.Set(0)
+    }
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/api"
+)
+
+// pushLink keeps the push channel up while the "push" feature is on, so
+// remote config can turn it off or back on without a restart
+// Only the main loop touches it
+type pushLink struct {
+    ctx       context.Context
+    apiClient *api.Client
+    jobs      *runningJobs
+    events    chan api.Event // Offers and directives for the main loop
+    
+    channel *api.PushChannel
+    stop    context.CancelFunc
+}
+
+func newPushLink(ctx context.Context, apiClient *api.Client, jobs *runningJobs) *pushLink {
+    return &pushLink{
+        ctx:       ctx,
+        apiClient: apiClient,
+        jobs:      jobs,
+        events:    make(chan api.Event, 16),
+    }
+}
+
+// set starts or stops the channel to match the feature flag
+func (p *pushLink) set(enabled bool) {
+    if enabled == (p.channel != nil) {
+        return
+    }
+    
+    if !enabled {
+        p.stop()
+        p.channel, p.stop = nil, nil
+        slog.Info("Push channel turned off; polling for jobs")
+        return
+    }
+    
+    ctx, stop := context.WithCancel(p.ctx)
+    channel := p.apiClient.NewPushChannel()
+    p.channel, p.stop = channel, stop
+    go channel.Run(ctx)
+    go p.forward(ctx, channel)
+}
+
+// forward sends cancellations straight to the running job and everything
+// else to the main loop
+func (p *pushLink) forward(ctx context.Context, channel *api.PushChannel) {
+    for {
+        var event api.Event
+        select {
+        case event = <-channel.Events():
+        case <-ctx.Done():
+            return
+        }
+    
+        if event.Type == api.EventJobCancel {
+            if p.jobs.cancel(event.JobID) {
+                slog.Info("Server cancelled job", "job_id", event.JobID)
+            }
+            continue
+        }
+        select {
+        case p.events <- event:
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+// connected reports whether the server can reach us without waiting for a poll
+func (p *pushLink) connected() bool {
+    return p.channel != nil && p.channel.Connected()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/api"
+    "github.com/ifruncillo/idlenet-agent/internal/config"
+    "github.com/ifruncillo/idlenet-agent/internal/metrics"
+    "github.com/ifruncillo/idlenet-agent/internal/remoteconfig"
+    "github.com/ifruncillo/idlenet-agent/internal/runner"
+)
+
+// builtinSettings are the compiled-in defaults that remote config can change
+func builtinSettings() remoteconfig.Values {
+    return remoteconfig.Values{
+        HeartbeatSeconds:     30,
+        JobPollSeconds:       20,
+        MetricsSeconds:       300,
+        EarningsPerCPUSecond: metrics.DefaultEarningsRate,
+        JobTypes:             runner.SupportedTypes(),
+        Features:             map[string]bool{"push": true},
+    }
+}
+
+// userSettings are the values from config.json that take precedence over
+// the server's defaults unless the server or an admin policy locks them
+func userSettings(cfg *config.Config) remoteconfig.Values {
+    return remoteconfig.Values{
+        MaxCPUPercent: cfg.MaxCPUPercent,
+        MaxMemoryMB:   cfg.MaxMemoryMB,
+    }
+}
+
+// refreshRemoteConfig fetches the server's config and reports whether it changed
+func refreshRemoteConfig(ctx context.Context, apiClient *api.Client, settings *remoteconfig.Manager) (bool, error) {
+    if !remoteconfig.Enabled() {
+        return false, nil
+    }
+    
+    fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    
+    envelope, err := apiClient.GetRemoteConfig(fetchCtx)
+    if err != nil || envelope == nil {
+        return false, err
+    }
+    
+    before := settings.Version()
+    if err := settings.Apply(envelope); err != nil {
+        return false, err
+    }
+    return settings.Version() != before, nil
+}
+
+// rateCardRefresh is how often to check for a new rate card
+const rateCardRefresh = 6 * time.Hour
+
+// refreshRateCard fetches the server's rate card, caching and applying it if
+// it's newer than the one in use, and reports whether it changed
+func refreshRateCard(ctx context.Context, apiClient *api.Client, tracker *metrics.Tracker, dataDir string) (bool, error) {
+    fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    
+    data, err := apiClient.GetRateCard(fetchCtx)
+    if err != nil || data == nil {
+        return false, err
+    }
+    card, err := metrics.ParseRateCard(data)
+    if err != nil {
+        return false, err
+    }
+    if card.Version <= tracker.RateCard().Version {
+        return false, nil
+    }
+    
+    if err := metrics.SaveRateCard(dataDir, card); err != nil {
+        slog.Warn("Rate card not cached", "error", err)
+    }
+    tracker.SetRateCard(card)
+    return true, nil
+}
+
+// describeRateCard prints the rate card estimates are made with
+func describeRateCard(card metrics.RateCard) {
+    source := fmt.Sprintf("v%d", card.Version)
+    switch card.Version {
+    case 0:
+        source = "built-in until the server publishes one"
+    case metrics.OverrideRateCardVersion:
+        source = "built-in with the rate from remote config"
+    }
+    slog.Info("Rate card in use; earnings shown are estimates", "rate_card", source,
+        "per_cpu_second", card.PerCPUSecond, "per_gb_hour", card.PerGBHour, "currency", card.Currency)
+}
+
+// describeSettings prints the effective value of each setting and where it came from
+func describeSettings(eff remoteconfig.Effective) {
+    slog.Info("Settings",
+        "heartbeat", fmt.Sprintf("%ds (%s)", eff.HeartbeatSeconds, eff.Sources["heartbeat_seconds"]),
+        "jobs", fmt.Sprintf("%ds (%s)", eff.JobPollSeconds, eff.Sources["job_poll_seconds"]),
+        "metrics", fmt.Sprintf("%ds (%s)", eff.MetricsSeconds, eff.Sources["metrics_seconds"]),
+        "types", fmt.Sprintf("%v (%s)", eff.JobTypes, eff.Sources["job_types"]))
+    if eff.MaxCPUPercent > 0 || eff.MaxMemoryMB > 0 {
+        slog.Info("Settings",
+            "cpu_ceiling", fmt.Sprintf("%d%% (%s)", eff.MaxCPUPercent, eff.Sources["max_cpu_percent"]),
+            "job_memory_ceiling", fmt.Sprintf("%dMB (%s)", eff.MaxMemoryMB, eff.Sources["max_memory_mb"]))
+    }
+}
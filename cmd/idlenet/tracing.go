@@ -0,0 +1,36 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/config"
+    "github.com/ifruncillo/idlenet-agent/internal/tracing"
+)
+
+// startTracing exports job spans to the configured collector, if any
+// The returned stop flushes spans still waiting to go out
+func startTracing(cfg *config.Config) (stop func()) {
+    shutdown, err := tracing.Setup(tracing.Options{
+        Endpoint: cfg.TraceEndpoint,
+        Version:  version,
+        DeviceID: cfg.DeviceID,
+    })
+    if err != nil {
+        slog.Warn("Tracing disabled", "error", err)
+        return func() {}
+    }
+    if cfg.TraceEndpoint != "" {
+        endpoint, _ := tracing.EndpointURL(cfg.TraceEndpoint)
+        slog.Info("Exporting job traces", "endpoint", endpoint)
+    }
+    
+    return func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        if err := shutdown(ctx); err != nil {
+            slog.Warn("Some job traces were not exported", "error", err)
+        }
+    }
+}
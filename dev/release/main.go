@@ -0,0 +1,176 @@
+// Command release writes the signed manifest.json that lets agents verify a
+// release and update to it with a small patch instead of the full binary
+//
+// In CI, after the binaries and any zstd patches are in dist:
+//
+//	RELEASE_SIGNING_KEY=... go run ./dev/release -version v1.2.0 -dist dist -previous v1.1.0 -previous-dir previous
+//
+// Patches are found by name, <asset>.from-<previous>.zst, and made with
+// `zstd --patch-from=previous/<asset> dist/<asset>`. To make a key pair:
+//
+//	go run ./dev/release -keygen
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ifruncillo/idlenet-agent/internal/updater"
+)
+
+func main() {
+	keygen := flag.Bool("keygen", false, "print a new signing key and its public key, then exit")
+	version := flag.String("version", "", "tag of the release being built, e.g. v1.2.0")
+	dist := flag.String("dist", "dist", "directory holding the release binaries and patches")
+	previous := flag.String("previous", "", "tag of the release the patches start from")
+	previousDir := flag.String("previous-dir", "previous", "directory holding the previous release's binaries")
+	flag.Parse()
+
+	if *keygen {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("RELEASE_SIGNING_KEY=%s\n", base64.StdEncoding.EncodeToString(private.Seed()))
+		fmt.Printf("RELEASE_PUBLIC_KEY=%s\n", base64.StdEncoding.EncodeToString(public))
+		return
+	}
+
+	if *version == "" {
+		log.Fatal("-version is required")
+	}
+	key, err := signingKey(os.Getenv("RELEASE_SIGNING_KEY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	manifest, err := buildManifest(*version, *dist, *previous, *previousDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, body))
+	if err := os.WriteFile(filepath.Join(*dist, "manifest.json"), body, 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(*dist, "manifest.json.sig"), []byte(signature+"\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+	for name, asset := range manifest.Assets {
+		log.Printf("%s: %d bytes, %d patches", name, asset.Size, len(asset.Patches))
+	}
+}
+
+// signingKey decodes a base64 ed25519 seed, or a full private key
+func signingKey(encoded string) (ed25519.PrivateKey, error) {
+	if encoded == "" {
+		return nil, fmt.Errorf("RELEASE_SIGNING_KEY is not set")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("RELEASE_SIGNING_KEY: %w", err)
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, fmt.Errorf("RELEASE_SIGNING_KEY is %d bytes, want a %d byte seed", len(raw), ed25519.SeedSize)
+}
+
+// buildManifest describes every binary in dist and the patches that lead to it
+func buildManifest(version, dist, previous, previousDir string) (*updater.Manifest, error) {
+	binaries, err := filepath.Glob(filepath.Join(dist, "idlenet-*"))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &updater.Manifest{Version: version, Assets: make(map[string]updater.ManifestAsset)}
+	for _, path := range binaries {
+		name := filepath.Base(path)
+		if strings.HasSuffix(name, ".zst") {
+			continue
+		}
+
+		sum, size, err := hashFile(path)
+		if err != nil {
+			return nil, err
+		}
+		asset := updater.ManifestAsset{SHA256: sum, Size: size}
+
+		if previous != "" {
+			patch, err := findPatch(dist, name, previous, previousDir)
+			if err != nil {
+				return nil, err
+			}
+			if patch != nil {
+				asset.Patches = append(asset.Patches, *patch)
+			}
+		}
+		manifest.Assets[name] = asset
+	}
+
+	if len(manifest.Assets) == 0 {
+		return nil, fmt.Errorf("no binaries in %s", dist)
+	}
+	return manifest, nil
+}
+
+// findPatch returns the patch from the previous release's copy of name, or
+// nil if there isn't one, e.g. for a platform that's new in this release
+func findPatch(dist, name, previous, previousDir string) (*updater.Patch, error) {
+	patchName := fmt.Sprintf("%s.from-%s.zst", name, previous)
+	sum, size, err := hashFile(filepath.Join(dist, patchName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The agent checks its own binary against this before patching it
+	fromSum, _, err := hashFile(filepath.Join(previousDir, name))
+	if err != nil {
+		return nil, fmt.Errorf("patch %s has no base binary: %w", patchName, err)
+	}
+
+	return &updater.Patch{
+		From:       previous,
+		FromSHA256: fromSum,
+		Name:       patchName,
+		Format:     "zstd",
+		SHA256:     sum,
+		Size:       size,
+	}, nil
+}
+
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
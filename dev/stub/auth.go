@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ifruncillo/idlenet-agent/internal/api"
+)
+
+// signatureWindow is how far a request timestamp may drift from our clock
+const signatureWindow = 5 * time.Minute
+
+// deviceKeys remembers registered public keys and recently seen nonces
+type deviceKeys struct {
+	mu     sync.Mutex
+	keys   map[string]ed25519.PublicKey
+	nonces map[string]time.Time
+}
+
+func newDeviceKeys() *deviceKeys {
+	return &deviceKeys{
+		keys:   make(map[string]ed25519.PublicKey),
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// middleware checks request signatures like the real API would
+// Unsigned requests are let through with a warning so older agents still work against the stub
+func (d *deviceKeys) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		device := r.Header.Get(api.HeaderDevice)
+		if device == "" {
+			log.Printf("  unsigned request %s %s", r.Method, r.URL.Path)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := d.keyFor(r, device, body)
+		if err == nil {
+			err = d.verify(r, key, body)
+		}
+		if err != nil {
+			log.Printf("  signature rejected for %s: %v", device, err)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// keyFor finds the key to verify with: the stored one, or on registration the
+// one being registered, which proves the agent holds its private half
+func (d *deviceKeys) keyFor(r *http.Request, device string, body []byte) (ed25519.PublicKey, error) {
+	if r.URL.Path == "/api/agent/register" {
+		var req struct {
+			PublicKey string `json:"publicKey"`
+		}
+		plain, _ := decodeBody(r.Header.Get("Content-Encoding"), body)
+		json.Unmarshal(plain, &req)
+		raw, err := base64.StdEncoding.DecodeString(req.PublicKey)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("missing or malformed publicKey")
+		}
+
+		d.mu.Lock()
+		d.keys[device] = ed25519.PublicKey(raw)
+		d.mu.Unlock()
+		return ed25519.PublicKey(raw), nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key, ok := d.keys[device]
+	if !ok {
+		return nil, fmt.Errorf("unknown device (stub restarted? re-register)")
+	}
+	return key, nil
+}
+
+func (d *deviceKeys) verify(r *http.Request, key ed25519.PublicKey, body []byte) error {
+	timestamp := r.Header.Get(api.HeaderTimestamp)
+	nonce := r.Header.Get(api.HeaderNonce)
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad timestamp")
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > signatureWindow || skew < -signatureWindow {
+		return fmt.Errorf("timestamp outside window (%v)", skew)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(api.HeaderSignature))
+	if err != nil {
+		return fmt.Errorf("bad signature encoding")
+	}
+
+	message := api.CanonicalRequest(r.Method, signedPath(r), body, timestamp, nonce)
+	if !ed25519.Verify(key, message, signature) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, seen := d.nonces[nonce]; seen {
+		return fmt.Errorf("replayed nonce")
+	}
+	d.nonces[nonce] = time.Now()
+	for n, at := range d.nonces {
+		if time.Since(at) > 2*signatureWindow {
+			delete(d.nonces, n)
+		}
+	}
+	return nil
+}
+
+// signedPath is the request path as the agent signed it, before it added
+// the Vercel bypass parameters
+func signedPath(r *http.Request) string {
+	query := r.URL.Query()
+	if !query.Has("x-vercel-protection-bypass") {
+		return r.URL.RequestURI()
+	}
+	query.Del("x-vercel-protection-bypass")
+	query.Del("x-vercel-set-bypass-cookie")
+	if len(query) == 0 {
+		return r.URL.EscapedPath()
+	}
+	return r.URL.EscapedPath() + "?" + query.Encode()
+}
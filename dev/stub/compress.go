@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// acceptedEncodings is advertised on every response so agents start
+// compressing request bodies, as the real API does
+const acceptedEncodings = "zstd, gzip"
+
+var errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+// decompress undoes Content-Encoding on request bodies
+// It runs after signature checks, which cover the body as sent
+func decompress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Encoding", acceptedEncodings)
+
+		encoding := r.Header.Get("Content-Encoding")
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+		body, err := decodeBody(encoding, raw)
+		if errors.Is(err, errUnsupportedEncoding) {
+			log.Printf("  unsupported Content-Encoding %q on %s", encoding, r.URL.Path)
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(w, "bad "+encoding+" body", http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("  %s body %s: %d -> %d bytes", encoding, r.URL.Path, len(raw), len(body))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.Header.Del("Content-Encoding")
+		r.ContentLength = int64(len(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeBody reverses encoding on raw
+func decodeBody(encoding string, raw []byte) ([]byte, error) {
+	switch encoding {
+	case "", "identity":
+		return raw, nil
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(gz)
+	case "zstd":
+		zr, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return zr.DecodeAll(raw, nil)
+	}
+	return nil, errUnsupportedEncoding
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// stubRate is what the stub pays per CPU second
+const stubRate = 0.001
+
+type credit struct {
+	JobID      string    `json:"jobId"`
+	Amount     float64   `json:"amount"`
+	Currency   string    `json:"currency"`
+	CreditedAt time.Time `json:"creditedAt"`
+}
+
+// credits is the stub's record of what it has paid, in the order it paid it
+// The cursor handed to agents is simply how many they've seen
+type credits struct {
+	mu   sync.Mutex
+	list []credit
+}
+
+// pay credits a finished job
+func (c *credits) pay(jobID string, amount float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.list = append(c.list, credit{JobID: jobID, Amount: amount, Currency: "USD", CreditedAt: time.Now().UTC()})
+}
+
+// handleGet serves credits after the cursor
+func (c *credits) handleGet(w http.ResponseWriter, r *http.Request) {
+	from, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+
+	c.mu.Lock()
+	if from < 0 || from > len(c.list) {
+		from = 0
+	}
+	page := append([]credit{}, c.list[from:]...)
+	next := len(c.list)
+	c.mu.Unlock()
+
+	log.Printf("CREDITS %s from=%d -> %d", r.URL.Query().Get("deviceId"), from, len(page))
+	json.NewEncoder(w).Encode(map[string]any{"credits": page, "cursor": strconv.Itoa(next)})
+}
+
+// handleAdjust records a correction, e.g. to see the agent flag a discrepancy:
+// curl 'http://127.0.0.1:8787/dev/credit?job=stub-0001&amount=0.5'
+func (c *credits) handleAdjust(w http.ResponseWriter, r *http.Request) {
+	amount, err := strconv.ParseFloat(r.URL.Query().Get("amount"), 64)
+	if err != nil {
+		http.Error(w, "amount required", http.StatusBadRequest)
+		return
+	}
+	c.pay(r.URL.Query().Get("job"), amount)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// eventHub is the stub's side of the agent's long-poll push channel
+type eventHub struct {
+	mu       sync.Mutex
+	seq      int64
+	events   []stubEvent
+	wake     chan struct{} // Closed and replaced whenever an event is published
+	lastPoll time.Time
+}
+
+type stubEvent struct {
+	seq  int64
+	body map[string]any
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{wake: make(chan struct{})}
+}
+
+// publish queues an event and wakes any waiting poll
+func (h *eventHub) publish(body map[string]any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	h.events = append(h.events, stubEvent{seq: h.seq, body: body})
+	if len(h.events) > 100 {
+		h.events = h.events[len(h.events)-100:]
+	}
+	close(h.wake)
+	h.wake = make(chan struct{})
+	log.Printf("EVENT #%d %v", h.seq, body)
+}
+
+// online reports whether an agent has polled recently enough to receive pushes
+func (h *eventHub) online() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Since(h.lastPoll) < time.Minute
+}
+
+// after returns events newer than cursor and a channel closed on the next publish
+func (h *eventHub) after(cursor int64) ([]map[string]any, int64, <-chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastPoll = time.Now()
+	if cursor > h.seq || cursor < 0 {
+		cursor = h.seq // The agent's cursor is from before a stub restart
+	}
+	var out []map[string]any
+	for _, e := range h.events {
+		if e.seq > cursor {
+			out = append(out, e.body)
+		}
+	}
+	return out, h.seq, h.wake
+}
+
+// handle holds GET /api/agent/events open until there's something to send or wait expires
+func (h *eventHub) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// A fresh agent starts from now rather than replaying stale events
+	cursor, err := strconv.ParseInt(r.URL.Query().Get("cursor"), 10, 64)
+	if err != nil {
+		_, cursor, _ = h.after(-1)
+	}
+	wait, err := strconv.Atoi(r.URL.Query().Get("wait"))
+	if err != nil || wait <= 0 || wait > 30 {
+		wait = 25
+	}
+	timeout := time.NewTimer(time.Duration(wait) * time.Second)
+	defer timeout.Stop()
+
+	for {
+		events, seq, wake := h.after(cursor)
+		if len(events) > 0 {
+			writeEvents(w, events, seq)
+			return
+		}
+		select {
+		case <-wake:
+		case <-timeout.C:
+			writeEvents(w, nil, seq)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvents(w http.ResponseWriter, events []map[string]any, cursor int64) {
+	if events == nil {
+		events = []map[string]any{}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"events": events, "cursor": strconv.FormatInt(cursor, 10)})
+}
@@ -1,24 +1,101 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Register struct {
-	Email    string `json:"email"`
-	DeviceID string `json:"deviceId"`
-	Referral string `json:"referral,omitempty"`
-	Version  string `json:"version,omitempty"`
+	Email     string `json:"email"`
+	DeviceID  string `json:"deviceId"`
+	Referral  string `json:"referral,omitempty"`
+	Version   string `json:"version,omitempty"`
+	PublicKey string `json:"publicKey,omitempty"`
+
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
 }
 type Beat struct {
+	Schema       int      `json:"schema"`
+	Email        string   `json:"email"`
+	DeviceID     string   `json:"deviceId"`
+	AgentVersion string   `json:"agentVersion"`
+	OS           string   `json:"os"`
+	Arch         string   `json:"arch"`
+	AllowedCores int      `json:"allowedCores"`
+	RunningJobs  []string `json:"runningJobs"`
+	JobTypes     []string `json:"jobTypes"`
+	Update       *struct {
+		State            string `json:"state"`
+		CurrentVersion   string `json:"currentVersion"`
+		AvailableVersion string `json:"availableVersion"`
+	} `json:"update,omitempty"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+}
+
+// Capabilities is the agent's benchmark profile, scores per core
+type Capabilities struct {
+	AgentVersion string  `json:"agentVersion"`
+	HashMBps     float64 `json:"hashMBps"`
+	FloatMflops  float64 `json:"floatMflops"`
+	MemoryMBps   float64 `json:"memoryMBps"`
+	WASMMips     float64 `json:"wasmMips"`
+}
+
+func (c *Capabilities) String() string {
+	if c == nil {
+		return "none"
+	}
+	return fmt.Sprintf("hash=%.0fMB/s float=%.0fMflops memory=%.0fMB/s wasm=%.0fMips (%s)",
+		c.HashMBps, c.FloatMflops, c.MemoryMBps, c.WASMMips, c.AgentVersion)
+}
+
+type Result struct {
 	Email    string `json:"email"`
 	DeviceID string `json:"deviceId"`
+	Result   struct {
+		JobID            string     `json:"jobId"`
+		Status           string     `json:"status"`
+		Error            string     `json:"error"`
+		CPUSeconds       float64    `json:"cpuSeconds"`
+		ElapsedSeconds   float64    `json:"elapsedSeconds"`
+		SuspendedSeconds float64    `json:"suspendedSeconds"`
+		ServerFinishedAt *time.Time `json:"serverFinishedAt"`
+	} `json:"result"`
+}
+type Telemetry struct {
+	DeviceID string            `json:"deviceId"`
+	Events   []json.RawMessage `json:"events"`
 }
 
 func main() {
+	var jobSeq atomic.Int64
+
+	// Directives queued via /dev/directive are pushed to a connected agent,
+	// or delivered on the next heartbeat otherwise
+	var mu sync.Mutex
+	var directives []map[string]any
+
+	// Length and type of the jobs handed out, set with /dev/offer?seconds=N&type=hash
+	var jobSeconds atomic.Int64
+	jobSeconds.Store(3)
+	var jobType atomic.Value
+	jobType.Store("sleep")
+
+	events := newEventHub()
+
+	// Idempotency keys already processed, so replayed outbox records aren't counted twice
+	seen := newKeySet()
+
+	tokens := newSessions()
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/api/agent/register", func(w http.ResponseWriter, r *http.Request) {
@@ -27,22 +104,184 @@ func main() {
 			http.Error(w, "bad json", http.StatusBadRequest)
 			return
 		}
-		log.Printf("REGISTER %s %s referral=%q version=%q ua=%q",
-			req.Email, req.DeviceID, req.Referral, req.Version, r.UserAgent())
-		json.NewEncoder(w).Encode(map[string]any{"ok": true, "ts": time.Now().UTC()})
+		log.Printf("REGISTER %s %s referral=%q version=%q signed=%t ua=%q capabilities=%v",
+			req.Email, req.DeviceID, req.Referral, req.Version, req.PublicKey != "", r.UserAgent(), req.Capabilities)
+		response := tokens.issue(req.DeviceID)
+		response["ok"] = true
+		response["ts"] = time.Now().UTC()
+		json.NewEncoder(w).Encode(response)
 	})
 
+	mux.HandleFunc("/api/agent/token/refresh", tokens.handleRefresh)
+	mux.HandleFunc("/dev/revoke", tokens.handleRevoke)
+
 	mux.HandleFunc("/api/agent/beat", func(w http.ResponseWriter, r *http.Request) {
 		var req Beat
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "bad json", http.StatusBadRequest)
 			return
 		}
-		log.Printf("BEAT %s %s ua=%q", req.Email, req.DeviceID, r.UserAgent())
-		json.NewEncoder(w).Encode(map[string]any{"ok": true, "ts": time.Now().UTC()})
+		log.Printf("BEAT v%d %s %s agent=%s %s/%s cores=%d running=%v types=%v ua=%q",
+			req.Schema, req.Email, req.DeviceID, req.AgentVersion, req.OS, req.Arch,
+			req.AllowedCores, req.RunningJobs, req.JobTypes, r.UserAgent())
+		if u := req.Update; u != nil {
+			log.Printf("  update state=%s current=%s available=%s", u.State, u.CurrentVersion, u.AvailableVersion)
+		}
+		if c := req.Capabilities; c != nil {
+			log.Printf("  capabilities %v", c)
+		}
+		mu.Lock()
+		pending := directives
+		directives = nil
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "ts": time.Now().UTC(), "directives": pending})
+	})
+
+	// e.g. curl 'http://127.0.0.1:8787/dev/directive?type=pause&seconds=60'
+	mux.HandleFunc("/dev/directive", func(w http.ResponseWriter, r *http.Request) {
+		d := map[string]any{"type": r.URL.Query().Get("type")}
+		if secs, err := strconv.Atoi(r.URL.Query().Get("seconds")); err == nil {
+			d["seconds"] = secs
+		}
+		if events.online() {
+			events.publish(map[string]any{"type": "directive", "directive": d})
+		} else {
+			mu.Lock()
+			directives = append(directives, d)
+			mu.Unlock()
+			log.Printf("DIRECTIVE queued %v", d)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/api/agent/events", events.handle)
+
+	remote := newRemoteConfig()
+	mux.HandleFunc("/api/agent/config", remote.handleGet)
+	mux.HandleFunc("/dev/config", remote.handlePublish)
+
+	paid := &credits{}
+	mux.HandleFunc("/api/agent/credits", paid.handleGet)
+	mux.HandleFunc("/dev/credit", paid.handleAdjust)
+
+	rates := &rateCard{}
+	mux.HandleFunc("/api/agent/ratecard", rates.handleGet)
+	mux.HandleFunc("/dev/ratecard", rates.handlePublish)
+
+	// e.g. curl 'http://127.0.0.1:8787/dev/offer?seconds=60' to offer a longer job,
+	// or add &type=hash for one that keeps a core busy
+	mux.HandleFunc("/dev/offer", func(w http.ResponseWriter, r *http.Request) {
+		if secs, err := strconv.Atoi(r.URL.Query().Get("seconds")); err == nil && secs > 0 {
+			jobSeconds.Store(int64(secs))
+		}
+		if t := r.URL.Query().Get("type"); t != "" {
+			jobType.Store(t)
+		}
+		events.publish(map[string]any{"type": "job_offer"})
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// e.g. curl 'http://127.0.0.1:8787/dev/cancel?job=stub-0001'
+	mux.HandleFunc("/dev/cancel", func(w http.ResponseWriter, r *http.Request) {
+		events.publish(map[string]any{"type": "job_cancel", "jobId": r.URL.Query().Get("job")})
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/api/agent/jobs/next", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := fmt.Sprintf("stub-%04d", jobSeq.Add(1))
+		traceParent := newTraceParent()
+		log.Printf("JOB %s -> %s traceparent=%s", r.URL.Query().Get("deviceId"), id, traceParent)
+		json.NewEncoder(w).Encode(map[string]any{"job": map[string]any{
+			"id":          id,
+			"type":        jobType.Load(),
+			"args":        map[string]any{"seconds": jobSeconds.Load()},
+			"max_seconds": jobSeconds.Load() + 30,
+			"mem_mb":      64,
+			"traceparent": traceParent,
+		}})
+	})
+
+	mux.HandleFunc("/api/agent/results", func(w http.ResponseWriter, r *http.Request) {
+		var req Result
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if !seen.first(r.Header.Get("Idempotency-Key")) {
+			log.Printf("RESULT %s job=%s duplicate, ignored", req.DeviceID, req.Result.JobID)
+			json.NewEncoder(w).Encode(map[string]any{"ok": true, "duplicate": true})
+			return
+		}
+		log.Printf("RESULT %s job=%s status=%s cpu=%.2fs elapsed=%.2fs suspended=%.0fs err=%q traceparent=%s",
+			req.DeviceID, req.Result.JobID, req.Result.Status, req.Result.CPUSeconds,
+			req.Result.ElapsedSeconds, req.Result.SuspendedSeconds, req.Result.Error, r.Header.Get("traceparent"))
+		if req.Result.Status == "ok" {
+			paid.pay(req.Result.JobID, req.Result.CPUSeconds*stubRate)
+		}
+		if at := req.Result.ServerFinishedAt; at != nil {
+			log.Printf("  finished at %s by our clock (%v ago)", at.Format(time.RFC3339Nano), time.Since(*at).Round(time.Millisecond))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"ok": true})
+	})
+
+	mux.HandleFunc("/api/agent/telemetry", func(w http.ResponseWriter, r *http.Request) {
+		var req Telemetry
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		fresh := 0
+		for _, raw := range req.Events {
+			var event struct {
+				ID   string `json:"id"`
+				Kind string `json:"kind"`
+			}
+			json.Unmarshal(raw, &event)
+			if seen.first(event.ID) {
+				fresh++
+			}
+		}
+		log.Printf("TELEMETRY %s events=%d new=%d", req.DeviceID, len(req.Events), fresh)
+		w.WriteHeader(http.StatusNoContent)
 	})
 
 	addr := "127.0.0.1:8787"
 	log.Printf("mock API listening on http://%s", addr)
-	log.Fatal(http.ListenAndServe(addr, mux))
+	log.Fatal(http.ListenAndServe(addr, newDeviceKeys().middleware(decompress(tokens.middleware(mux)))))
+}
+
+// keySet remembers idempotency keys
+type keySet struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func newKeySet() *keySet {
+	return &keySet{keys: make(map[string]bool)}
+}
+
+// first records key and reports whether it is new; requests without a key always are
+func (k *keySet) first(key string) bool {
+	if key == "" {
+		return true
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys[key] {
+		return false
+	}
+	k.keys[key] = true
+	return true
+}
+
+// newTraceParent starts a W3C trace for a job offer, as a tracing server would,
+// so the agent's spans and result headers can be checked against it
+func newTraceParent() string {
+	id := make([]byte, 24)
+	rand.Read(id)
+	return "00-" + hex.EncodeToString(id[:16]) + "-" + hex.EncodeToString(id[16:]) + "-01"
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateCard serves the published rate card
+type rateCard struct {
+	mu      sync.Mutex
+	version int64
+	card    []byte
+}
+
+// handleGet serves the current card, or 404 before one was published
+func (c *rateCard) handleGet(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	card, version := c.card, c.version
+	c.mu.Unlock()
+
+	if card == nil {
+		http.NotFound(w, r)
+		return
+	}
+	log.Printf("RATECARD %s -> v%d", r.URL.Query().Get("deviceId"), version)
+	w.Write(card)
+}
+
+// handlePublish publishes a new version of the card in the request body, e.g.
+// curl -d '{"per_cpu_second":0.002,"per_gb_hour":0.01,"job_types":{"hash":1.5},"time_of_day":[{"from_hour":22,"to_hour":6,"multiplier":1.2}]}' http://127.0.0.1:8787/dev/ratecard
+func (c *rateCard) handlePublish(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var card map[string]any
+	if err := json.Unmarshal(body, &card); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	card["version"] = c.version
+	card["published"] = time.Now().UTC()
+	if _, ok := card["currency"]; !ok {
+		card["currency"] = "USD"
+	}
+	c.card, _ = json.Marshal(card)
+	log.Printf("RATECARD published v%d %s", c.version, c.card)
+	w.WriteHeader(http.StatusNoContent)
+}
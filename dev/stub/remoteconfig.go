@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// remoteConfig serves signed config documents to the agent
+// The key is derived from a fixed seed so dev agents can be built with it,
+// using the -ldflags value logged at startup
+type remoteConfig struct {
+	key ed25519.PrivateKey
+
+	mu       sync.Mutex
+	version  int64
+	envelope []byte
+}
+
+func newRemoteConfig() *remoteConfig {
+	seed := sha256.Sum256([]byte("idlenet dev stub remote config"))
+	c := &remoteConfig{key: ed25519.NewKeyFromSeed(seed[:])}
+	log.Printf("remote config key: -ldflags \"-X github.com/ifruncillo/idlenet-agent/internal/remoteconfig.publicKey=%s\"",
+		base64.StdEncoding.EncodeToString(c.key.Public().(ed25519.PublicKey)))
+	return c
+}
+
+// handleGet serves the current envelope, or 404 before one was published
+func (c *remoteConfig) handleGet(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	envelope, version := c.envelope, c.version
+	c.mu.Unlock()
+
+	if envelope == nil {
+		http.NotFound(w, r)
+		return
+	}
+	log.Printf("CONFIG %s -> v%d", r.URL.Query().Get("deviceId"), version)
+	w.Write(envelope)
+}
+
+// handlePublish signs a new version of the document in the request body, e.g.
+// curl -d '{"defaults":{"heartbeat_seconds":10},"locked":["max_cpu_percent"]}' http://127.0.0.1:8787/dev/config
+func (c *remoteConfig) handlePublish(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var doc map[string]any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	doc["version"] = c.version
+	doc["issued_at"] = time.Now().UTC()
+	payload, _ := json.Marshal(doc)
+	c.envelope, _ = json.Marshal(map[string]string{
+		"payload":   base64.StdEncoding.EncodeToString(payload),
+		"signature": base64.StdEncoding.EncodeToString(ed25519.Sign(c.key, payload)),
+	})
+	log.Printf("CONFIG published v%d %s", c.version, payload)
+	w.WriteHeader(http.StatusNoContent)
+}
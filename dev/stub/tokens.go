@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessTTL is deliberately short so agents exercise refresh against the stub
+const accessTTL = 5 * time.Minute
+
+type accessToken struct {
+	device  string
+	expires time.Time
+}
+
+// sessions issues and validates bearer tokens like the real API
+type sessions struct {
+	mu      sync.Mutex
+	access  map[string]accessToken
+	refresh map[string]string // refresh token -> device
+}
+
+func newSessions() *sessions {
+	return &sessions{
+		access:  make(map[string]accessToken),
+		refresh: make(map[string]string),
+	}
+}
+
+// issue creates a fresh token pair for device, replacing any it had
+func (s *sessions) issue(device string) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeLocked(device)
+	access, refresh := randomToken(), randomToken()
+	s.access[access] = accessToken{device: device, expires: time.Now().Add(accessTTL)}
+	s.refresh[refresh] = device
+
+	return map[string]any{
+		"accessToken":  access,
+		"refreshToken": refresh,
+		"expiresIn":    int(accessTTL.Seconds()),
+	}
+}
+
+// handleRefresh rotates a refresh token into a new token pair
+func (s *sessions) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DeviceID     string `json:"deviceId"`
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	device, ok := s.refresh[req.RefreshToken]
+	s.mu.Unlock()
+	if !ok || device != req.DeviceID {
+		log.Printf("REFRESH %s rejected", req.DeviceID)
+		http.Error(w, "refresh token revoked", http.StatusUnauthorized)
+		return
+	}
+
+	log.Printf("REFRESH %s ok", device)
+	json.NewEncoder(w).Encode(s.issue(device))
+}
+
+// handleRevoke drops every token for a device, forcing it to re-register
+// e.g. curl 'http://127.0.0.1:8787/dev/revoke?deviceId=device-...'
+func (s *sessions) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	device := r.URL.Query().Get("deviceId")
+	s.mu.Lock()
+	s.revokeLocked(device)
+	s.mu.Unlock()
+	log.Printf("REVOKE %s", device)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// middleware requires a valid bearer token on everything except enrollment
+func (s *sessions) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/agent/register",
+			r.URL.Path == "/api/agent/token/refresh",
+			strings.HasPrefix(r.URL.Path, "/dev/"):
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		session, ok := s.access[token]
+		if ok && time.Now().After(session.expires) {
+			delete(s.access, token)
+			ok = false
+		}
+		s.mu.Unlock()
+
+		if !ok {
+			log.Printf("  rejected bearer token for %s %s", r.Method, r.URL.Path)
+			http.Error(w, "invalid or expired access token", http.StatusUnauthorized)
+			return
+		}
+		if device := r.Header.Get("X-IdleNet-Device"); device != "" && device != session.device {
+			http.Error(w, "token issued to another device", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *sessions) revokeLocked(device string) {
+	for token, d := range s.refresh {
+		if d == device {
+			delete(s.refresh, token)
+		}
+	}
+	for token, a := range s.access {
+		if a.device == device {
+			delete(s.access, token)
+		}
+	}
+}
+
+func randomToken() string {
+	var b [24]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+    "errors"
+    "sync"
+    "time"
+)
+
+// ErrCircuitOpen is returned without touching the network while the server is considered down
+var ErrCircuitOpen = errors.New("API unavailable, backing off")
+
+type breakerState int
+
+const (
+    breakerClosed   breakerState = iota // Healthy, requests flow
+    breakerOpen                         // Failing, requests are refused until the cooldown ends
+    breakerHalfOpen                     // Cooldown over, one probe request is allowed through
+)
+
+// Breaker is a circuit breaker shared by every request on a transport
+// After Threshold consecutive failures it opens for a cooldown that doubles
+// on each failed probe, up to MaxCooldown
+// A nil *Breaker lets every request through and records nothing
+type Breaker struct {
+    Threshold   int
+    Cooldown    time.Duration
+    MaxCooldown time.Duration
+    
+    mu        sync.Mutex
+    state     breakerState
+    failures  int
+    current   time.Duration // Cooldown applied the last time we opened
+    openUntil time.Time
+    probing   bool
+    now       func() time.Time
+}
+
+// NewBreaker creates a breaker with the agent's default thresholds
+func NewBreaker() *Breaker {
+    return &Breaker{
+        Threshold:   3,
+        Cooldown:    30 * time.Second,
+        MaxCooldown: 10 * time.Minute,
+        now:         time.Now,
+    }
+}
+
+// Allow reports whether a request may be sent now
+// A request let through while half-open is the probe, and must end in
+// Success, Failure or Release
+func (b *Breaker) Allow() error {
+    _, err := b.allow()
+    return err
+}
+
+// allow is Allow, also saying whether the caller holds the probe
+func (b *Breaker) allow() (probe bool, err error) {
+    if b == nil {
+        return false, nil
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    
+    switch b.state {
+    case breakerOpen:
+        if b.now().Before(b.openUntil) {
+            return false, ErrCircuitOpen
+        }
+        b.state = breakerHalfOpen
+        b.probing = true
+        return true, nil
+    
+    case breakerHalfOpen:
+        // Only one probe at a time while we find out if the server is back
+        if b.probing {
+            return false, ErrCircuitOpen
+        }
+        b.probing = true
+        return true, nil
+    }
+    
+    return false, nil
+}
+
+// Release gives up a probe that ended without a verdict, e.g. because the
+// caller's deadline passed first, so the next request can probe instead
+func (b *Breaker) Release() {
+    if b == nil {
+        return
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    
+    if b.state == breakerHalfOpen {
+        b.probing = false
+    }
+}
+
+// Success records a healthy response and closes the breaker
+func (b *Breaker) Success() {
+    if b == nil {
+        return
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    
+    b.state = breakerClosed
+    b.failures = 0
+    b.current = 0
+    b.probing = false
+}
+
+// Failure records an unhealthy response
+// hint is the server's Retry-After, if any, and sets a floor on the cooldown
+func (b *Breaker) Failure(hint time.Duration) {
+    if b == nil {
+        return
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    
+    b.failures++
+    b.probing = false
+    
+    switch {
+    case b.state == breakerHalfOpen:
+        // The probe failed; stay away for longer this time
+        b.current *= 2
+    case b.failures >= b.Threshold:
+        b.current = b.Cooldown
+    case hint > 0:
+        // The server asked us to wait; respect that without escalating
+        b.state = breakerOpen
+        b.openUntil = b.now().Add(hint)
+        return
+    default:
+        return
+    }
+    
+    if b.current < b.Cooldown {
+        b.current = b.Cooldown
+    }
+    if b.current > b.MaxCooldown {
+        b.current = b.MaxCooldown
+    }
+    
+    cooldown := b.current
+    if hint > cooldown {
+        cooldown = hint
+    }
+    
+    b.state = breakerOpen
+    b.openUntil = b.now().Add(cooldown)
+}
+
+// Healthy reports whether the breaker is closed
+func (b *Breaker) Healthy() bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.state == breakerClosed
+}
+
+// Slowdown returns how many times longer periodic work should wait between
+// calls: 1 while healthy, growing with the cooldown while the server is down
+func (b *Breaker) Slowdown() int {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    
+    if b.state == breakerClosed || b.Cooldown <= 0 {
+        return 1
+    }
+    
+    factor := 2 * int(b.current/b.Cooldown)
+    if factor < 2 {
+        factor = 2
+    }
+    if factor > 16 {
+        factor = 16
+    }
+    return factor
+}
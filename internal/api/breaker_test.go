@@ -0,0 +1,99 @@
+package api
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+type fakeClock struct {
+    t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestBreaker() (*Breaker, *fakeClock) {
+    clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+    b := NewBreaker()
+    b.now = clock.now
+    return b, clock
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+    b, _ := newTestBreaker()
+    
+    for i := 0; i < b.Threshold-1; i++ {
+        b.Failure(0)
+        if err := b.Allow(); err != nil {
+            t.Fatalf("breaker opened after %d failures", i+1)
+        }
+    }
+    
+    b.Failure(0)
+    if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+        t.Fatalf("Allow = %v, want ErrCircuitOpen", err)
+    }
+    if b.Slowdown() < 2 {
+        t.Errorf("Slowdown = %d while open, want >= 2", b.Slowdown())
+    }
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+    b, clock := newTestBreaker()
+    for i := 0; i < b.Threshold; i++ {
+        b.Failure(0)
+    }
+    
+    clock.t = clock.t.Add(b.Cooldown)
+    if err := b.Allow(); err != nil {
+        t.Fatalf("probe refused after cooldown: %v", err)
+    }
+    if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+        t.Fatalf("second concurrent probe allowed")
+    }
+    
+    // Failed probe doubles the cooldown
+    b.Failure(0)
+    clock.t = clock.t.Add(b.Cooldown)
+    if err := b.Allow(); err == nil {
+        t.Fatal("breaker reopened for only the base cooldown after a failed probe")
+    }
+    clock.t = clock.t.Add(b.Cooldown)
+    if err := b.Allow(); err != nil {
+        t.Fatalf("probe refused after doubled cooldown: %v", err)
+    }
+    
+    b.Success()
+    if !b.Healthy() || b.Slowdown() != 1 {
+        t.Error("successful probe should close the breaker")
+    }
+}
+
+func TestBreakerCooldownIsCapped(t *testing.T) {
+    b, clock := newTestBreaker()
+    for i := 0; i < b.Threshold; i++ {
+        b.Failure(0)
+    }
+    
+    for i := 0; i < 20; i++ {
+        clock.t = clock.t.Add(b.MaxCooldown)
+        if err := b.Allow(); err != nil {
+            t.Fatalf("probe %d refused after MaxCooldown: %v", i, err)
+        }
+        b.Failure(0)
+    }
+}
+
+func TestBreakerRetryAfterHint(t *testing.T) {
+    b, clock := newTestBreaker()
+    
+    b.Failure(5 * time.Second)
+    if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+        t.Fatal("Retry-After should hold requests back")
+    }
+    
+    clock.t = clock.t.Add(5 * time.Second)
+    if err := b.Allow(); err != nil {
+        t.Fatalf("Allow after Retry-After elapsed = %v", err)
+    }
+}
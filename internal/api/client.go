@@ -1,158 +1,315 @@
 package api
 
 import (
-    "bytes"
     "context"
+    "crypto/ed25519"
+    cryptorand "crypto/rand"
+    "encoding/hex"
     "encoding/json"
     "fmt"
-    "io"
+    "math/rand"
     "net/http"
     "net/url"
     "time"
 )
 
 // Client handles all communication with the IdleNet API
+// Every endpoint is built on the shared Transport
 type Client struct {
-    baseURL    string
-    httpClient *http.Client
-    email      string
-    deviceID   string
-    bypass     string  // Optional Vercel bypass token for protected deployments
+    transport *Transport
+    signer    *RequestSigner
+    session   *Session
+    version   string
+    email     string
+    deviceID  string
+    
+    capabilities *Capabilities // Benchmark profile sent at registration and in heartbeats
 }
 
 // NewClient creates a new API client with the given configuration
-func NewClient(baseURL, email, deviceID string) *Client {
+func NewClient(baseURL, version, email, deviceID string) *Client {
     return &Client{
-        baseURL:  baseURL,
-        email:    email,
-        deviceID: deviceID,
-        httpClient: &http.Client{
-            Timeout: 30 * time.Second,  // Don't wait forever for responses
-        },
+        transport: NewTransport(baseURL, version),
+        version:   version,
+        email:     email,
+        deviceID:  deviceID,
     }
 }
 
 // SetBypassToken sets the Vercel bypass token if the deployment is protected
 func (c *Client) SetBypassToken(token string) {
-    c.bypass = token
+    c.transport.SetBypassToken(token)
+}
+
+// SetHTTPTransport routes API requests through rt
+func (c *Client) SetHTTPTransport(rt http.RoundTripper) {
+    c.transport.SetHTTPTransport(rt)
+}
+
+// SetObserver reports every API request attempt to observe, e.g. for latency metrics
+func (c *Client) SetObserver(observe Observer) {
+    c.transport.SetObserver(observe)
+}
+
+// SetDeviceKey signs every request with the device's private key and
+// registers the matching public key
+func (c *Client) SetDeviceKey(key ed25519.PrivateKey) {
+    c.signer = NewRequestSigner(c.deviceID, key)
+    c.transport.SetSigner(c.signer)
+}
+
+// SetCapabilities reports caps from now on, at registration and in heartbeats
+func (c *Client) SetCapabilities(caps *Capabilities) {
+    c.capabilities = caps
+}
+
+// SetTokenStore enables bearer-token sessions, keeping the refresh token in store
+func (c *Client) SetTokenStore(store TokenStore) {
+    c.session = newSession(c.transport, store, c.deviceID)
+    c.transport.SetAuthenticator(c.session)
+}
+
+// Healthy reports whether the API has been answering normally
+func (c *Client) Healthy() bool {
+    return c.transport.Breaker().Healthy()
+}
+
+// ServerTime converts a local wall-clock time to the server's clock
+// It returns false until the server's offset has been learned
+func (c *Client) ServerTime(t time.Time) (time.Time, bool) {
+    return c.transport.clock.ServerTime(t)
+}
+
+// ClockOffset returns how far the server's clock is ahead of ours
+func (c *Client) ClockOffset() (time.Duration, bool) {
+    return c.transport.ClockOffset()
+}
+
+// Pace stretches a polling interval while the API is unhealthy, with jitter,
+// so agents back off instead of hammering a struggling server in lockstep
+func (c *Client) Pace(base time.Duration) time.Duration {
+    factor := c.transport.Breaker().Slowdown()
+    if factor == 1 {
+        return base
+    }
+    
+    stretched := base * time.Duration(factor)
+    return stretched/2 + time.Duration(rand.Int63n(int64(stretched/2)+1))
 }
 
 // Register tells the server about this agent for the first time
 // Think of this as introducing yourself at a new job
-func (c *Client) Register(ctx context.Context, referral, version string) error {
+// It is also how a device re-enrolls after its session was revoked
+func (c *Client) Register(ctx context.Context, referral string) error {
     payload := map[string]interface{}{
         "email":    c.email,
         "deviceId": c.deviceID,
         "referral": referral,
-        "version":  version,
+        "version":  c.version,
+    }
+    if c.signer != nil {
+        payload["publicKey"] = c.signer.PublicKey()
+        payload["keyAlgorithm"] = "ed25519"
+    }
+    if c.capabilities != nil {
+        payload["capabilities"] = c.capabilities
     }
     
-    response, err := c.doRequest(ctx, "POST", "/api/agent/register", payload)
-    if err != nil {
+    var response tokenResponse
+    if err := c.transport.do(ctx, http.MethodPost, "/api/agent/register", payload, &response, false); err != nil {
         return fmt.Errorf("registration failed: %w", err)
     }
-    defer response.Body.Close()
     
-    if response.StatusCode != http.StatusOK {
-        body, _ := io.ReadAll(response.Body)
-        return fmt.Errorf("registration rejected: %s (status %d)", string(body), response.StatusCode)
+    // Older deployments don't issue tokens; requests then rely on signatures alone
+    if c.session != nil && response.AccessToken != "" {
+        if err := c.session.set(response); err != nil {
+            return fmt.Errorf("registration failed: %w", err)
+        }
     }
     
     return nil
 }
 
-// Beat sends a heartbeat to let the server know we're still alive
-// Like a lighthouse flashing to say "all is well"
-func (c *Client) Beat(ctx context.Context) error {
-    payload := map[string]interface{}{
-        "email":    c.email,
-        "deviceId": c.deviceID,
+// UpdateStatus tells the server where this agent's self-update is up to
+type UpdateStatus struct {
+    State            string  `json:"state"`
+    CurrentVersion   string  `json:"currentVersion"`
+    AvailableVersion string  `json:"availableVersion,omitempty"`
+    Progress         float64 `json:"progress,omitempty"`
+    Error            string  `json:"error,omitempty"`
+}
+
+// Job represents a unit of work from the server
+type Job struct {
+    ID          string            `json:"id"`
+    Type        string            `json:"type"`
+    ArtifactURL string            `json:"artifact_url,omitempty"`
+    SHA256      string            `json:"sha256,omitempty"`
+    Args        json.RawMessage   `json:"args,omitempty"`
+    MaxSeconds  int               `json:"max_seconds"`
+    MemoryMB    int               `json:"mem_mb"`
+    TraceParent string            `json:"traceparent,omitempty"` // W3C trace context of the offer, so our spans join the server's trace
+    TraceState  string            `json:"tracestate,omitempty"`
+}
+
+// GetNextJob asks the server if there's any work available
+// Returns nil if no work is available (this is normal and expected)
+// Polling claims the job it returns, so retries of one poll share a key and
+// the server hands back the same job rather than claiming another
+func (c *Client) GetNextJob(ctx context.Context) (*Job, error) {
+    var claim [16]byte
+    if _, err := cryptorand.Read(claim[:]); err != nil {
+        return nil, fmt.Errorf("failed to generate claim key: %w", err)
     }
+    ctx = withIdempotencyKey(ctx, "claim:"+hex.EncodeToString(claim[:]))
     
-    response, err := c.doRequest(ctx, "POST", "/api/agent/beat", payload)
-    if err != nil {
-        return fmt.Errorf("heartbeat failed: %w", err)
-    }
-    defer response.Body.Close()
+    path := "/api/agent/jobs/next?deviceId=" + url.QueryEscape(c.deviceID)
     
-    if response.StatusCode != http.StatusOK {
-        body, _ := io.ReadAll(response.Body)
-        return fmt.Errorf("heartbeat rejected: %s (status %d)", string(body), response.StatusCode)
+    var response struct {
+        Job *Job `json:"job"`
+    }
+    if err := c.transport.Do(ctx, http.MethodGet, path, nil, &response); err != nil {
+        return nil, fmt.Errorf("job poll failed: %w", err)
     }
     
-    return nil
+    return response.Job, nil
 }
 
-// doRequest is the internal workhorse that actually sends HTTP requests
-// It handles all the details like headers, bypass tokens, and JSON encoding
-func (c *Client) doRequest(ctx context.Context, method, path string, payload interface{}) (*http.Response, error) {
-    // Build the full URL
-    fullURL := c.baseURL + path
+// GetRemoteConfig fetches the signed remote config envelope for this device
+// Returns nil if the server has none; verifying it is up to the caller
+func (c *Client) GetRemoteConfig(ctx context.Context) ([]byte, error) {
+    path := "/api/agent/config?deviceId=" + url.QueryEscape(c.deviceID)
     
-    // Add bypass parameters if we have a token (for protected Vercel deployments)
-    if c.bypass != "" {
-        parsed, err := url.Parse(fullURL)
-        if err != nil {
-            return nil, fmt.Errorf("invalid URL: %w", err)
+    var envelope json.RawMessage
+    if err := c.transport.Do(ctx, http.MethodGet, path, nil, &envelope); err != nil {
+        if IsStatus(err, http.StatusNotFound) {
+            return nil, nil
         }
-        
-        query := parsed.Query()
-        query.Set("x-vercel-set-bypass-cookie", "true")
-        query.Set("x-vercel-protection-bypass", c.bypass)
-        parsed.RawQuery = query.Encode()
-        fullURL = parsed.String()
-    }
-    
-    // Convert the payload to JSON
-    var body io.Reader
-    if payload != nil {
-        jsonData, err := json.Marshal(payload)
-        if err != nil {
-            return nil, fmt.Errorf("failed to encode payload: %w", err)
+        return nil, fmt.Errorf("config fetch failed: %w", err)
+    }
+    
+    return envelope, nil
+}
+
+// GetRateCard fetches the server's current rate card
+// Returns nil if the server hasn't published one
+func (c *Client) GetRateCard(ctx context.Context) ([]byte, error) {
+    path := "/api/agent/ratecard?deviceId=" + url.QueryEscape(c.deviceID)
+    
+    var card json.RawMessage
+    if err := c.transport.Do(ctx, http.MethodGet, path, nil, &card); err != nil {
+        if IsStatus(err, http.StatusNotFound) {
+            return nil, nil
         }
-        body = bytes.NewReader(jsonData)
+        return nil, fmt.Errorf("rate card fetch failed: %w", err)
     }
     
-    // Create the HTTP request
-    request, err := http.NewRequestWithContext(ctx, method, fullURL, body)
-    if err != nil {
-        return nil, fmt.Errorf("failed to create request: %w", err)
+    return card, nil
+}
+
+// Credit is the server's authoritative payment for one job
+type Credit struct {
+    JobID      string    `json:"jobId"`
+    Amount     float64   `json:"amount"`
+    Currency   string    `json:"currency"`
+    CreditedAt time.Time `json:"creditedAt"`
+}
+
+// GetCredits fetches the credits recorded after cursor ("" for all of them)
+// and the cursor to pass next time. Returns no credits if the server doesn't
+// offer them.
+func (c *Client) GetCredits(ctx context.Context, cursor string) ([]Credit, string, error) {
+    query := url.Values{}
+    query.Set("deviceId", c.deviceID)
+    if cursor != "" {
+        query.Set("cursor", cursor)
     }
     
-    // Set headers
-    request.Header.Set("Content-Type", "application/json")
-    request.Header.Set("User-Agent", "IdleNet-Agent/1.0")
+    var response struct {
+        Credits []Credit `json:"credits"`
+        Cursor  string   `json:"cursor"`
+    }
+    if err := c.transport.Do(ctx, http.MethodGet, "/api/agent/credits?"+query.Encode(), nil, &response); err != nil {
+        if IsStatus(err, http.StatusNotFound) {
+            return nil, cursor, nil
+        }
+        return nil, cursor, fmt.Errorf("credits fetch failed: %w", err)
+    }
+    if response.Cursor == "" {
+        response.Cursor = cursor
+    }
+    
+    return response.Credits, response.Cursor, nil
+}
+
+// JobResult is what the agent reports back once a job has finished
+// StartedAt and FinishedAt are our wall clock; the Server* fields are the same
+// instants on the server's clock, when we know its offset. ElapsedSeconds is
+// measured on the monotonic clock and excludes any time spent suspended, so
+// it's the figure to credit rather than FinishedAt minus StartedAt.
+type JobResult struct {
+    JobID            string     `json:"jobId"`
+    Status           string     `json:"status"` // "ok" | "error" | "skipped" | "cancelled"
+    Error            string     `json:"error,omitempty"`
+    StartedAt        time.Time  `json:"startedAt"`
+    FinishedAt       time.Time  `json:"finishedAt"`
+    ServerStartedAt  *time.Time `json:"serverStartedAt,omitempty"`
+    ServerFinishedAt *time.Time `json:"serverFinishedAt,omitempty"`
+    ClockOffsetMs    int64      `json:"clockOffsetMs,omitempty"` // Server minus local when measured
+    ElapsedSeconds   float64    `json:"elapsedSeconds"`
+    SuspendedSeconds float64    `json:"suspendedSeconds,omitempty"`
+    ClockJumpSeconds float64    `json:"clockJumpSeconds,omitempty"` // Wall clock steps during the job
+    CPUSeconds       float64    `json:"cpuSeconds"`
+    UserCPUSeconds   float64    `json:"userCpuSeconds,omitempty"`
+    SystemCPUSeconds float64    `json:"systemCpuSeconds,omitempty"`
+    MemoryMB         int        `json:"memoryMb"` // Peak RSS
+    ReadBytes        uint64     `json:"readBytes,omitempty"`
+    WriteBytes       uint64     `json:"writeBytes,omitempty"`
+    NetRxBytes       uint64     `json:"netRxBytes,omitempty"`
+    NetTxBytes       uint64     `json:"netTxBytes,omitempty"`
+    UsageSource      string     `json:"usageSource,omitempty"` // "thread" or "process": whether usage is the job's alone or the agent's
+    RateCardVersion  int64      `json:"rateCardVersion,omitempty"` // Card our estimate used
     
-    // Add bypass header if we have a token
-    if c.bypass != "" {
-        request.Header.Set("x-vercel-protection-bypass", c.bypass)
+    // The job's trace context, kept with the result in the outbox until it's sent
+    TraceParent      string     `json:"traceparent,omitempty"`
+    TraceState       string     `json:"tracestate,omitempty"`
+}
+
+// SubmitResult reports a finished job so the device can be credited for it
+// Resubmitting the same job is safe; the server keys results by job ID
+func (c *Client) SubmitResult(ctx context.Context, result *JobResult) error {
+    ctx = withIdempotencyKey(ctx, "result:"+result.JobID)
+    payload := map[string]interface{}{
+        "email":    c.email,
+        "deviceId": c.deviceID,
+        "result":   result,
     }
     
-    // Send the request
-    return c.httpClient.Do(request)
+    if err := c.transport.Do(ctx, http.MethodPost, "/api/agent/results", payload, nil); err != nil {
+        return fmt.Errorf("result submission failed: %w", err)
+    }
+    
+    return nil
 }
 
-// Job represents a unit of work from the server
-type Job struct {
-    ID          string            `json:"id"`
-    Type        string            `json:"type"`
-    ArtifactURL string            `json:"artifact_url,omitempty"`
-    SHA256      string            `json:"sha256,omitempty"`
-    Args        json.RawMessage   `json:"args,omitempty"`
-    MaxSeconds  int               `json:"max_seconds"`
-    MemoryMB    int               `json:"mem_mb"`
+// TelemetryEvent is a single metrics record, e.g. a performance sample
+type TelemetryEvent struct {
+    ID        string          `json:"id,omitempty"` // Lets the server drop events it has already stored
+    Kind      string          `json:"kind"`
+    Timestamp time.Time       `json:"ts"`
+    Data      json.RawMessage `json:"data"`
 }
 
-// GetNextJob asks the server if there's any work available
-// Returns nil if no work is available (this is normal and expected)
-func (c *Client) GetNextJob(ctx context.Context) (*Job, error) {
-    // Temporary test job for local testing
-    testJob := &Job{
-        ID:         "test-001",
-        Type:       "sleep",
-        Args:       json.RawMessage(`{"seconds": 5}`),
-        MaxSeconds: 30,
-        MemoryMB:   256,
-    }
-    return testJob, nil
-}
\ No newline at end of file
+// SendTelemetry uploads a batch of metrics records
+func (c *Client) SendTelemetry(ctx context.Context, events []TelemetryEvent) error {
+    payload := map[string]interface{}{
+        "deviceId": c.deviceID,
+        "events":   events,
+    }
+    
+    if err := c.transport.Do(ctx, http.MethodPost, "/api/agent/telemetry", payload, nil); err != nil {
+        return fmt.Errorf("telemetry upload failed: %w", err)
+    }
+    
+    return nil
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    
+    "github.com/klauspost/compress/zstd"
+)
+
+// Request body encodings we can produce, best first
+const (
+    EncodingZstd     = "zstd"
+    EncodingGzip     = "gzip"
+    EncodingIdentity = ""
+)
+
+// compressMin is the smallest body worth compressing; below this the
+// framing overhead eats most of the saving
+const compressMin = 1 << 10
+
+// zstdEncoder is shared; EncodeAll is safe for concurrent use
+var (
+    zstdOnce    sync.Once
+    zstdEncoder *zstd.Encoder
+    zstdErr     error
+)
+
+// negotiator remembers which request encoding the server last said it accepts
+// The server advertises this with Accept-Encoding on its responses (RFC 7694);
+// until it does, bodies go out uncompressed
+type negotiator struct {
+    mu       sync.Mutex
+    encoding string
+}
+
+// observe picks up the server's Accept-Encoding from a response
+// Responses without the header leave the current choice alone
+func (n *negotiator) observe(header http.Header) {
+    values := header.Values("Accept-Encoding")
+    if len(values) == 0 {
+        return
+    }
+    
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    n.encoding = pickEncoding(strings.Join(values, ","))
+}
+
+// reject forgets the negotiated encoding after the server refused it with a 415
+func (n *negotiator) reject() {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    n.encoding = EncodingIdentity
+}
+
+// current returns the encoding to use for a body of size bytes
+func (n *negotiator) current(size int) string {
+    if size < compressMin {
+        return EncodingIdentity
+    }
+    
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    return n.encoding
+}
+
+// pickEncoding chooses the best encoding we support from an Accept-Encoding list
+// Entries with q=0 are refused, anything else counts as accepted
+func pickEncoding(list string) string {
+    accepted := make(map[string]bool)
+    for _, part := range strings.Split(list, ",") {
+        fields := strings.Split(part, ";")
+        name := strings.ToLower(strings.TrimSpace(fields[0]))
+        refused := false
+        for _, param := range fields[1:] {
+            key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+            if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); strings.EqualFold(key, "q") && err == nil && q == 0 {
+                refused = true
+            }
+        }
+        if name != "" && !refused {
+            accepted[name] = true
+        }
+    }
+    
+    for _, encoding := range []string{EncodingZstd, EncodingGzip} {
+        if accepted[encoding] {
+            return encoding
+        }
+    }
+    return EncodingIdentity
+}
+
+// compressBody encodes body with encoding
+func compressBody(encoding string, body []byte) ([]byte, error) {
+    switch encoding {
+    case EncodingZstd:
+        zstdOnce.Do(func() {
+            zstdEncoder, zstdErr = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+        })
+        if zstdErr != nil {
+            return nil, fmt.Errorf("failed to create zstd encoder: %w", zstdErr)
+        }
+        return zstdEncoder.EncodeAll(body, make([]byte, 0, len(body)/2)), nil
+    
+    case EncodingGzip:
+        var buf bytes.Buffer
+        writer := gzip.NewWriter(&buf)
+        if _, err := writer.Write(body); err != nil {
+            return nil, err
+        }
+        if err := writer.Close(); err != nil {
+            return nil, err
+        }
+        return buf.Bytes(), nil
+    
+    case EncodingIdentity:
+        return body, nil
+    }
+    return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
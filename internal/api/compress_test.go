@@ -0,0 +1,160 @@
+package api
+
+import (
+    "compress/gzip"
+    "context"
+    "encoding/json"
+    "io"
+    "net/http"
+    "strings"
+    "sync"
+    "testing"
+    
+    "github.com/klauspost/compress/zstd"
+)
+
+// encodingServer records the Content-Encoding of telemetry uploads and
+// checks that each body decodes as JSON
+type encodingServer struct {
+    t      *testing.T
+    accept string // Advertised in Accept-Encoding, "" for none
+    refuse bool   // Answer compressed bodies with 415
+    mu     sync.Mutex
+    seen   []string
+}
+
+func (s *encodingServer) handle(w http.ResponseWriter, r *http.Request) {
+    if s.accept != "" {
+        w.Header().Set("Accept-Encoding", s.accept)
+    }
+    
+    encoding := r.Header.Get("Content-Encoding")
+    s.mu.Lock()
+    s.seen = append(s.seen, encoding)
+    s.mu.Unlock()
+    
+    if encoding != "" && s.refuse {
+        w.WriteHeader(http.StatusUnsupportedMediaType)
+        return
+    }
+    
+    var reader io.Reader = r.Body
+    switch encoding {
+    case "gzip":
+        gz, err := gzip.NewReader(r.Body)
+        if err != nil {
+            s.t.Errorf("gzip body: %v", err)
+            w.WriteHeader(http.StatusBadRequest)
+            return
+        }
+        reader = gz
+    case "zstd":
+        zr, err := zstd.NewReader(r.Body)
+        if err != nil {
+            s.t.Errorf("zstd body: %v", err)
+            w.WriteHeader(http.StatusBadRequest)
+            return
+        }
+        defer zr.Close()
+        reader = zr
+    }
+    
+    var payload struct {
+        Events []TelemetryEvent `json:"events"`
+    }
+    if err := json.NewDecoder(reader).Decode(&payload); err != nil {
+        s.t.Errorf("decode %q body: %v", encoding, err)
+        w.WriteHeader(http.StatusBadRequest)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *encodingServer) encodings() []string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]string(nil), s.seen...)
+}
+
+// bigBatch is comfortably over compressMin
+func bigBatch() []TelemetryEvent {
+    events := make([]TelemetryEvent, 50)
+    for i := range events {
+        events[i] = TelemetryEvent{Kind: "performance", Data: json.RawMessage(`{"cpu":12.5,"memory":40.1}`)}
+    }
+    return events
+}
+
+func TestCompressionNegotiated(t *testing.T) {
+    tests := []struct {
+        accept string
+        want   string
+    }{
+        {"zstd, gzip", "zstd"},
+        {"gzip", "gzip"},
+        {"gzip, zstd;q=0", "gzip"},
+        {"identity", ""},
+        {"", ""},
+    }
+    
+    for _, tt := range tests {
+        server := &encodingServer{t: t, accept: tt.accept}
+        client := newTestClient(t, server.handle)
+    
+        // The first request teaches the client what the server accepts
+        for i := 0; i < 2; i++ {
+            if err := client.SendTelemetry(context.Background(), bigBatch()); err != nil {
+                t.Fatalf("accept %q: SendTelemetry: %v", tt.accept, err)
+            }
+        }
+    
+        got := server.encodings()
+        if got[0] != "" {
+            t.Errorf("accept %q: first request encoded %q before negotiating", tt.accept, got[0])
+        }
+        if got[1] != tt.want {
+            t.Errorf("accept %q: second request encoded %q, want %q", tt.accept, got[1], tt.want)
+        }
+    }
+}
+
+func TestCompressionSkipsSmallBodies(t *testing.T) {
+    server := &encodingServer{t: t, accept: "zstd, gzip"}
+    client := newTestClient(t, server.handle)
+    
+    small := []TelemetryEvent{{Kind: "job"}}
+    for i := 0; i < 2; i++ {
+        if err := client.SendTelemetry(context.Background(), small); err != nil {
+            t.Fatalf("SendTelemetry: %v", err)
+        }
+    }
+    for _, encoding := range server.encodings() {
+        if encoding != "" {
+            t.Errorf("small body sent with Content-Encoding %q", encoding)
+        }
+    }
+}
+
+func TestCompressionFallsBackOnUnsupportedMediaType(t *testing.T) {
+    server := &encodingServer{t: t, accept: "gzip"}
+    client := newTestClient(t, server.handle)
+    if err := client.SendTelemetry(context.Background(), bigBatch()); err != nil {
+        t.Fatalf("SendTelemetry: %v", err)
+    }
+    
+    // Something in front of the API stops taking compressed bodies and
+    // no longer advertises them
+    server.refuse = true
+    server.accept = ""
+    if err := client.SendTelemetry(context.Background(), bigBatch()); err != nil {
+        t.Fatalf("SendTelemetry after 415: %v", err)
+    }
+    if err := client.SendTelemetry(context.Background(), bigBatch()); err != nil {
+        t.Fatalf("SendTelemetry: %v", err)
+    }
+    
+    got := strings.Join(server.encodings(), ",")
+    if want := ",gzip,,"; got != want {
+        t.Errorf("encodings = %q, want %q", got, want)
+    }
+}
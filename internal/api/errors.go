@@ -0,0 +1,52 @@
+package api
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// APIError is returned when the server answers with a non-2xx status
+type APIError struct {
+    Method     string
+    Path       string
+    StatusCode int
+    Status     string
+    Body       string // First few KB of the response, for diagnostics
+}
+
+func newAPIError(method, path string, response *http.Response) *APIError {
+    slurp, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+    return &APIError{
+        Method:     method,
+        Path:       path,
+        StatusCode: response.StatusCode,
+        Status:     response.Status,
+        Body:       strings.TrimSpace(string(slurp)),
+    }
+}
+
+func (e *APIError) Error() string {
+    if e.Body == "" {
+        return fmt.Sprintf("%s %s -> %s", e.Method, e.Path, e.Status)
+    }
+    return fmt.Sprintf("%s %s -> %s: %s", e.Method, e.Path, e.Status, e.Body)
+}
+
+// Temporary reports whether the same request might succeed if retried
+func (e *APIError) Temporary() bool {
+    switch e.StatusCode {
+    case http.StatusTooManyRequests, http.StatusBadGateway,
+        http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+        return true
+    }
+    return false
+}
+
+// IsStatus reports whether err is an APIError with the given status code
+func IsStatus(err error, code int) bool {
+    var apiErr *APIError
+    return errors.As(err, &apiErr) && apiErr.StatusCode == code
+}
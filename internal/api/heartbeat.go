@@ -0,0 +1,112 @@
+package api
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "runtime"
+    "time"
+)
+
+// HeartbeatSchemaVersion is bumped whenever Heartbeat changes incompatibly
+const HeartbeatSchemaVersion = 1
+
+// Heartbeat is everything the scheduler needs to know about this agent right now
+type Heartbeat struct {
+    Schema       int    `json:"schema"`
+    Email        string `json:"email"`
+    DeviceID     string `json:"deviceId"`
+    AgentVersion string `json:"agentVersion"`
+    OS           string `json:"os"`
+    Arch         string `json:"arch"`
+    
+    Cores         int           `json:"cores"`
+    AllowedCores  int           `json:"allowedCores"`
+    Limits        Limits        `json:"limits"`
+    Idle          IdleState     `json:"idle"`
+    RunningJobs   []string      `json:"runningJobs"`
+    FreeDisk      uint64        `json:"freeDiskBytes"`
+    JobTypes      []string      `json:"jobTypes"`
+    Cache         []CacheEntry  `json:"cache"`
+    Update        *UpdateStatus `json:"update,omitempty"`
+    ConfigVersion int64         `json:"configVersion,omitempty"` // Remote config in use, 0 for none
+    RateCard      int64         `json:"rateCardVersion,omitempty"` // Rate card used for estimates, 0 for built-in
+    Capabilities  *Capabilities `json:"capabilities,omitempty"`    // Benchmark profile, once the device has been measured
+}
+
+// Capabilities say how fast this device is, so the server can size jobs to it
+// Scores are for one core; multiply by AllowedCores for what the agent may use
+type Capabilities struct {
+    AgentVersion string    `json:"agentVersion"` // Agent that measured them
+    MeasuredAt   time.Time `json:"measuredAt"`
+    CPUModel     string    `json:"cpuModel,omitempty"`
+    MemoryMB     uint64    `json:"memoryMb,omitempty"`
+    HashMBps     float64   `json:"hashMBps"`
+    FloatMflops  float64   `json:"floatMflops"`
+    MemoryMBps   float64   `json:"memoryMBps"`
+    WASMMips     float64   `json:"wasmMips,omitempty"` // 0 when this build can't run WASM
+}
+
+// Limits are the resource ceilings currently applied by resource.Manager
+type Limits struct {
+    CPUPercent    int `json:"cpuPercent"`
+    MemoryPercent int `json:"memoryPercent"`
+}
+
+// IdleState describes how busy the user is and whether the agent is paused
+type IdleState struct {
+    IdleSeconds   float64 `json:"idleSeconds"`
+    ActivityLevel int     `json:"activityLevel"` // 0 = very active, 100 = completely idle
+    Paused        bool    `json:"paused"`
+}
+
+// CacheEntry is one artifact the agent already has locally
+type CacheEntry struct {
+    Key  string `json:"key"`
+    Size int64  `json:"size"`
+}
+
+// Directive types the server may send back in a heartbeat response
+const (
+    DirectivePause       = "pause"        // Stop taking jobs for Seconds (0 = until resumed)
+    DirectiveResume      = "resume"       // Lift a pause early
+    DirectiveSetInterval = "set_interval" // Change the heartbeat interval to Seconds
+    DirectiveDropCache   = "drop_cache"   // Delete cached artifacts
+    DirectiveUpdateNow   = "update_now"   // Check for and apply an update as soon as jobs drain
+)
+
+// Directive is an instruction from the server carried on a heartbeat response
+type Directive struct {
+    Type    string `json:"type"`
+    Seconds int    `json:"seconds,omitempty"`
+}
+
+// HeartbeatResponse is what the server sends back to a heartbeat
+type HeartbeatResponse struct {
+    Directives []Directive `json:"directives,omitempty"`
+}
+
+// Beat sends a heartbeat to let the server know we're still alive
+// Like a lighthouse flashing to say "all is well"
+// Identity and platform fields are filled in here; callers supply live state
+func (c *Client) Beat(ctx context.Context, hb *Heartbeat) (*HeartbeatResponse, error) {
+    hb.Schema = HeartbeatSchemaVersion
+    hb.Email = c.email
+    hb.DeviceID = c.deviceID
+    hb.AgentVersion = c.version
+    hb.OS = runtime.GOOS
+    hb.Arch = runtime.GOARCH
+    if hb.Cores == 0 {
+        hb.Cores = runtime.NumCPU()
+    }
+    if hb.Capabilities == nil {
+        hb.Capabilities = c.capabilities
+    }
+    
+    var response HeartbeatResponse
+    if err := c.transport.Do(ctx, http.MethodPost, "/api/agent/beat", hb, &response); err != nil {
+        return nil, fmt.Errorf("heartbeat failed: %w", err)
+    }
+    
+    return &response, nil
+}
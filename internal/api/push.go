@@ -0,0 +1,145 @@
+package api
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Event types the server can push
+const (
+    EventJobOffer  = "job_offer"  // Work is waiting; claim it with GetNextJob
+    EventJobCancel = "job_cancel" // Stop JobID and report it as cancelled
+    EventDirective = "directive"  // Same directives a heartbeat response carries
+)
+
+// Event is a single message on the push channel
+type Event struct {
+    Type      string     `json:"type"`
+    JobID     string     `json:"jobId,omitempty"`
+    Directive *Directive `json:"directive,omitempty"`
+}
+
+// ErrPushUnsupported means the server has no push endpoint, so the agent should poll
+var ErrPushUnsupported = errors.New("server does not support push")
+
+const (
+    // pushWait is how long the server may hold a poll open; it doubles as
+    // the keepalive since an empty response proves the path is still up.
+    // It stays under the transport's 30s client timeout.
+    pushWait = 25 * time.Second
+    
+    // pushRecheck is how often to look for a push endpoint on servers without one
+    pushRecheck = 10 * time.Minute
+)
+
+// WaitEvents long-polls for events after cursor, returning the new cursor
+// An empty batch means the wait expired with nothing to deliver
+func (c *Client) WaitEvents(ctx context.Context, cursor string, wait time.Duration) ([]Event, string, error) {
+    query := url.Values{}
+    query.Set("deviceId", c.deviceID)
+    query.Set("wait", strconv.Itoa(int(wait/time.Second)))
+    if cursor != "" {
+        query.Set("cursor", cursor)
+    }
+    
+    var response struct {
+        Events []Event `json:"events"`
+        Cursor string  `json:"cursor"`
+    }
+    err := c.transport.Do(ctx, http.MethodGet, "/api/agent/events?"+query.Encode(), nil, &response)
+    if IsStatus(err, http.StatusNotFound) || IsStatus(err, http.StatusNotImplemented) {
+        return nil, cursor, ErrPushUnsupported
+    }
+    if err != nil {
+        return nil, cursor, fmt.Errorf("event poll failed: %w", err)
+    }
+    
+    if response.Cursor == "" {
+        response.Cursor = cursor
+    }
+    return response.Events, response.Cursor, nil
+}
+
+// PushChannel keeps a long-poll open to the server, reconnecting as needed
+// Callers should keep polling for jobs while Connected is false
+type PushChannel struct {
+    client *Client
+    events chan Event
+    sleep  func(context.Context, time.Duration) error
+    
+    mu        sync.Mutex
+    connected bool
+}
+
+// NewPushChannel creates a push channel; call Run to start it
+func (c *Client) NewPushChannel() *PushChannel {
+    return &PushChannel{
+        client: c,
+        events: make(chan Event, 16),
+        sleep:  sleepContext,
+    }
+}
+
+// Events delivers pushed events in order
+func (p *PushChannel) Events() <-chan Event {
+    return p.events
+}
+
+// Connected reports whether the last poll succeeded
+func (p *PushChannel) Connected() bool {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return p.connected
+}
+
+func (p *PushChannel) setConnected(connected bool) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.connected = connected
+}
+
+// Run polls until ctx is cancelled, backing off after failures
+// A revoked session just keeps the channel down until the agent re-enrolls
+func (p *PushChannel) Run(ctx context.Context) {
+    defer p.setConnected(false)
+    
+    retry := DefaultRetryPolicy()
+    cursor := ""
+    failures := 0
+    for ctx.Err() == nil {
+        events, next, err := p.client.WaitEvents(ctx, cursor, pushWait)
+        if err != nil {
+            if ctx.Err() != nil {
+                return
+            }
+            p.setConnected(false)
+            
+            delay := retry.Backoff(failures)
+            if errors.Is(err, ErrPushUnsupported) {
+                delay = pushRecheck
+            }
+            failures++
+            if p.sleep(ctx, delay) != nil {
+                return
+            }
+            continue
+        }
+        
+        p.setConnected(true)
+        failures = 0
+        cursor = next
+        for _, event := range events {
+            select {
+            case p.events <- event:
+            case <-ctx.Done():
+                return
+            }
+        }
+    }
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestWaitEventsAdvancesCursor(t *testing.T) {
+    var gotCursor, gotWait string
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        gotCursor = r.URL.Query().Get("cursor")
+        gotWait = r.URL.Query().Get("wait")
+        w.Write([]byte(`{"cursor":"8","events":[{"type":"job_cancel","jobId":"j1"},{"type":"directive","directive":{"type":"pause"}}]}`))
+    })
+    
+    events, cursor, err := client.WaitEvents(context.Background(), "7", 25*time.Second)
+    if err != nil {
+        t.Fatalf("WaitEvents: %v", err)
+    }
+    if gotCursor != "7" || gotWait != "25" {
+        t.Errorf("cursor=%q wait=%q", gotCursor, gotWait)
+    }
+    if cursor != "8" {
+        t.Errorf("cursor = %q, want 8", cursor)
+    }
+    if len(events) != 2 || events[0].JobID != "j1" || events[1].Directive == nil || events[1].Directive.Type != DirectivePause {
+        t.Errorf("events = %+v", events)
+    }
+}
+
+func TestWaitEventsUnsupported(t *testing.T) {
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusNotFound)
+    })
+    
+    _, cursor, err := client.WaitEvents(context.Background(), "3", time.Second)
+    if !errors.Is(err, ErrPushUnsupported) {
+        t.Fatalf("err = %v, want ErrPushUnsupported", err)
+    }
+    if cursor != "3" {
+        t.Errorf("cursor = %q, want it kept", cursor)
+    }
+}
+
+func TestPushChannelDeliversAndReconnects(t *testing.T) {
+    polls := make(chan string, 10)
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        cursor := r.URL.Query().Get("cursor")
+        polls <- cursor
+        switch cursor {
+        case "":
+            w.Write([]byte(`{"cursor":"1","events":[{"type":"job_offer"}]}`))
+        case "1":
+            // A dropped connection; the channel should come back with the same cursor
+            w.WriteHeader(http.StatusBadRequest)
+        default:
+            <-r.Context().Done()
+        }
+    })
+    
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    push := client.NewPushChannel()
+    push.sleep = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+    go push.Run(ctx)
+    
+    select {
+    case event := <-push.Events():
+        if event.Type != EventJobOffer {
+            t.Errorf("event = %+v", event)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("no event delivered")
+    }
+    
+    // First poll, the failed poll, then a retry that resumes from cursor 1
+    for i, want := range []string{"", "1", "1"} {
+        select {
+        case got := <-polls:
+            if got != want {
+                t.Errorf("poll %d cursor = %q, want %q", i, got, want)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatalf("poll %d never happened", i)
+        }
+    }
+}
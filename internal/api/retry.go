@@ -0,0 +1,66 @@
+package api
+
+import (
+    "math/rand"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// RetryPolicy controls how transient failures are retried
+// Delays use exponential backoff with full jitter so a fleet of agents that
+// failed together doesn't retry together
+type RetryPolicy struct {
+    MaxAttempts int           // Total tries including the first
+    BaseDelay   time.Duration // Upper bound of the first retry delay
+    MaxDelay    time.Duration // Cap on any single delay, including Retry-After
+}
+
+// DefaultRetryPolicy is used by NewTransport
+func DefaultRetryPolicy() RetryPolicy {
+    return RetryPolicy{
+        MaxAttempts: 3,
+        BaseDelay:   500 * time.Millisecond,
+        MaxDelay:    30 * time.Second,
+    }
+}
+
+// Backoff returns a random delay in [0, min(MaxDelay, BaseDelay*2^retry))
+// retry counts from 0 for the first retry
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+    ceiling := p.MaxDelay
+    if retry < 32 {
+        if d := p.BaseDelay << uint(retry); d > 0 && d < ceiling {
+            ceiling = d
+        }
+    }
+    if ceiling <= 0 {
+        return 0
+    }
+    return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// retryAfter parses a Retry-After header, which is either delta-seconds or an HTTP date
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+    value := strings.TrimSpace(header.Get("Retry-After"))
+    if value == "" {
+        return 0, false
+    }
+    
+    if seconds, err := strconv.Atoi(value); err == nil {
+        if seconds < 0 {
+            return 0, false
+        }
+        return time.Duration(seconds) * time.Second, true
+    }
+    
+    if when, err := http.ParseTime(value); err == nil {
+        if d := when.Sub(now); d > 0 {
+            return d, true
+        }
+        return 0, true
+    }
+    
+    return 0, false
+}
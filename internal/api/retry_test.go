@@ -0,0 +1,256 @@
+package api
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestBackoffFullJitterBounds(t *testing.T) {
+    policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+    
+    for retry, ceiling := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+        ceiling *= time.Millisecond
+        for i := 0; i < 200; i++ {
+            if d := policy.Backoff(retry); d < 0 || d >= ceiling {
+                t.Fatalf("Backoff(%d) = %v, want [0, %v)", retry, d, ceiling)
+            }
+        }
+    }
+}
+
+func TestRetryAfterParsing(t *testing.T) {
+    now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+    
+    tests := []struct {
+        value string
+        want  time.Duration
+        ok    bool
+    }{
+        {"", 0, false},
+        {"7", 7 * time.Second, true},
+        {"-1", 0, false},
+        {now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+        {now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+        {"soon", 0, false},
+    }
+    
+    for _, tt := range tests {
+        header := http.Header{}
+        if tt.value != "" {
+            header.Set("Retry-After", tt.value)
+        }
+        got, ok := retryAfter(header, now)
+        if got != tt.want || ok != tt.ok {
+            t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+        }
+    }
+}
+
+// recordingClient returns a client whose retry sleeps are recorded and
+// advance the breaker's clock instead of actually sleeping
+func recordingClient(t *testing.T, handler http.HandlerFunc) (*Client, *[]time.Duration) {
+    t.Helper()
+    server := httptest.NewServer(handler)
+    t.Cleanup(server.Close)
+    
+    clock := &fakeClock{t: time.Now()}
+    var slept []time.Duration
+    client := NewClient(server.URL, "test", "user@example.com", "device-abc")
+    client.transport.breaker.now = clock.now
+    client.transport.sleep = func(_ context.Context, d time.Duration) error {
+        slept = append(slept, d)
+        clock.t = clock.t.Add(d)
+        return nil
+    }
+    return client, &slept
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+    var calls atomic.Int32
+    client, slept := recordingClient(t, func(w http.ResponseWriter, r *http.Request) {
+        if calls.Add(1) == 1 {
+            w.Header().Set("Retry-After", "2")
+            w.WriteHeader(http.StatusTooManyRequests)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    })
+    
+    if _, err := client.GetNextJob(context.Background()); err != nil {
+        t.Fatalf("GetNextJob: %v", err)
+    }
+    if len(*slept) != 1 || (*slept)[0] != 2*time.Second {
+        t.Errorf("slept %v, want [2s]", *slept)
+    }
+}
+
+func TestRetryAfterBeyondDeadlineGivesUp(t *testing.T) {
+    var calls atomic.Int32
+    client, slept := recordingClient(t, func(w http.ResponseWriter, r *http.Request) {
+        calls.Add(1)
+        w.Header().Set("Retry-After", "20")
+        w.WriteHeader(http.StatusServiceUnavailable)
+    })
+    
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    
+    _, err := client.GetNextJob(ctx)
+    if !IsStatus(err, http.StatusServiceUnavailable) {
+        t.Fatalf("err = %v, want 503", err)
+    }
+    if calls.Load() != 1 || len(*slept) != 0 {
+        t.Errorf("calls = %d, slept %v; want a single call and no wait", calls.Load(), *slept)
+    }
+}
+
+func TestRetryExhaustsAttemptsWithBackoff(t *testing.T) {
+    var calls atomic.Int32
+    client, slept := recordingClient(t, func(w http.ResponseWriter, r *http.Request) {
+        calls.Add(1)
+        w.WriteHeader(http.StatusBadGateway)
+    })
+    client.transport.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: time.Second})
+    client.transport.breaker.Threshold = 10
+    
+    _, err := client.GetNextJob(context.Background())
+    if !IsStatus(err, http.StatusBadGateway) {
+        t.Fatalf("err = %v, want 502", err)
+    }
+    if calls.Load() != 3 || len(*slept) != 2 {
+        t.Fatalf("calls = %d, sleeps = %d; want 3 and 2", calls.Load(), len(*slept))
+    }
+    if (*slept)[0] >= 10*time.Millisecond || (*slept)[1] >= 20*time.Millisecond {
+        t.Errorf("sleeps %v exceed backoff ceilings", *slept)
+    }
+}
+
+func TestCircuitOpensAndShortCircuits(t *testing.T) {
+    var calls atomic.Int32
+    client, _ := recordingClient(t, func(w http.ResponseWriter, r *http.Request) {
+        calls.Add(1)
+        w.WriteHeader(http.StatusServiceUnavailable)
+    })
+    client.transport.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+    
+    for i := 0; i < 3; i++ {
+        client.GetNextJob(context.Background())
+    }
+    if client.Healthy() {
+        t.Fatal("breaker should be open after 3 consecutive failures")
+    }
+    
+    _, err := client.GetNextJob(context.Background())
+    if !errors.Is(err, ErrCircuitOpen) {
+        t.Errorf("err = %v, want ErrCircuitOpen", err)
+    }
+    if n := calls.Load(); n != 3 {
+        t.Errorf("server saw %d calls, want 3 (the 4th should not reach it)", n)
+    }
+    if pace := client.Pace(20 * time.Second); pace < 20*time.Second {
+        t.Errorf("Pace while unhealthy = %v, want at least the base interval", pace)
+    }
+}
+
+func TestClientErrorsDoNotTripBreaker(t *testing.T) {
+    client, _ := recordingClient(t, func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusBadRequest)
+    })
+    
+    for i := 0; i < 5; i++ {
+        client.GetNextJob(context.Background())
+    }
+    if !client.Healthy() {
+        t.Error("4xx responses should not open the breaker")
+    }
+}
+
+func TestCancelledProbeDoesNotWedgeBreaker(t *testing.T) {
+    var mode atomic.Int32 // 0 failing, 1 slow, 2 healthy
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch mode.Load() {
+        case 0:
+            w.WriteHeader(http.StatusServiceUnavailable)
+        case 1:
+            select {
+            case <-time.After(time.Second):
+            case <-r.Context().Done():
+            }
+            w.WriteHeader(http.StatusNoContent)
+        default:
+            w.WriteHeader(http.StatusNoContent)
+        }
+    }))
+    t.Cleanup(server.Close)
+    
+    clock := &fakeClock{t: time.Now()}
+    client := NewClient(server.URL, "test", "user@example.com", "device-abc")
+    client.transport.breaker.now = clock.now
+    client.transport.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+    
+    // Enough failures to open the breaker
+    for i := 0; i < 3; i++ {
+        client.GetNextJob(context.Background())
+    }
+    if client.Healthy() {
+        t.Fatal("breaker should be open after repeated 503s")
+    }
+    
+    // The cooldown passes and the probe gives up before the slow server answers
+    clock.t = clock.t.Add(time.Hour)
+    mode.Store(1)
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    _, err := client.GetNextJob(ctx)
+    cancel()
+    if !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("probe error = %v, want deadline exceeded", err)
+    }
+    
+    // A day later the server is fine and requests must get through again
+    clock.t = clock.t.Add(24 * time.Hour)
+    mode.Store(2)
+    if _, err := client.GetNextJob(context.Background()); err != nil {
+        t.Fatalf("request after a cancelled probe: %v", err)
+    }
+    if !client.Healthy() {
+        t.Error("breaker should close once a probe succeeds")
+    }
+}
+
+func TestOnlyReplayableRequestsRetry(t *testing.T) {
+    var polls, beats atomic.Int32
+    claims := map[string]bool{}
+    var mu sync.Mutex
+    client, _ := recordingClient(t, func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/api/agent/jobs/next":
+            mu.Lock()
+            claims[r.Header.Get("Idempotency-Key")] = true
+            mu.Unlock()
+            polls.Add(1)
+        case "/api/agent/beat":
+            beats.Add(1)
+        }
+        w.WriteHeader(http.StatusBadGateway)
+    })
+    client.transport.breaker.Threshold = 10
+    
+    client.GetNextJob(context.Background())
+    if polls.Load() != 3 {
+        t.Errorf("job poll sent %d times, want 3", polls.Load())
+    }
+    if len(claims) != 1 || claims[""] {
+        t.Errorf("claim keys %v, want one key shared by every retry", claims)
+    }
+    
+    client.Beat(context.Background(), &Heartbeat{})
+    if beats.Load() != 1 {
+        t.Errorf("heartbeat without a key sent %d times, want 1", beats.Load())
+    }
+}
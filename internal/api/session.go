@@ -0,0 +1,162 @@
+package api
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// ErrSessionRevoked means the server no longer accepts this device's refresh
+// token (or never issued one) and the agent has to register again
+var ErrSessionRevoked = errors.New("device session revoked, re-registration required")
+
+// refreshMargin renews access tokens slightly before they expire
+const refreshMargin = 30 * time.Second
+
+// TokenStore persists the long-lived refresh token between runs
+// Access tokens are short-lived and only ever kept in memory
+type TokenStore interface {
+    LoadRefreshToken() (string, error)
+    SaveRefreshToken(token string) error
+    ClearRefreshToken() error
+}
+
+// tokenResponse is returned by registration and token refresh
+type tokenResponse struct {
+    AccessToken  string `json:"accessToken"`
+    RefreshToken string `json:"refreshToken"`
+    ExpiresIn    int    `json:"expiresIn"` // Seconds until the access token expires
+}
+
+// Session holds the device's tokens and renews them through the transport
+type Session struct {
+    transport *Transport
+    store     TokenStore
+    deviceID  string
+    now       func() time.Time
+    
+    mu      sync.Mutex
+    access  string
+    expires time.Time
+    refresh string
+    loaded  bool
+}
+
+func newSession(transport *Transport, store TokenStore, deviceID string) *Session {
+    return &Session{
+        transport: transport,
+        store:     store,
+        deviceID:  deviceID,
+        now:       time.Now,
+    }
+}
+
+// Token implements Authenticator
+func (s *Session) Token(ctx context.Context) (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    
+    if s.access != "" && s.now().Add(refreshMargin).Before(s.expires) {
+        return s.access, nil
+    }
+    
+    if err := s.loadLocked(); err != nil {
+        return "", err
+    }
+    if s.refresh == "" {
+        // Not enrolled yet; let the request go out and the server decide
+        return "", nil
+    }
+    
+    if err := s.refreshLocked(ctx); err != nil {
+        return "", err
+    }
+    return s.access, nil
+}
+
+// Refresh implements Authenticator
+func (s *Session) Refresh(ctx context.Context) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    
+    if err := s.loadLocked(); err != nil {
+        return err
+    }
+    return s.refreshLocked(ctx)
+}
+
+// refreshLocked trades the refresh token for a new access token
+// Servers may rotate the refresh token; the new one replaces the old in the store
+func (s *Session) refreshLocked(ctx context.Context) error {
+    if s.refresh == "" {
+        return ErrSessionRevoked
+    }
+    
+    payload := map[string]interface{}{
+        "deviceId":     s.deviceID,
+        "refreshToken": s.refresh,
+    }
+    
+    var response tokenResponse
+    err := s.transport.do(withoutBreaker(ctx), http.MethodPost, "/api/agent/token/refresh", payload, &response, false)
+    if IsStatus(err, http.StatusUnauthorized) || IsStatus(err, http.StatusForbidden) {
+        s.clearLocked()
+        return fmt.Errorf("%w: %v", ErrSessionRevoked, err)
+    }
+    if err != nil {
+        return fmt.Errorf("token refresh failed: %w", err)
+    }
+    
+    return s.setLocked(response)
+}
+
+// set installs tokens issued at registration
+func (s *Session) set(response tokenResponse) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    
+    s.loaded = true
+    return s.setLocked(response)
+}
+
+func (s *Session) setLocked(response tokenResponse) error {
+    if response.AccessToken == "" {
+        return fmt.Errorf("server issued no access token")
+    }
+    
+    s.access = response.AccessToken
+    s.expires = s.now().Add(time.Duration(response.ExpiresIn) * time.Second)
+    
+    if response.RefreshToken != "" && response.RefreshToken != s.refresh {
+        s.refresh = response.RefreshToken
+        if err := s.store.SaveRefreshToken(s.refresh); err != nil {
+            return fmt.Errorf("failed to store refresh token: %w", err)
+        }
+    }
+    
+    return nil
+}
+
+func (s *Session) loadLocked() error {
+    if s.loaded {
+        return nil
+    }
+    
+    token, err := s.store.LoadRefreshToken()
+    if err != nil {
+        return fmt.Errorf("failed to load refresh token: %w", err)
+    }
+    s.refresh = token
+    s.loaded = true
+    return nil
+}
+
+func (s *Session) clearLocked() {
+    s.access = ""
+    s.expires = time.Time{}
+    s.refresh = ""
+    s.store.ClearRefreshToken()
+}
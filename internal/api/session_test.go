@@ -0,0 +1,174 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sync"
+    "testing"
+)
+
+type memTokenStore struct {
+    mu    sync.Mutex
+    token string
+}
+
+func (m *memTokenStore) LoadRefreshToken() (string, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.token, nil
+}
+
+func (m *memTokenStore) SaveRefreshToken(token string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.token = token
+    return nil
+}
+
+func (m *memTokenStore) ClearRefreshToken() error {
+    return m.SaveRefreshToken("")
+}
+
+// tokenServer issues "access-N"/"refresh-N" pairs and accepts only the latest access token
+type tokenServer struct {
+    mu       sync.Mutex
+    issued   int
+    access   string
+    refresh  string
+    revoked  bool
+    refreshs int // Refresh calls seen
+}
+
+func (s *tokenServer) issue(w http.ResponseWriter) {
+    s.issued++
+    s.access = "access-" + string(rune('0'+s.issued))
+    s.refresh = "refresh-" + string(rune('0'+s.issued))
+    json.NewEncoder(w).Encode(tokenResponse{AccessToken: s.access, RefreshToken: s.refresh, ExpiresIn: 900})
+}
+
+func (s *tokenServer) handle(w http.ResponseWriter, r *http.Request) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    
+    switch r.URL.Path {
+    case "/api/agent/register":
+        s.revoked = false
+        s.issue(w)
+    case "/api/agent/token/refresh":
+        s.refreshs++
+        var req struct {
+            RefreshToken string `json:"refreshToken"`
+        }
+        json.NewDecoder(r.Body).Decode(&req)
+        if s.revoked || req.RefreshToken != s.refresh {
+            w.WriteHeader(http.StatusUnauthorized)
+            return
+        }
+        s.issue(w)
+    default:
+        if r.Header.Get("Authorization") != "Bearer "+s.access || s.revoked {
+            w.WriteHeader(http.StatusUnauthorized)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+func TestSessionRegisterStoresRefreshToken(t *testing.T) {
+    server := &tokenServer{}
+    client := newTestClient(t, server.handle)
+    store := &memTokenStore{}
+    client.SetTokenStore(store)
+    
+    if err := client.Register(context.Background(), ""); err != nil {
+        t.Fatalf("Register: %v", err)
+    }
+    if store.token != "refresh-1" {
+        t.Errorf("stored refresh token = %q", store.token)
+    }
+    if _, err := client.GetNextJob(context.Background()); err != nil {
+        t.Fatalf("GetNextJob with fresh token: %v", err)
+    }
+    if server.refreshs != 0 {
+        t.Errorf("refreshed %d times with a valid token", server.refreshs)
+    }
+}
+
+func TestSessionRefreshesOnUnauthorized(t *testing.T) {
+    server := &tokenServer{}
+    client := newTestClient(t, server.handle)
+    store := &memTokenStore{}
+    client.SetTokenStore(store)
+    
+    if err := client.Register(context.Background(), ""); err != nil {
+        t.Fatalf("Register: %v", err)
+    }
+    
+    // Server-side expiry: the agent's token no longer matches
+    server.mu.Lock()
+    server.access = "rotated-elsewhere"
+    server.mu.Unlock()
+    
+    // The 401 triggers a refresh and the request is replayed with access-2
+    if _, err := client.GetNextJob(context.Background()); err != nil {
+        t.Fatalf("GetNextJob: %v", err)
+    }
+    if server.refreshs != 1 {
+        t.Errorf("refreshes = %d, want 1", server.refreshs)
+    }
+    if store.token != "refresh-2" {
+        t.Errorf("rotated refresh token not stored, have %q", store.token)
+    }
+}
+
+func TestSessionResumesFromStoredRefreshToken(t *testing.T) {
+    server := &tokenServer{}
+    first := newTestClient(t, server.handle)
+    store := &memTokenStore{}
+    first.SetTokenStore(store)
+    if err := first.Register(context.Background(), ""); err != nil {
+        t.Fatalf("Register: %v", err)
+    }
+    
+    // A restarted agent has no access token, only the stored refresh token
+    restarted := newTestClient(t, server.handle)
+    restarted.SetTokenStore(store)
+    if _, err := restarted.GetNextJob(context.Background()); err != nil {
+        t.Fatalf("GetNextJob after restart: %v", err)
+    }
+    if server.refreshs != 1 {
+        t.Errorf("refreshes = %d, want 1", server.refreshs)
+    }
+}
+
+func TestSessionRevocation(t *testing.T) {
+    server := &tokenServer{}
+    client := newTestClient(t, server.handle)
+    store := &memTokenStore{}
+    client.SetTokenStore(store)
+    if err := client.Register(context.Background(), ""); err != nil {
+        t.Fatalf("Register: %v", err)
+    }
+    
+    server.mu.Lock()
+    server.revoked = true
+    server.mu.Unlock()
+    
+    _, err := client.GetNextJob(context.Background())
+    if !errors.Is(err, ErrSessionRevoked) {
+        t.Fatalf("err = %v, want ErrSessionRevoked", err)
+    }
+    if store.token != "" {
+        t.Errorf("revoked refresh token still stored: %q", store.token)
+    }
+    
+    // Re-enrolling restores service
+    if err := client.Register(context.Background(), ""); err != nil {
+        t.Fatalf("re-Register: %v", err)
+    }
+    if _, err := client.GetNextJob(context.Background()); err != nil {
+        t.Fatalf("GetNextJob after re-enrolling: %v", err)
+    }
+}
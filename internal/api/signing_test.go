@@ -0,0 +1,73 @@
+package api
+
+import (
+    "context"
+    "crypto/ed25519"
+    "encoding/base64"
+    "io"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestRequestsAreSigned(t *testing.T) {
+    public, private, err := ed25519.GenerateKey(nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    
+    var registeredKey string
+    var verified int
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        
+        if r.Header.Get(HeaderDevice) != "device-abc" {
+            t.Errorf("device header = %q", r.Header.Get(HeaderDevice))
+        }
+        signature, _ := base64.StdEncoding.DecodeString(r.Header.Get(HeaderSignature))
+        message := CanonicalRequest(r.Method, r.URL.RequestURI(), body,
+            r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce))
+        if ed25519.Verify(public, message, signature) {
+            verified++
+        } else {
+            t.Errorf("%s %s: signature did not verify", r.Method, r.URL)
+        }
+        
+        if r.URL.Path == "/api/agent/register" {
+            registeredKey = string(body)
+        }
+        w.WriteHeader(http.StatusNoContent)
+    })
+    client.SetDeviceKey(private)
+    
+    if err := client.Register(context.Background(), ""); err != nil {
+        t.Fatalf("Register: %v", err)
+    }
+    if _, err := client.GetNextJob(context.Background()); err != nil {
+        t.Fatalf("GetNextJob: %v", err)
+    }
+    
+    if verified != 2 {
+        t.Errorf("verified %d signatures, want 2", verified)
+    }
+    wantKey := base64.StdEncoding.EncodeToString(public)
+    if !strings.Contains(registeredKey, wantKey) {
+        t.Errorf("register body %s does not carry public key %s", registeredKey, wantKey)
+    }
+}
+
+func TestCanonicalRequestBindsEveryField(t *testing.T) {
+    base := string(CanonicalRequest("POST", "/a", []byte("x"), "1", "n"))
+    variants := []string{
+        string(CanonicalRequest("GET", "/a", []byte("x"), "1", "n")),
+        string(CanonicalRequest("POST", "/b", []byte("x"), "1", "n")),
+        string(CanonicalRequest("POST", "/a", []byte("y"), "1", "n")),
+        string(CanonicalRequest("POST", "/a", []byte("x"), "2", "n")),
+        string(CanonicalRequest("POST", "/a", []byte("x"), "1", "m")),
+    }
+    for i, v := range variants {
+        if v == base {
+            t.Errorf("variant %d produced the same canonical request", i)
+        }
+    }
+}
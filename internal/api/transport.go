@@ -0,0 +1,431 @@
+package api
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "runtime"
+    "strings"
+    "time"
+    
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/propagation"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/clock"
+    "github.com/ifruncillo/idlenet-agent/internal/netconf"
+)
+
+// maxErrorBody caps how much of an error response we keep for messages
+const maxErrorBody = 4 << 10
+
+// Transport is the single HTTP layer every API call goes through
+// It owns the base URL, shared headers, Vercel bypass auth, retries and error mapping
+type Transport struct {
+    baseURL    string
+    userAgent  string
+    bypass     string // Optional Vercel bypass token for protected deployments
+    httpClient *http.Client
+    retry      RetryPolicy
+    breaker    *Breaker
+    signer     *RequestSigner // Signs requests once the device has a key
+    auth       Authenticator  // Supplies bearer tokens once the device has a session
+    encoding   negotiator     // Request body compression the server has agreed to
+    clock      clock.Offset   // Server clock offset learned from Date headers
+    observe    Observer       // Told about every attempt, for metrics
+    sleep      func(context.Context, time.Duration) error
+}
+
+// NewTransport creates a transport for the API at baseURL
+// version is reported in the User-Agent so the server can tell agent builds apart
+func NewTransport(baseURL, version string) *Transport {
+    if baseURL == "" {
+        baseURL = "http://127.0.0.1:8787" // sensible default for local stub
+    }
+    if version == "" {
+        version = "dev"
+    }
+    
+    return &Transport{
+        baseURL:   strings.TrimRight(baseURL, "/"),
+        userAgent: fmt.Sprintf("IdleNet-Agent/%s (%s/%s)", version, runtime.GOOS, runtime.GOARCH),
+        httpClient: &http.Client{
+            Transport: netconf.Default(),
+            Timeout:   30 * time.Second, // Don't wait forever for responses
+        },
+        retry:   DefaultRetryPolicy(),
+        breaker: NewBreaker(),
+        sleep:   sleepContext,
+    }
+}
+
+// SetBypassToken sets the Vercel bypass token if the deployment is protected
+func (t *Transport) SetBypassToken(token string) {
+    t.bypass = token
+}
+
+// SetHTTPTransport routes requests through rt, e.g. one built by netconf
+// for proxies, extra CAs, client certificates and pinning
+func (t *Transport) SetHTTPTransport(rt http.RoundTripper) {
+    t.httpClient.Transport = rt
+}
+
+// SetRetryPolicy replaces the default retry policy
+func (t *Transport) SetRetryPolicy(policy RetryPolicy) {
+    if policy.MaxAttempts < 1 {
+        policy.MaxAttempts = 1
+    }
+    t.retry = policy
+}
+
+// SetSigner makes every request carry a device signature
+func (t *Transport) SetSigner(signer *RequestSigner) {
+    t.signer = signer
+}
+
+// Authenticator supplies and renews the bearer token attached to requests
+type Authenticator interface {
+    // Token returns the current access token, renewing it first if it has expired
+    // An empty token means there is no session and the request goes out without one
+    Token(ctx context.Context) (string, error)
+    
+    // Refresh renews the access token after the server rejected it
+    Refresh(ctx context.Context) error
+}
+
+// SetAuthenticator attaches bearer tokens from auth to every authenticated request
+func (t *Transport) SetAuthenticator(auth Authenticator) {
+    t.auth = auth
+}
+
+// Observer is told how each request attempt went: route is the path without
+// its query, and status is 0 if no response came back
+type Observer func(method, route string, status int, elapsed time.Duration)
+
+// SetObserver reports every attempt to observe
+func (t *Transport) SetObserver(observe Observer) {
+    t.observe = observe
+}
+
+// ClockOffset returns how far the server's clock is ahead of ours, and false
+// until a response with a Date header has come back
+func (t *Transport) ClockOffset() (time.Duration, bool) {
+    return t.clock.Get()
+}
+
+// Breaker exposes the circuit breaker so callers can pace periodic work
+func (t *Transport) Breaker() *Breaker {
+    return t.breaker
+}
+
+// Do sends payload as JSON and decodes a successful response into out
+// Either may be nil. Non-2xx responses come back as *APIError.
+func (t *Transport) Do(ctx context.Context, method, path string, payload, out interface{}) error {
+    return t.do(ctx, method, path, payload, out, true)
+}
+
+// do is Do with control over whether a bearer token is attached
+// Registration and token refresh go out without one
+func (t *Transport) do(ctx context.Context, method, path string, payload, out interface{}, authenticated bool) error {
+    response, err := t.send(ctx, method, path, payload, authenticated && t.auth != nil)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    
+    if out == nil || response.StatusCode == http.StatusNoContent {
+        io.Copy(io.Discard, response.Body)
+        return nil
+    }
+    
+    if err := json.NewDecoder(response.Body).Decode(out); err != nil && err != io.EOF {
+        return fmt.Errorf("%s %s: failed to decode response: %w", method, path, err)
+    }
+    
+    return nil
+}
+
+// send performs the request, retrying transient failures
+// On success the caller owns the response body
+func (t *Transport) send(ctx context.Context, method, path string, payload interface{}, authenticated bool) (*http.Response, error) {
+    // Encode once so the same bytes can be replayed on retry
+    var body []byte
+    if payload != nil {
+        var err error
+        if body, err = json.Marshal(payload); err != nil {
+            return nil, fmt.Errorf("failed to encode payload: %w", err)
+        }
+    }
+    
+    // Token refreshes skip the breaker: they happen inside a request it has already let through
+    breaker := t.breaker
+    if ctx.Value(unguardedKey{}) != nil {
+        breaker = nil
+    }
+    
+    // A replayed POST could do its work twice, e.g. claim two jobs, unless
+    // the server can recognise it by its Idempotency-Key
+    attempts := t.retry.MaxAttempts
+    if !replayable(ctx, method) {
+        attempts = 1
+    }
+    
+    var lastErr error
+    var delay time.Duration
+    refreshed := false
+    downgraded := false
+    for attempt := 0; attempt < attempts; attempt++ {
+        if attempt > 0 {
+            if err := t.sleep(ctx, delay); err != nil {
+                return nil, err
+            }
+        }
+    
+        // Don't pile onto a server we already know is struggling
+        probe, err := breaker.allow()
+        if err != nil {
+            if lastErr != nil {
+                return nil, lastErr
+            }
+            return nil, fmt.Errorf("%s %s: %w", method, path, err)
+        }
+    
+        var token string
+        if authenticated {
+            var err error
+            if token, err = t.auth.Token(ctx); err != nil {
+                if probe {
+                    breaker.Release()
+                }
+                return nil, err
+            }
+        }
+    
+        sent := time.Now()
+        response, err := t.attempt(ctx, method, path, body, token)
+        if err != nil {
+            if ctx.Err() != nil {
+                // We gave up, which says nothing about the server
+                if probe {
+                    breaker.Release()
+                }
+                return nil, ctx.Err()
+            }
+            breaker.Failure(0)
+            lastErr = fmt.Errorf("%s %s: %w", method, path, err)
+            delay = t.retry.Backoff(attempt)
+            continue
+        }
+        t.encoding.observe(response.Header)
+        t.clock.Observe(sent, time.Now(), response.Header.Get("Date"))
+    
+        if response.StatusCode/100 == 2 {
+            breaker.Success()
+            return response, nil
+        }
+    
+        // An expired or revoked access token: renew once and replay without
+        // spending a retry. If renewal fails the caller must re-enroll.
+        if response.StatusCode == http.StatusUnauthorized && authenticated && !refreshed {
+            io.Copy(io.Discard, io.LimitReader(response.Body, maxErrorBody))
+            response.Body.Close()
+            breaker.Success()
+    
+            if err := t.auth.Refresh(ctx); err != nil {
+                return nil, err
+            }
+            refreshed = true
+            delay = 0
+            attempt--
+            continue
+        }
+    
+        // The server no longer takes our compressed bodies, e.g. a proxy
+        // in front of it changed. Fall back to plain ones and replay.
+        if response.StatusCode == http.StatusUnsupportedMediaType && !downgraded &&
+            response.Request.Header.Get("Content-Encoding") != "" {
+            io.Copy(io.Discard, io.LimitReader(response.Body, maxErrorBody))
+            response.Body.Close()
+            breaker.Success()
+    
+            t.encoding.reject()
+            downgraded = true
+            delay = 0
+            attempt--
+            continue
+        }
+    
+        apiErr := newAPIError(method, path, response)
+        response.Body.Close()
+    
+        if !apiErr.Temporary() {
+            // A 4xx means the server is up and answering, just not happy with us
+            if apiErr.StatusCode >= 500 {
+                breaker.Failure(0)
+            } else {
+                breaker.Success()
+            }
+            return nil, apiErr
+        }
+    
+        hint, hasHint := retryAfter(response.Header, time.Now())
+        breaker.Failure(hint)
+        lastErr = apiErr
+    
+        delay = t.retry.Backoff(attempt)
+        if hasHint {
+            // Honour the server's request, but give up rather than wait
+            // longer than our policy or the caller's deadline allows
+            if hint > t.retry.MaxDelay || !fitsDeadline(ctx, hint) {
+                return nil, apiErr
+            }
+            delay = hint
+        }
+    }
+    
+    return nil, lastErr
+}
+
+// attempt sends a single request with all shared headers and auth applied
+func (t *Transport) attempt(ctx context.Context, method, path string, body []byte, token string) (*http.Response, error) {
+    fullURL, err := t.buildURL(path)
+    if err != nil {
+        return nil, err
+    }
+    
+    // Compressed per attempt so a 415 fallback sends the plain bytes
+    encoding := t.encoding.current(len(body))
+    if encoding != EncodingIdentity {
+        if body, err = compressBody(encoding, body); err != nil {
+            return nil, err
+        }
+    }
+    
+    var reader io.Reader
+    if body != nil {
+        reader = bytes.NewReader(body)
+    }
+    
+    request, err := http.NewRequestWithContext(ctx, method, fullURL, reader)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create request: %w", err)
+    }
+    
+    if body != nil {
+        request.Header.Set("Content-Type", "application/json")
+    }
+    if encoding != EncodingIdentity {
+        request.Header.Set("Content-Encoding", encoding)
+    }
+    request.Header.Set("Accept", "application/json")
+    request.Header.Set("User-Agent", t.userAgent)
+    if key, ok := ctx.Value(idempotencyKey{}).(string); ok {
+        request.Header.Set("Idempotency-Key", key)
+    }
+    // traceparent and tracestate, when the caller is inside a span
+    otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
+    if token != "" {
+        request.Header.Set("Authorization", "Bearer "+token)
+    }
+    
+    // Add bypass header if we have a token
+    if t.bypass != "" {
+        request.Header.Set("x-vercel-protection-bypass", t.bypass)
+    }
+    
+    // Signed fresh on every attempt so retries get their own nonce and timestamp
+    // The signature covers the body as sent, i.e. after compression
+    if t.signer != nil {
+        if err := t.signer.Sign(request, path, body); err != nil {
+            return nil, err
+        }
+    }
+    
+    if t.observe == nil {
+        return t.httpClient.Do(request)
+    }
+    started := time.Now()
+    response, err := t.httpClient.Do(request)
+    status := 0
+    if err == nil {
+        status = response.StatusCode
+    }
+    route, _, _ := strings.Cut(path, "?")
+    t.observe(method, route, status, time.Since(started))
+    return response, err
+}
+
+// buildURL joins path onto the base URL, adding bypass parameters when needed
+func (t *Transport) buildURL(path string) (string, error) {
+    fullURL := t.baseURL + path
+    if t.bypass == "" {
+        return fullURL, nil
+    }
+    
+    // Protected Vercel deployments also want the token in the query string
+    parsed, err := url.Parse(fullURL)
+    if err != nil {
+        return "", fmt.Errorf("invalid URL: %w", err)
+    }
+    
+    query := parsed.Query()
+    query.Set("x-vercel-set-bypass-cookie", "true")
+    query.Set("x-vercel-protection-bypass", t.bypass)
+    parsed.RawQuery = query.Encode()
+    return parsed.String(), nil
+}
+
+// unguardedKey is the context key for withoutBreaker
+type unguardedKey struct{}
+
+// withoutBreaker marks a request made on behalf of another one the breaker
+// has already let through, such as a token refresh, so it isn't refused as a
+// second probe and doesn't count twice
+func withoutBreaker(ctx context.Context) context.Context {
+    return context.WithValue(ctx, unguardedKey{}, true)
+}
+
+// idempotencyKey is the context key for withIdempotencyKey
+type idempotencyKey struct{}
+
+// withIdempotencyKey tags requests made with ctx so the server can drop
+// replays, e.g. a result resent after its response was lost
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+    return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// replayable reports whether a request can safely be sent again after a
+// failure that may have reached the server
+func replayable(ctx context.Context, method string) bool {
+    switch method {
+    case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+        return true
+    }
+    _, keyed := ctx.Value(idempotencyKey{}).(string)
+    return keyed
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+    if d <= 0 {
+        return ctx.Err()
+    }
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-timer.C:
+        return nil
+    }
+}
+
+// fitsDeadline reports whether waiting d still leaves time before ctx expires
+func fitsDeadline(ctx context.Context, d time.Duration) bool {
+    deadline, ok := ctx.Deadline()
+    return !ok || time.Until(deadline) > d
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "runtime"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+    
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/propagation"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+    t.Helper()
+    server := httptest.NewServer(handler)
+    t.Cleanup(server.Close)
+    
+    client := NewClient(server.URL+"/", "v9.9.9", "user@example.com", "device-abc")
+    client.transport.sleep = func(context.Context, time.Duration) error { return nil }
+    return client
+}
+
+func TestTransportSharedHeaders(t *testing.T) {
+    var got *http.Request
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        got = r.Clone(context.Background())
+        w.Write([]byte(`{"ok":true}`))
+    })
+    client.SetBypassToken("secret")
+    
+    if _, err := client.Beat(context.Background(), &Heartbeat{}); err != nil {
+        t.Fatalf("Beat: %v", err)
+    }
+    
+    wantUA := "IdleNet-Agent/v9.9.9 (" + runtime.GOOS + "/" + runtime.GOARCH + ")"
+    if ua := got.UserAgent(); ua != wantUA {
+        t.Errorf("User-Agent = %q, want %q", ua, wantUA)
+    }
+    if got.URL.Path != "/api/agent/beat" {
+        t.Errorf("path = %q, want trimmed base URL joined with /api/agent/beat", got.URL.Path)
+    }
+    if h := got.Header.Get("x-vercel-protection-bypass"); h != "secret" {
+        t.Errorf("bypass header = %q", h)
+    }
+    if q := got.URL.Query().Get("x-vercel-protection-bypass"); q != "secret" {
+        t.Errorf("bypass query = %q", q)
+    }
+    if ct := got.Header.Get("Content-Type"); ct != "application/json" {
+        t.Errorf("Content-Type = %q", ct)
+    }
+}
+
+func TestTransportErrorBodyIsTruncated(t *testing.T) {
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusBadRequest)
+        w.Write([]byte(strings.Repeat("x", 10<<10)))
+    })
+    
+    err := client.Register(context.Background(), "")
+    if !IsStatus(err, http.StatusBadRequest) {
+        t.Fatalf("Register error = %v, want APIError with 400", err)
+    }
+    
+    var apiErr *APIError
+    if !errors.As(err, &apiErr) {
+        t.Fatalf("error %v is not an APIError", err)
+    }
+    if len(apiErr.Body) != maxErrorBody {
+        t.Errorf("error body length = %d, want %d", len(apiErr.Body), maxErrorBody)
+    }
+}
+
+func TestTransportRetriesTemporaryFailures(t *testing.T) {
+    var calls atomic.Int32
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        if calls.Add(1) < 3 {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        }
+        w.Write([]byte(`{"job":null}`))
+    })
+    
+    if _, err := client.GetNextJob(context.Background()); err != nil {
+        t.Fatalf("GetNextJob: %v", err)
+    }
+    if n := calls.Load(); n != 3 {
+        t.Errorf("server saw %d calls, want 3", n)
+    }
+}
+
+func TestTransportDoesNotRetryClientErrors(t *testing.T) {
+    var calls atomic.Int32
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        calls.Add(1)
+        w.WriteHeader(http.StatusForbidden)
+    })
+    
+    if _, err := client.Beat(context.Background(), &Heartbeat{}); !IsStatus(err, http.StatusForbidden) {
+        t.Fatalf("Beat error = %v, want 403", err)
+    }
+    if n := calls.Load(); n != 1 {
+        t.Errorf("server saw %d calls, want 1", n)
+    }
+}
+
+func TestGetNextJob(t *testing.T) {
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet || r.URL.Query().Get("deviceId") != "device-abc" {
+            t.Errorf("unexpected request %s %s", r.Method, r.URL)
+        }
+        w.Write([]byte(`{"job":{"id":"j1","type":"sleep","max_seconds":30}}`))
+    })
+    
+    job, err := client.GetNextJob(context.Background())
+    if err != nil {
+        t.Fatalf("GetNextJob: %v", err)
+    }
+    if job == nil || job.ID != "j1" || job.Type != "sleep" {
+        t.Errorf("job = %+v", job)
+    }
+}
+
+func TestGetNextJobNoWork(t *testing.T) {
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusNoContent)
+    })
+    
+    job, err := client.GetNextJob(context.Background())
+    if err != nil || job != nil {
+        t.Errorf("GetNextJob = %+v, %v; want nil, nil", job, err)
+    }
+}
+
+func TestSubmitResultPayload(t *testing.T) {
+    var body struct {
+        DeviceID string    `json:"deviceId"`
+        Result   JobResult `json:"result"`
+    }
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        json.NewDecoder(r.Body).Decode(&body)
+        w.WriteHeader(http.StatusNoContent)
+    })
+    
+    err := client.SubmitResult(context.Background(), &JobResult{JobID: "j1", Status: "ok", CPUSeconds: 1.5})
+    if err != nil {
+        t.Fatalf("SubmitResult: %v", err)
+    }
+    if body.DeviceID != "device-abc" || body.Result.JobID != "j1" || body.Result.CPUSeconds != 1.5 {
+        t.Errorf("server received %+v", body)
+    }
+}
+
+func TestBeatSendsSchemaAndReturnsDirectives(t *testing.T) {
+    var got Heartbeat
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        json.NewDecoder(r.Body).Decode(&got)
+        w.Write([]byte(`{"directives":[{"type":"pause","seconds":60},{"type":"drop_cache"}]}`))
+    })
+    
+    response, err := client.Beat(context.Background(), &Heartbeat{RunningJobs: []string{"j1"}})
+    if err != nil {
+        t.Fatalf("Beat: %v", err)
+    }
+    
+    if got.Schema != HeartbeatSchemaVersion || got.DeviceID != "device-abc" || got.AgentVersion != "v9.9.9" {
+        t.Errorf("heartbeat identity = %+v", got)
+    }
+    if got.OS != runtime.GOOS || got.Cores == 0 || len(got.RunningJobs) != 1 {
+        t.Errorf("heartbeat state = %+v", got)
+    }
+    
+    if len(response.Directives) != 2 ||
+        response.Directives[0].Type != DirectivePause || response.Directives[0].Seconds != 60 ||
+        response.Directives[1].Type != DirectiveDropCache {
+        t.Errorf("directives = %+v", response.Directives)
+    }
+}
+
+func TestCapabilitiesSentAtRegistrationAndInHeartbeats(t *testing.T) {
+    bodies := map[string]map[string]json.RawMessage{}
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        var body map[string]json.RawMessage
+        json.NewDecoder(r.Body).Decode(&body)
+        bodies[r.URL.Path] = body
+        w.Write([]byte(`{}`))
+    })
+    
+    // Nothing to report before the device has been measured
+    if err := client.Register(context.Background(), ""); err != nil {
+        t.Fatalf("Register: %v", err)
+    }
+    if _, ok := bodies["/api/agent/register"]["capabilities"]; ok {
+        t.Error("registration sent capabilities before any were set")
+    }
+    
+    client.SetCapabilities(&Capabilities{AgentVersion: "v9.9.9", HashMBps: 1200, FloatMflops: 2600, MemoryMBps: 11000})
+    if err := client.Register(context.Background(), ""); err != nil {
+        t.Fatalf("Register: %v", err)
+    }
+    if _, err := client.Beat(context.Background(), &Heartbeat{}); err != nil {
+        t.Fatalf("Beat: %v", err)
+    }
+    
+    for _, path := range []string{"/api/agent/register", "/api/agent/beat"} {
+        var caps Capabilities
+        if err := json.Unmarshal(bodies[path]["capabilities"], &caps); err != nil || caps.HashMBps != 1200 {
+            t.Errorf("%s capabilities = %s", path, bodies[path]["capabilities"])
+        }
+    }
+}
+
+func TestClockOffsetFromDateHeader(t *testing.T) {
+    ahead := 10 * time.Minute
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Date", time.Now().Add(ahead).UTC().Format(http.TimeFormat))
+        w.WriteHeader(http.StatusNoContent)
+    })
+    
+    if _, ok := client.ServerTime(time.Now()); ok {
+        t.Fatal("ServerTime known before any response")
+    }
+    if err := client.SendTelemetry(context.Background(), nil); err != nil {
+        t.Fatalf("SendTelemetry: %v", err)
+    }
+    
+    offset, ok := client.ClockOffset()
+    if !ok || offset < ahead-time.Second || offset > ahead+time.Second {
+        t.Fatalf("offset = %v (%v), want about %v", offset, ok, ahead)
+    }
+    local := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    server, _ := client.ServerTime(local)
+    if d := server.Sub(local); d != offset.Truncate(time.Millisecond) {
+        t.Errorf("ServerTime moved by %v, offset %v", d, offset)
+    }
+}
+
+func TestObserverSeesEachAttempt(t *testing.T) {
+    var calls atomic.Int32
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        if calls.Add(1) < 2 {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    })
+    
+    var seen []string
+    client.SetObserver(func(method, route string, status int, elapsed time.Duration) {
+        seen = append(seen, fmt.Sprintf("%s %s %d", method, route, status))
+    })
+    if _, err := client.GetNextJob(context.Background()); err != nil {
+        t.Fatalf("GetNextJob: %v", err)
+    }
+    
+    want := "GET /api/agent/jobs/next 503,GET /api/agent/jobs/next 204"
+    if got := strings.Join(seen, ","); got != want {
+        t.Errorf("observed %q, want %q", got, want)
+    }
+}
+
+func TestRequestsCarryTraceContext(t *testing.T) {
+    previous := otel.GetTextMapPropagator()
+    otel.SetTextMapPropagator(propagation.TraceContext{})
+    t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+    
+    var got string
+    client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+        got = r.Header.Get("traceparent")
+        w.WriteHeader(http.StatusNoContent)
+    })
+    
+    // As if the job offer came from a server span
+    const offer = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+    ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier{"traceparent": offer})
+    if err := client.SubmitResult(ctx, &JobResult{JobID: "job-1", Status: "ok"}); err != nil {
+        t.Fatalf("SubmitResult: %v", err)
+    }
+    if got != offer {
+        t.Errorf("traceparent = %q, want %q", got, offer)
+    }
+    
+    // Outside a trace there's nothing to send
+    got = "unset"
+    if err := client.SubmitResult(context.Background(), &JobResult{JobID: "job-2", Status: "ok"}); err != nil {
+        t.Fatalf("SubmitResult: %v", err)
+    }
+    if got != "" {
+        t.Errorf("traceparent = %q outside a trace, want none", got)
+    }
+}
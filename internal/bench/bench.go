@@ -0,0 +1,198 @@
+// Package bench measures how fast this device is, so the server can size
+// jobs to it: short, standard CPU, memory and WASM tests whose scores are
+// cached along with the agent version and hardware they were measured on
+package bench
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "time"
+)
+
+const profileFile = "benchmark.json"
+
+// testDuration is how long each test runs once warmed up
+const testDuration = 500 * time.Millisecond
+
+// Scores are for one core; the server scales them by the cores it may use
+type Scores struct {
+    HashMBps    float64 `json:"hash_mbps"`           // SHA-256 over buffers that fit in cache
+    FloatMflops float64 `json:"float_mflops"`        // float64 multiply-adds
+    MemoryMBps  float64 `json:"memory_mbps"`         // Copying buffers too big for cache, bytes read plus written
+    WASMMips    float64 `json:"wasm_mips,omitempty"` // Millions of WASM instructions a second, 0 in builds without a WASM runtime
+}
+
+// Hardware is what the scores depend on besides the agent itself
+// A change to any of it means measuring again
+type Hardware struct {
+    OS       string `json:"os"`
+    Arch     string `json:"arch"`
+    Cores    int    `json:"cores"`
+    CPUModel string `json:"cpu_model,omitempty"`
+    MemoryMB uint64 `json:"memory_mb,omitempty"`
+}
+
+// DetectHardware describes this machine; fields the OS won't tell us are left empty
+func DetectHardware() Hardware {
+    return Hardware{
+        OS:       runtime.GOOS,
+        Arch:     runtime.GOARCH,
+        Cores:    runtime.NumCPU(),
+        CPUModel: cpuModel(),
+        MemoryMB: totalMemory() >> 20,
+    }
+}
+
+// Profile is a set of scores and what they were measured on
+type Profile struct {
+    AgentVersion string    `json:"agent_version"`
+    Hardware     Hardware  `json:"hardware"`
+    MeasuredAt   time.Time `json:"measured_at"`
+    Scores       Scores    `json:"scores"`
+}
+
+// Stale says why p should be measured again before it's reported for
+// version on hw, or "" if it's still good
+func Stale(p *Profile, version string, hw Hardware) string {
+    switch {
+    case p == nil:
+        return "no benchmark yet"
+    case p.AgentVersion != version:
+        return "agent version changed"
+    case p.Hardware != hw:
+        return "hardware changed"
+    }
+    return ""
+}
+
+// Run measures this device for the given agent version
+// It takes a few seconds and keeps one core busy, so callers wait for idle time
+func Run(ctx context.Context, version string) (*Profile, error) {
+    p := &Profile{
+        AgentVersion: version,
+        Hardware:     DetectHardware(),
+    }
+    
+    tests := []struct {
+        name  string
+        score *float64
+        run   func(context.Context) (float64, error)
+    }{
+        {"hash", &p.Scores.HashMBps, hashScore},
+        {"float", &p.Scores.FloatMflops, floatScore},
+        {"memory", &p.Scores.MemoryMBps, memoryScore},
+        {"wasm", &p.Scores.WASMMips, wasmScore},
+    }
+    for _, test := range tests {
+        score, err := test.run(ctx)
+        if err != nil {
+            return nil, fmt.Errorf("%s benchmark: %w", test.name, err)
+        }
+        *test.score = score
+    }
+    
+    p.MeasuredAt = time.Now()
+    return p, nil
+}
+
+// measure calls round, which returns the work it did, until testDuration has
+// passed, after one call to warm up; the result is work per second
+func measure(ctx context.Context, round func() float64) (float64, error) {
+    round()
+    
+    var work float64
+    start := time.Now()
+    for time.Since(start) < testDuration {
+        if err := ctx.Err(); err != nil {
+            return 0, err
+        }
+        work += round()
+    }
+    return work / time.Since(start).Seconds(), nil
+}
+
+// sink keeps the compiler from dropping work whose result isn't otherwise used
+var sink float64
+
+// hashScore is SHA-256 throughput in MB/s
+func hashScore(ctx context.Context) (float64, error) {
+    buf := make([]byte, 64<<10)
+    for i := range buf {
+        buf[i] = byte(i)
+    }
+    return measure(ctx, func() float64 {
+        for i := 0; i < 16; i++ {
+            sum := sha256.Sum256(buf)
+            buf[0] = sum[0]
+        }
+        return 16 * float64(len(buf)) / 1e6
+    })
+}
+
+// floatScore is float64 throughput in millions of operations a second
+// Four independent chains keep the FPU pipelines busy
+func floatScore(ctx context.Context) (float64, error) {
+    const iterations = 1 << 20
+    return measure(ctx, func() float64 {
+        a, b, c, d := 1.0, 1.1, 1.2, 1.3
+        for i := 0; i < iterations; i++ {
+            a = a*0.999999 + 0.000001
+            b = b*0.999999 + 0.000001
+            c = c*0.999999 + 0.000001
+            d = d*0.999999 + 0.000001
+        }
+        sink += a + b + c + d
+        return 4 * 2 * iterations / 1e6
+    })
+}
+
+// memoryScore is memory bandwidth in MB/s, counting each copied byte as read
+// and written the way STREAM's copy test does
+func memoryScore(ctx context.Context) (float64, error) {
+    src := make([]byte, 32<<20)
+    dst := make([]byte, len(src))
+    for i := range src {
+        src[i] = byte(i)
+    }
+    return measure(ctx, func() float64 {
+        copy(dst, src)
+        return 2 * float64(len(src)) / 1e6
+    })
+}
+
+// Load returns the profile cached in dataDir, or nil if there's none
+func Load(dataDir string) *Profile {
+    data, err := os.ReadFile(filepath.Join(dataDir, profileFile))
+    if err != nil {
+        return nil
+    }
+    var p Profile
+    if err := json.Unmarshal(data, &p); err != nil {
+        return nil
+    }
+    return &p
+}
+
+// Save caches p in dataDir
+func Save(dataDir string, p *Profile) error {
+    data, err := json.MarshalIndent(p, "", "  ")
+    if err != nil {
+        return err
+    }
+    
+    path := filepath.Join(dataDir, profileFile)
+    tempPath := path + ".tmp"
+    if err := os.WriteFile(tempPath, data, 0600); err != nil {
+        return fmt.Errorf("failed to cache benchmark: %w", err)
+    }
+    if err := os.Rename(tempPath, path); err != nil {
+        os.Remove(tempPath)
+        return fmt.Errorf("failed to cache benchmark: %w", err)
+    }
+    return nil
+}
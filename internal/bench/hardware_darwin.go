@@ -0,0 +1,23 @@
+package bench
+
+import (
+    "golang.org/x/sys/unix"
+)
+
+// cpuModel is the CPU's brand string, e.g. "Apple M2"
+func cpuModel() string {
+    model, err := unix.Sysctl("machdep.cpu.brand_string")
+    if err != nil {
+        return ""
+    }
+    return model
+}
+
+// totalMemory is physical memory in bytes
+func totalMemory() uint64 {
+    size, err := unix.SysctlUint64("hw.memsize")
+    if err != nil {
+        return 0
+    }
+    return size
+}
@@ -0,0 +1,37 @@
+package bench
+
+import (
+    "bufio"
+    "os"
+    "strings"
+    
+    "golang.org/x/sys/unix"
+)
+
+// cpuModel is the first "model name" in /proc/cpuinfo
+// ARM kernels often don't give one, and then it's empty
+func cpuModel() string {
+    file, err := os.Open("/proc/cpuinfo")
+    if err != nil {
+        return ""
+    }
+    defer file.Close()
+    
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        key, value, ok := strings.Cut(scanner.Text(), ":")
+        if ok && strings.TrimSpace(key) == "model name" {
+            return strings.TrimSpace(value)
+        }
+    }
+    return ""
+}
+
+// totalMemory is physical memory in bytes
+func totalMemory() uint64 {
+    var info unix.Sysinfo_t
+    if unix.Sysinfo(&info) != nil {
+        return 0
+    }
+    return uint64(info.Totalram) * uint64(info.Unit)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+
+package bench
+
+// cpuModel isn't known here
+func cpuModel() string {
+    return ""
+}
+
+// totalMemory isn't known here
+func totalMemory() uint64 {
+    return 0
+}
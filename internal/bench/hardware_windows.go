@@ -0,0 +1,49 @@
+package bench
+
+import (
+    "strings"
+    "unsafe"
+    
+    "golang.org/x/sys/windows"
+    "golang.org/x/sys/windows/registry"
+)
+
+var procGlobalMemoryStatusEx = windows.NewLazySystemDLL("kernel32.dll").NewProc("GlobalMemoryStatusEx")
+
+// memoryStatusEx is MEMORYSTATUSEX
+type memoryStatusEx struct {
+    Length               uint32
+    MemoryLoad           uint32
+    TotalPhys            uint64
+    AvailPhys            uint64
+    TotalPageFile        uint64
+    AvailPageFile        uint64
+    TotalVirtual         uint64
+    AvailVirtual         uint64
+    AvailExtendedVirtual uint64
+}
+
+// cpuModel is the first processor's name as Windows records it
+func cpuModel() string {
+    key, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DESCRIPTION\System\CentralProcessor\0`, registry.QUERY_VALUE)
+    if err != nil {
+        return ""
+    }
+    defer key.Close()
+    name, _, err := key.GetStringValue("ProcessorNameString")
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(name)
+}
+
+// totalMemory is physical memory in bytes
+func totalMemory() uint64 {
+    status := memoryStatusEx{}
+    status.Length = uint32(unsafe.Sizeof(status))
+    ret, _, _ := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&status)))
+    if ret == 0 {
+        return 0
+    }
+    return status.TotalPhys
+}
@@ -0,0 +1,77 @@
+//go:build cgo
+
+package bench
+
+import (
+    "context"
+    "errors"
+    
+    "github.com/bytecodealliance/wasmtime-go/v15"
+)
+
+// wasmLoop is a tight integer loop, standing in for the arithmetic WASM jobs do
+const wasmLoop = `
+(module
+  (func (export "spin") (param $n i64) (result i64)
+    (local $acc i64)
+    (block $done
+      (loop $next
+        (br_if $done (i64.eqz (local.get $n)))
+        (local.set $acc (i64.add (i64.mul (local.get $acc) (i64.const 6364136223846793005)) (i64.const 1442695040888963407)))
+        (local.set $n (i64.sub (local.get $n) (i64.const 1)))
+        (br $next)))
+    (local.get $acc)))
+`
+
+// wasmScore is how many millions of WASM instructions a second wasmtime runs,
+// counted with fuel, which costs one unit per instruction
+func wasmScore(ctx context.Context) (float64, error) {
+    wasm, err := wasmtime.Wat2Wasm(wasmLoop)
+    if err != nil {
+        return 0, err
+    }
+    
+    config := wasmtime.NewConfig()
+    config.SetConsumeFuel(true)
+    engine := wasmtime.NewEngineWithConfig(config)
+    module, err := wasmtime.NewModule(engine, wasm)
+    if err != nil {
+        return 0, err
+    }
+    store := wasmtime.NewStore(engine)
+    instance, err := wasmtime.NewInstance(store, module, nil)
+    if err != nil {
+        return 0, err
+    }
+    spin := instance.GetFunc(store, "spin")
+    if spin == nil {
+        return 0, errors.New("spin export missing")
+    }
+    
+    // Plenty of fuel for a round; what's left afterwards says what was used
+    const tank = 1 << 40
+    var failed error
+    score, err := measure(ctx, func() float64 {
+        if failed != nil {
+            return 0
+        }
+        if err := store.SetFuel(tank); err != nil {
+            failed = err
+            return 0
+        }
+        if _, err := spin.Call(store, int64(1<<20)); err != nil {
+            failed = err
+            return 0
+        }
+        left, err := store.GetFuel()
+        if err != nil {
+            failed = err
+            return 0
+        }
+        return float64(tank-left) / 1e6
+    })
+    if failed != nil {
+        return 0, failed
+    }
+    return score, err
+}
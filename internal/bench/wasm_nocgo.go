@@ -0,0 +1,12 @@
+//go:build !cgo
+
+package bench
+
+import (
+    "context"
+)
+
+// wasmScore is 0 here: wasmtime needs cgo, so this build can't run WASM
+func wasmScore(ctx context.Context) (float64, error) {
+    return 0, nil
+}
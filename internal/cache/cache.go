@@ -0,0 +1,90 @@
+package cache
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync/atomic"
+)
+
+// Cache keeps downloaded job artifacts so repeat jobs don't fetch them again
+// Entries are files named by their key (the artifact's SHA256)
+type Cache struct {
+    dir    string
+    hits   atomic.Uint64
+    misses atomic.Uint64
+}
+
+// Entry is a single cached artifact
+type Entry struct {
+    Key  string
+    Size int64
+}
+
+// New opens the cache rooted at dir, creating it if needed
+func New(dir string) (*Cache, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, fmt.Errorf("failed to create cache directory: %w", err)
+    }
+    return &Cache{dir: dir}, nil
+}
+
+// Path returns where the artifact with the given key lives (or would live)
+func (c *Cache) Path(key string) string {
+    return filepath.Join(c.dir, filepath.Base(key))
+}
+
+// Lookup returns the path of a cached artifact and whether it's there,
+// counting hits and misses for Stats
+func (c *Cache) Lookup(key string) (string, bool) {
+    path := c.Path(key)
+    if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+        c.hits.Add(1)
+        return path, true
+    }
+    c.misses.Add(1)
+    return path, false
+}
+
+// Stats returns how many lookups hit and missed since the cache was opened
+func (c *Cache) Stats() (hits, misses uint64) {
+    return c.hits.Load(), c.misses.Load()
+}
+
+// Entries lists everything currently cached
+func (c *Cache) Entries() ([]Entry, error) {
+    files, err := os.ReadDir(c.dir)
+    if err != nil {
+        return nil, err
+    }
+    
+    entries := make([]Entry, 0, len(files))
+    for _, f := range files {
+        if f.IsDir() {
+            continue
+        }
+        info, err := f.Info()
+        if err != nil {
+            continue
+        }
+        entries = append(entries, Entry{Key: f.Name(), Size: info.Size()})
+    }
+    
+    return entries, nil
+}
+
+// Clear deletes every cached artifact
+func (c *Cache) Clear() error {
+    files, err := os.ReadDir(c.dir)
+    if err != nil {
+        return err
+    }
+    
+    for _, f := range files {
+        if err := os.RemoveAll(filepath.Join(c.dir, f.Name())); err != nil {
+            return fmt.Errorf("failed to remove %s: %w", f.Name(), err)
+        }
+    }
+    
+    return nil
+}
@@ -0,0 +1,62 @@
+// Package clock measures durations that survive wall-clock steps and
+// suspend/resume, and tracks how far our clock is from the server's
+package clock
+
+import (
+    "time"
+)
+
+// jumpNoise is how much clocks may disagree before we call it a clock step
+// or a suspend rather than timer jitter
+const jumpNoise = time.Second
+
+// Span is a stretch of time being measured
+// time.Now carries a monotonic reading, so NTP or the user changing the
+// wall clock mid-span doesn't change Elapsed
+type Span struct {
+    start     time.Time
+    suspended time.Duration // Platform suspend counter at start
+    canTell   bool          // Whether the platform has a suspend counter
+}
+
+// Measurement is what a finished Span measured
+type Measurement struct {
+    Started   time.Time     // Wall clock at start, for records and display
+    Finished  time.Time     // Wall clock at the end
+    Elapsed   time.Duration // Time that passed while the machine was awake
+    Suspended time.Duration // Time spent asleep during the span
+    Jump      time.Duration // How far the wall clock was stepped during the span, forward positive
+}
+
+// Start begins measuring
+func Start() Span {
+    suspended, ok := suspendedTotal()
+    return Span{start: time.Now(), suspended: suspended, canTell: ok}
+}
+
+// Stop ends the span
+// Stopping the same Span again measures from the same start
+func (s Span) Stop() Measurement {
+    end := time.Now()
+    m := Measurement{Started: s.start.Round(0), Finished: end.Round(0)}
+    
+    // Sub-second differences are the counters ticking at different rates
+    if total, ok := suspendedTotal(); ok && s.canTell && total-s.suspended >= jumpNoise {
+        m.Suspended = total - s.suspended
+    }
+    
+    m.Elapsed = end.Sub(s.start)
+    if monotonicIncludesSuspend {
+        m.Elapsed -= m.Suspended
+    }
+    if m.Elapsed < 0 {
+        m.Elapsed = 0
+    }
+    
+    // Whatever the wall clock moved beyond awake and asleep time was a step
+    m.Jump = m.Finished.Sub(m.Started) - m.Elapsed - m.Suspended
+    if m.Jump > -jumpNoise && m.Jump < jumpNoise {
+        m.Jump = 0
+    }
+    return m
+}
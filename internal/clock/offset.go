@@ -0,0 +1,70 @@
+package clock
+
+import (
+    "net/http"
+    "sync"
+    "time"
+)
+
+// Offset estimates how far the server's clock is ahead of ours from the
+// Date headers on its responses
+// Date only has one-second resolution, so samples are smoothed; a sample far
+// from the estimate means one of the clocks was stepped and starts over
+type Offset struct {
+    mu     sync.Mutex
+    offset time.Duration
+    known  bool
+}
+
+const (
+    maxSampleRTT = 5 * time.Second // Slower round trips say too little about when Date was stamped
+    resetAfter   = 5 * time.Second // Disagreement that means a clock was stepped
+    smoothing    = 8               // Each sample moves the estimate 1/smoothing of the way
+)
+
+// Observe takes a sample from a response to a request sent at sent and
+// received at received, both from time.Now
+func (o *Offset) Observe(sent, received time.Time, date string) {
+    if date == "" {
+        return
+    }
+    serverTime, err := http.ParseTime(date)
+    if err != nil {
+        return
+    }
+    rtt := received.Sub(sent)
+    if rtt < 0 || rtt > maxSampleRTT {
+        return
+    }
+    
+    // The server stamped Date somewhere in the round trip, truncated to the second
+    midpoint := sent.Round(0).Add(rtt / 2)
+    sample := serverTime.Add(500 * time.Millisecond).Sub(midpoint)
+    
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    diff := sample - o.offset
+    if !o.known || diff > resetAfter || diff < -resetAfter {
+        o.offset = sample
+        o.known = true
+        return
+    }
+    o.offset += diff / smoothing
+}
+
+// Get returns the server's clock minus ours, and false before any sample
+func (o *Offset) Get() (time.Duration, bool) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    return o.offset, o.known
+}
+
+// ServerTime converts a local wall-clock time to the server's clock
+// It returns false if no offset is known yet
+func (o *Offset) ServerTime(t time.Time) (time.Time, bool) {
+    offset, ok := o.Get()
+    if !ok {
+        return time.Time{}, false
+    }
+    return t.Round(0).Add(offset).Truncate(time.Millisecond).UTC(), true
+}
@@ -0,0 +1,20 @@
+package clock
+
+import (
+    "time"
+    
+    "golang.org/x/sys/unix"
+)
+
+// Go's monotonic clock is mach_absolute_time, which stops while asleep
+const monotonicIncludesSuspend = false
+
+// suspendedTotal returns how long the machine has slept since boot:
+// CLOCK_MONOTONIC counts sleep on macOS, CLOCK_UPTIME_RAW doesn't
+func suspendedTotal() (time.Duration, bool) {
+    var awake, total unix.Timespec
+    if unix.ClockGettime(unix.CLOCK_MONOTONIC, &total) != nil || unix.ClockGettime(unix.CLOCK_UPTIME_RAW, &awake) != nil {
+        return 0, false
+    }
+    return time.Duration(total.Nano() - awake.Nano()), true
+}
@@ -0,0 +1,20 @@
+package clock
+
+import (
+    "time"
+    
+    "golang.org/x/sys/unix"
+)
+
+// Go's monotonic clock is CLOCK_MONOTONIC, which stops while suspended
+const monotonicIncludesSuspend = false
+
+// suspendedTotal returns how long the machine has been suspended since boot:
+// CLOCK_BOOTTIME counts suspend, CLOCK_MONOTONIC doesn't
+func suspendedTotal() (time.Duration, bool) {
+    var boot, mono unix.Timespec
+    if unix.ClockGettime(unix.CLOCK_BOOTTIME, &boot) != nil || unix.ClockGettime(unix.CLOCK_MONOTONIC, &mono) != nil {
+        return 0, false
+    }
+    return time.Duration(boot.Nano() - mono.Nano()), true
+}
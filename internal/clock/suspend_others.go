@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+
+package clock
+
+import (
+    "time"
+)
+
+const monotonicIncludesSuspend = false
+
+// suspendedTotal isn't available here, so suspend shows up as a clock jump
+func suspendedTotal() (time.Duration, bool) {
+    return 0, false
+}
@@ -0,0 +1,28 @@
+package clock
+
+import (
+    "time"
+    "unsafe"
+    
+    "golang.org/x/sys/windows"
+)
+
+// Go's monotonic clock on Windows is the interrupt time, which keeps
+// counting through sleep and hibernation
+const monotonicIncludesSuspend = true
+
+var procQueryUnbiasedInterruptTime = windows.NewLazySystemDLL("kernel32.dll").NewProc("QueryUnbiasedInterruptTime")
+
+// suspendedTotal returns how long the machine has slept since boot:
+// the tick count includes sleep, the unbiased interrupt time doesn't.
+// They tick at different resolutions, so this is only good to ~16ms.
+func suspendedTotal() (time.Duration, bool) {
+    if procQueryUnbiasedInterruptTime.Find() != nil {
+        return 0, false
+    }
+    var unbiased uint64 // 100ns units
+    if ok, _, _ := procQueryUnbiasedInterruptTime.Call(uintptr(unsafe.Pointer(&unbiased))); ok == 0 {
+        return 0, false
+    }
+    return windows.DurationSinceBoot() - time.Duration(unbiased)*100, true
+}
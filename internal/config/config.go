@@ -18,6 +18,7 @@ type Config struct {
     Referral          string    `json:"referral,omitempty"`
     APIBase           string    `json:"api_base"`
     Registered        bool      `json:"registered"`
+    RegisteredKey     string    `json:"registered_key,omitempty"` // Fingerprint of the device key the server knows
     CreatedAt         time.Time `json:"created_at"`
     UpdatedAt         time.Time `json:"updated_at"`
     
@@ -26,6 +27,30 @@ type Config struct {
     AllowBackground   bool      `json:"allow_background"`   // Run jobs while system is in use
     MaxCPUPercent     int       `json:"max_cpu_percent"`    // Override max CPU usage
     MaxMemoryMB       int       `json:"max_memory_mb"`      // Override max memory usage
+    
+    // Days of per-job history kept in the data directory; older days survive only as monthly totals
+    MetricsRetentionDays int `json:"metrics_retention_days,omitempty"`
+    
+    // Logs go to agent.log in the data directory's logs folder as well as the console
+    LogLevel          string    `json:"log_level,omitempty"`  // debug, info, warn or error; default info
+    LogFormat         string    `json:"log_format,omitempty"` // text or json for the log file; default text
+    
+    // Opt-in Prometheus endpoint, e.g. "9464" or "127.0.0.1:9464"; a bare port listens on loopback only
+    MetricsListen     string    `json:"metrics_listen,omitempty"`
+    
+    // Opt-in OTLP/HTTP collector for job traces, e.g. "4318" or "http://127.0.0.1:4318"; a bare port is on loopback
+    TraceEndpoint     string    `json:"trace_endpoint,omitempty"`
+    
+    // Updates are applied when idle, or inside this daily local-time window (e.g. "02:00-05:00")
+    UpdateWindow      string    `json:"update_window,omitempty"`
+    
+    // Network settings for fleets behind proxies and TLS-inspecting gateways
+    Proxy             string    `json:"proxy,omitempty"`       // http://, https:// or socks5:// URL, or "direct"; empty uses HTTP(S)_PROXY
+    NoProxy           string    `json:"no_proxy,omitempty"`    // Comma-separated hosts that bypass Proxy
+    CABundles         []string  `json:"ca_bundles,omitempty"`  // Extra PEM files to trust, e.g. the gateway's CA
+    ClientCert        string    `json:"client_cert,omitempty"` // PEM certificate for mTLS to the API
+    ClientKey         string    `json:"client_key,omitempty"`
+    APIPins           []string  `json:"api_pins,omitempty"`    // Base64 SHA-256 SPKI pins for the API host
 }
 
 // Existing functions remain the same...
@@ -58,7 +83,7 @@ func Load() (*Config, error) {
         return nil, fmt.Errorf("failed to get config directory: %w", err)
     }
     
-    if err := os.MkdirAll(dir, 0755); err != nil {
+    if err := os.MkdirAll(dir, 0700); err != nil {
         return nil, fmt.Errorf("failed to create config directory: %w", err)
     }
     
@@ -115,19 +140,8 @@ func Save(cfg *Config) error {
         return fmt.Errorf("failed to marshal config: %w", err)
     }
     
-    tempPath := configPath + ".tmp"
-    if err := os.WriteFile(tempPath, data, 0644); err != nil {
-        return fmt.Errorf("failed to write config: %w", err)
-    }
-    
-    if err := os.Rename(tempPath, configPath); err != nil {
-        os.Remove(tempPath)
-        if err := os.WriteFile(configPath, data, 0644); err != nil {
-            return fmt.Errorf("failed to save config: %w", err)
-        }
-    }
-    
-    return nil
+    // Credentials live in the secrets store, but keep the rest private too
+    return writePrivate(configPath, data)
 }
 
 func generateDeviceID() string {
@@ -138,6 +152,18 @@ func generateDeviceID() string {
     return "device-" + hex.EncodeToString(bytes[:])
 }
 
+// DataDir returns the directory the agent keeps its state in, creating it if needed
+func DataDir() (string, error) {
+    dir, err := configDir()
+    if err != nil {
+        return "", err
+    }
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return "", err
+    }
+    return dir, nil
+}
+
 func ConfigPath() (string, error) {
     dir, err := configDir()
     if err != nil {
@@ -0,0 +1,156 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/url"
+    "os"
+    "path/filepath"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/secrets"
+)
+
+// legacySecretFields are credentials older builds (or hand edits) left in
+// config.json, and the secret each one moves to ("" means just drop it)
+var legacySecretFields = map[string]string{
+    "bypass_token":        secrets.BypassToken,
+    "vercel_bypass_token": secrets.BypassToken,
+    "refresh_token":       secrets.RefreshToken,
+    "access_token":        "", // Short-lived, a new one is issued on refresh
+}
+
+// MigrateSecrets moves credentials out of plain files into store and tightens
+// permissions on what's left. cfg is the already loaded config, which is
+// scrubbed too so a later Save can't write the credentials back.
+// It is safe to run on every start.
+func MigrateSecrets(cfg *Config, store secrets.Store) error {
+    dir, err := configDir()
+    if err != nil {
+        return fmt.Errorf("failed to get config directory: %w", err)
+    }
+    
+    if err := os.Chmod(dir, 0700); err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("failed to secure config directory: %w", err)
+    }
+    
+    if err := migrateConfigFields(filepath.Join(dir, "config.json"), store); err != nil {
+        return err
+    }
+    cfg.Proxy = withoutPassword(cfg.Proxy)
+    
+    // session.json held the refresh token before the secrets store existed
+    sessionPath := filepath.Join(dir, "session.json")
+    if data, err := os.ReadFile(sessionPath); err == nil {
+        var session struct {
+            RefreshToken string `json:"refresh_token"`
+        }
+        if json.Unmarshal(data, &session) == nil && session.RefreshToken != "" {
+            if err := store.Set(secrets.RefreshToken, session.RefreshToken); err != nil {
+                return fmt.Errorf("failed to migrate session: %w", err)
+            }
+        }
+        os.Remove(sessionPath)
+    }
+    
+    // device.key held the signing key as PEM
+    keyPath := filepath.Join(dir, "device.key")
+    if data, err := os.ReadFile(keyPath); err == nil {
+        if err := store.Set(secrets.DeviceKey, string(data)); err != nil {
+            return fmt.Errorf("failed to migrate device key: %w", err)
+        }
+        os.Remove(keyPath)
+    }
+    
+    return nil
+}
+
+// migrateConfigFields strips legacy credentials from config.json, keeping
+// every other field exactly as written
+func migrateConfigFields(configPath string, store secrets.Store) error {
+    data, err := os.ReadFile(configPath)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to read config: %w", err)
+    }
+    
+    var raw map[string]json.RawMessage
+    if err := json.Unmarshal(data, &raw); err != nil {
+        return fmt.Errorf("failed to parse config: %w", err)
+    }
+    
+    changed := false
+    for field, name := range legacySecretFields {
+        value, ok := raw[field]
+        if !ok {
+            continue
+        }
+        
+        var token string
+        if name != "" && json.Unmarshal(value, &token) == nil && token != "" {
+            if err := store.Set(name, token); err != nil {
+                return fmt.Errorf("failed to migrate %s: %w", field, err)
+            }
+        }
+        delete(raw, field)
+        changed = true
+    }
+    
+    // A password in the proxy URL moves to the store; the username stays
+    if value, ok := raw["proxy"]; ok {
+        var proxy string
+        if json.Unmarshal(value, &proxy) == nil {
+            if parsed, err := url.Parse(proxy); err == nil && parsed.User != nil {
+                if password, set := parsed.User.Password(); set {
+                    if err := store.Set(secrets.ProxyPassword, password); err != nil {
+                        return fmt.Errorf("failed to migrate proxy password: %w", err)
+                    }
+                    raw["proxy"], _ = json.Marshal(withoutPassword(proxy))
+                    changed = true
+                }
+            }
+        }
+    }
+    
+    if !changed {
+        return os.Chmod(configPath, 0600)
+    }
+    
+    cleaned, err := json.MarshalIndent(raw, "", "  ")
+    if err != nil {
+        return fmt.Errorf("failed to marshal config: %w", err)
+    }
+    return writePrivate(configPath, cleaned)
+}
+
+// writePrivate atomically replaces path with data readable only by this user
+func writePrivate(path string, data []byte) error {
+    tempPath := path + ".tmp"
+    if err := os.WriteFile(tempPath, data, 0600); err != nil {
+        return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+    }
+    // WriteFile keeps the mode of an existing temp file
+    if err := os.Chmod(tempPath, 0600); err != nil {
+        os.Remove(tempPath)
+        return err
+    }
+    if err := os.Rename(tempPath, path); err != nil {
+        os.Remove(tempPath)
+        return fmt.Errorf("failed to save %s: %w", filepath.Base(path), err)
+    }
+    return nil
+}
+
+// withoutPassword strips the password from a proxy URL, keeping the username
+func withoutPassword(proxy string) string {
+    parsed, err := url.Parse(proxy)
+    if err != nil || parsed.User == nil {
+        return proxy
+    }
+    if _, set := parsed.User.Password(); !set {
+        return proxy
+    }
+    parsed.User = url.User(parsed.User.Username())
+    return parsed.String()
+}
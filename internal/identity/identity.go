@@ -0,0 +1,87 @@
+package identity
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/hex"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/secrets"
+)
+
+// Identity is the device's signing keypair
+// The public half is registered with the server; the private half never leaves this machine
+type Identity struct {
+    key ed25519.PrivateKey
+}
+
+// LoadOrCreate reads the device key from the secrets store, generating one on first run
+func LoadOrCreate(store secrets.Store) (*Identity, error) {
+    data, err := store.Get(secrets.DeviceKey)
+    if err == nil {
+        key, err := decodeKey([]byte(data))
+        if err != nil {
+            return nil, fmt.Errorf("invalid device key: %w", err)
+        }
+        return &Identity{key: key}, nil
+    }
+    if !errors.Is(err, secrets.ErrNotFound) {
+        return nil, fmt.Errorf("failed to read device key: %w", err)
+    }
+    
+    _, key, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, fmt.Errorf("failed to generate device key: %w", err)
+    }
+    
+    encoded, err := encodeKey(key)
+    if err != nil {
+        return nil, err
+    }
+    if err := store.Set(secrets.DeviceKey, string(encoded)); err != nil {
+        return nil, fmt.Errorf("failed to save device key: %w", err)
+    }
+    
+    return &Identity{key: key}, nil
+}
+
+// PrivateKey returns the signing key
+func (id *Identity) PrivateKey() ed25519.PrivateKey {
+    return id.key
+}
+
+// Fingerprint is a short, stable identifier for the public key
+func (id *Identity) Fingerprint() string {
+    sum := sha256.Sum256(id.key.Public().(ed25519.PublicKey))
+    return hex.EncodeToString(sum[:8])
+}
+
+func encodeKey(key ed25519.PrivateKey) ([]byte, error) {
+    der, err := x509.MarshalPKCS8PrivateKey(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to encode device key: %w", err)
+    }
+    return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func decodeKey(data []byte) (ed25519.PrivateKey, error) {
+    block, _ := pem.Decode(data)
+    if block == nil || block.Type != "PRIVATE KEY" {
+        return nil, fmt.Errorf("not a PEM private key")
+    }
+    
+    parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, err
+    }
+    
+    key, ok := parsed.(ed25519.PrivateKey)
+    if !ok {
+        return nil, fmt.Errorf("not an ed25519 key")
+    }
+    return key, nil
+}
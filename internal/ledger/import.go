@@ -0,0 +1,108 @@
+package ledger
+
+import (
+    "bufio"
+    "compress/gzip"
+    "encoding/json"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+// legacyJob is the subset of a metrics.JobMetrics line the ledger needs
+type legacyJob struct {
+    JobID         string    `json:"job_id"`
+    JobType       string    `json:"job_type"`
+    EndTime       time.Time `json:"end_time"`
+    CPUSeconds    float64   `json:"cpu_seconds"`
+    Success       bool      `json:"success"`
+    CreditedUnits float64   `json:"credited_units"`
+    RateCard      int64     `json:"rate_card_version"`
+    Earnings      float64   `json:"earnings"`
+}
+
+// ImportJobFiles seeds the ledger from the daily jobs_*.json files in dir that
+// the agent wrote before it kept a ledger, so lifetime totals include them
+// Days the history has already gzipped are read too. Jobs already in the
+// ledger are left alone.
+func (l *Ledger) ImportJobFiles(dir string) (int, error) {
+    files, err := filepath.Glob(filepath.Join(dir, "jobs_*.json"))
+    if err != nil {
+        return 0, err
+    }
+    compressed, _ := filepath.Glob(filepath.Join(dir, "jobs_*.json.gz"))
+    files = append(files, compressed...)
+    if len(files) == 0 {
+        return 0, nil
+    }
+    sort.Strings(files)
+    
+    imported := 0
+    for _, path := range files {
+        n, err := l.importFile(path)
+        imported += n
+        if err != nil {
+            return imported, err
+        }
+    }
+    return imported, nil
+}
+
+// importFile records the unknown jobs in one daily file, plain or gzipped
+// A file that can't be read is skipped; only failing to record is an error
+func (l *Ledger) importFile(path string) (int, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return 0, nil
+    }
+    defer file.Close()
+    
+    var reader io.Reader = file
+    if filepath.Ext(path) == ".gz" {
+        gz, err := gzip.NewReader(file)
+        if err != nil {
+            return 0, nil
+        }
+        defer gz.Close()
+        reader = gz
+    }
+    
+    imported := 0
+    scanner := bufio.NewScanner(reader)
+    for scanner.Scan() {
+        var job legacyJob
+        if json.Unmarshal(scanner.Bytes(), &job) != nil || job.JobID == "" {
+            continue
+        }
+        l.mu.Lock()
+        _, known := l.entries[job.JobID]
+        l.mu.Unlock()
+        if known {
+            continue
+        }
+    
+        status := "ok"
+        if !job.Success {
+            // Older agents priced failures too, but they were never paid
+            status = "error"
+            job.CreditedUnits, job.Earnings = 0, 0
+        }
+        err := l.Record(Entry{
+            JobID:      job.JobID,
+            JobType:    job.JobType,
+            Status:     status,
+            Finished:   job.EndTime,
+            CPUSeconds: job.CPUSeconds,
+            Units:      job.CreditedUnits,
+            Estimate:   job.Earnings,
+            RateCard:   job.RateCard,
+        })
+        if err != nil {
+            return imported, err
+        }
+        imported++
+    }
+    return imported, nil
+}
@@ -0,0 +1,336 @@
+// Package ledger keeps the device's lifetime earnings history: what each job
+// was estimated to earn locally and what the server actually credited for it
+package ledger
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "math"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Discrepancy kinds
+const (
+    Missing = "missing" // The server hasn't credited a job that finished a while ago
+    Differs = "differs" // The server credited a different amount than we estimated
+)
+
+// creditGrace is how long the server gets to credit a job before it's missing
+const creditGrace = 24 * time.Hour
+
+// Entry is one job in the ledger
+type Entry struct {
+    JobID      string    `json:"job_id"`
+    JobType    string    `json:"job_type,omitempty"`
+    Status     string    `json:"status,omitempty"`
+    Finished   time.Time `json:"finished"`
+    CPUSeconds float64   `json:"cpu_seconds"`
+    Units      float64   `json:"units"`
+    Estimate   float64   `json:"estimate"`
+    RateCard   int64     `json:"rate_card_version"`
+    
+    Confirmed  bool      `json:"confirmed,omitempty"` // The server has credited it
+    Credited   float64   `json:"credited,omitempty"`
+    CreditedAt time.Time `json:"credited_at,omitempty"`
+}
+
+// Credit is the server's authoritative amount for one job
+type Credit struct {
+    JobID      string
+    Amount     float64
+    CreditedAt time.Time
+}
+
+// Discrepancy is a job whose credit needs the user's attention
+type Discrepancy struct {
+    Kind  string
+    Entry Entry
+}
+
+func (d Discrepancy) String() string {
+    switch d.Kind {
+    case Missing:
+        return fmt.Sprintf("job %s finished %s, estimated $%.4f, not credited yet",
+            d.Entry.JobID, d.Entry.Finished.Local().Format("2006-01-02 15:04"), d.Entry.Estimate)
+    default:
+        return fmt.Sprintf("job %s estimated $%.4f, credited $%.4f",
+            d.Entry.JobID, d.Entry.Estimate, d.Entry.Credited)
+    }
+}
+
+// Totals sums the jobs in some period
+type Totals struct {
+    Jobs      int
+    Estimated float64 // Local estimates for every job
+    Confirmed float64 // What the server has credited
+    Pending   float64 // Estimates for jobs not credited yet
+}
+
+// Summary is the ledger's totals over the usual periods, in local time
+type Summary struct {
+    Today    Totals
+    Week     Totals // Since Monday
+    Month    Totals
+    Lifetime Totals
+}
+
+// record is one line of the ledger file
+type record struct {
+    Op     string  `json:"op"` // "job" | "credit" | "cursor"
+    Entry  *Entry  `json:"entry,omitempty"`
+    Credit *credit `json:"credit,omitempty"`
+    Cursor string  `json:"cursor,omitempty"`
+}
+
+type credit struct {
+    JobID      string    `json:"job_id"`
+    Amount     float64   `json:"amount"`
+    CreditedAt time.Time `json:"credited_at"`
+}
+
+// Ledger is an append-only log of jobs and credits, replayed on open
+type Ledger struct {
+    path string
+    
+    mu      sync.Mutex
+    file    *os.File
+    entries map[string]*Entry
+    cursor  string // Where the next credit fetch picks up
+}
+
+// Open loads the ledger in dir; existed is false if it was just created
+func Open(dir string) (l *Ledger, existed bool, err error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, false, fmt.Errorf("failed to create ledger directory: %w", err)
+    }
+    
+    l = &Ledger{
+        path:    filepath.Join(dir, "ledger.jsonl"),
+        entries: make(map[string]*Entry),
+    }
+    existed, err = l.replay()
+    if err != nil {
+        return nil, false, err
+    }
+    return l, existed, nil
+}
+
+func (l *Ledger) replay() (bool, error) {
+    file, err := os.Open(l.path)
+    if os.IsNotExist(err) {
+        return false, nil
+    }
+    if err != nil {
+        return false, fmt.Errorf("failed to open ledger: %w", err)
+    }
+    defer file.Close()
+    
+    scanner := bufio.NewScanner(file)
+    scanner.Buffer(make([]byte, 64<<10), 1<<20)
+    for scanner.Scan() {
+        var rec record
+        if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+            continue // A torn last line from a crash
+        }
+        l.apply(rec)
+    }
+    if err := scanner.Err(); err != nil {
+        return true, fmt.Errorf("failed to read ledger: %w", err)
+    }
+    return true, nil
+}
+
+// apply folds one record into memory
+func (l *Ledger) apply(rec record) {
+    switch rec.Op {
+    case "job":
+        if rec.Entry == nil {
+            return
+        }
+        entry := *rec.Entry
+        if existing, ok := l.entries[entry.JobID]; ok && existing.Confirmed {
+            // A credit can arrive before a replayed job record
+            entry.Confirmed, entry.Credited, entry.CreditedAt = true, existing.Credited, existing.CreditedAt
+        }
+        l.entries[entry.JobID] = &entry
+    
+    case "credit":
+        if rec.Credit == nil {
+            return
+        }
+        entry, ok := l.entries[rec.Credit.JobID]
+        if !ok {
+            // Credited for a job we have no record of, e.g. the ledger was lost
+            entry = &Entry{JobID: rec.Credit.JobID, Finished: rec.Credit.CreditedAt}
+            l.entries[rec.Credit.JobID] = entry
+        }
+        entry.Confirmed = true
+        entry.Credited = rec.Credit.Amount
+        entry.CreditedAt = rec.Credit.CreditedAt
+    
+    case "cursor":
+        l.cursor = rec.Cursor
+    }
+}
+
+// Record adds a finished job with its local estimate
+// Recording a job again replaces the estimate but keeps any credit
+func (l *Ledger) Record(entry Entry) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    
+    rec := record{Op: "job", Entry: &entry}
+    if err := l.append(rec); err != nil {
+        return err
+    }
+    l.apply(rec)
+    return nil
+}
+
+// Cursor returns where the next fetch of server credits should start
+func (l *Ledger) Cursor() string {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.cursor
+}
+
+// ApplyCredits records the server's credits and the cursor they were fetched up to
+// It returns how many changed what the ledger knew
+func (l *Ledger) ApplyCredits(credits []Credit, cursor string) (int, error) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    
+    changed := 0
+    for _, c := range credits {
+        if entry, ok := l.entries[c.JobID]; ok && entry.Confirmed && entry.Credited == c.Amount {
+            continue
+        }
+        rec := record{Op: "credit", Credit: &credit{JobID: c.JobID, Amount: c.Amount, CreditedAt: c.CreditedAt}}
+        if err := l.append(rec); err != nil {
+            return changed, err
+        }
+        l.apply(rec)
+        changed++
+    }
+    
+    if cursor != "" && cursor != l.cursor {
+        rec := record{Op: "cursor", Cursor: cursor}
+        if err := l.append(rec); err != nil {
+            return changed, err
+        }
+        l.apply(rec)
+    }
+    return changed, nil
+}
+
+// Discrepancies lists jobs whose credit doesn't match what we expected, oldest first
+func (l *Ledger) Discrepancies(now time.Time) []Discrepancy {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    
+    var found []Discrepancy
+    for _, entry := range l.entries {
+        switch {
+        case !entry.Confirmed:
+            // Only successful jobs are owed anything for certain
+            if entry.Status == "ok" && entry.Estimate > 0 && now.Sub(entry.Finished) > creditGrace {
+                found = append(found, Discrepancy{Kind: Missing, Entry: *entry})
+            }
+        case entry.Estimate > 0 && !matches(entry.Estimate, entry.Credited):
+            found = append(found, Discrepancy{Kind: Differs, Entry: *entry})
+        }
+    }
+    sort.Slice(found, func(i, j int) bool { return found[i].Entry.Finished.Before(found[j].Entry.Finished) })
+    return found
+}
+
+// matches allows for rounding on the server: a hundredth of a cent or 1%
+func matches(estimate, credited float64) bool {
+    return math.Abs(estimate-credited) <= math.Max(0.0001, estimate*0.01)
+}
+
+// Summary totals the ledger for today, this week, this month and all time
+func (l *Ledger) Summary(now time.Time) Summary {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    
+    now = now.Local()
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+    week := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+    month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+    
+    var s Summary
+    for _, entry := range l.entries {
+        s.Lifetime.add(entry)
+        if !entry.Finished.Before(month) {
+            s.Month.add(entry)
+        }
+        if !entry.Finished.Before(week) {
+            s.Week.add(entry)
+        }
+        if !entry.Finished.Before(today) {
+            s.Today.add(entry)
+        }
+    }
+    return s
+}
+
+func (t *Totals) add(entry *Entry) {
+    t.Jobs++
+    t.Estimated += entry.Estimate
+    if entry.Confirmed {
+        t.Confirmed += entry.Credited
+    } else {
+        t.Pending += entry.Estimate
+    }
+}
+
+// Entries returns every job, oldest first
+func (l *Ledger) Entries() []Entry {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    
+    entries := make([]Entry, 0, len(l.entries))
+    for _, entry := range l.entries {
+        entries = append(entries, *entry)
+    }
+    sort.Slice(entries, func(i, j int) bool { return entries[i].Finished.Before(entries[j].Finished) })
+    return entries
+}
+
+// Close releases the ledger file
+func (l *Ledger) Close() error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.file == nil {
+        return nil
+    }
+    err := l.file.Close()
+    l.file = nil
+    return err
+}
+
+// append writes one record and syncs it
+func (l *Ledger) append(rec record) error {
+    if l.file == nil {
+        file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+        if err != nil {
+            return fmt.Errorf("failed to open ledger: %w", err)
+        }
+        l.file = file
+    }
+    
+    line, err := json.Marshal(rec)
+    if err != nil {
+        return err
+    }
+    if _, err := l.file.Write(append(line, '\n')); err != nil {
+        return fmt.Errorf("failed to write ledger: %w", err)
+    }
+    return l.file.Sync()
+}
@@ -0,0 +1,187 @@
+package ledger
+
+import (
+    "compress/gzip"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func openTest(t *testing.T, dir string) *Ledger {
+    t.Helper()
+    l, _, err := Open(dir)
+    if err != nil {
+        t.Fatalf("Open: %v", err)
+    }
+    t.Cleanup(func() { l.Close() })
+    return l
+}
+
+func entry(l *Ledger, jobID string) (Entry, bool) {
+    for _, e := range l.Entries() {
+        if e.JobID == jobID {
+            return e, true
+        }
+    }
+    return Entry{}, false
+}
+
+func TestCreditsSurviveReopen(t *testing.T) {
+    dir := t.TempDir()
+    finished := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+    
+    l, existed, err := Open(dir)
+    if err != nil || existed {
+        t.Fatalf("Open = %v, %v; want a new ledger", existed, err)
+    }
+    l.Record(Entry{JobID: "a", Status: "ok", Finished: finished, Estimate: 0.10})
+    l.Record(Entry{JobID: "b", Status: "ok", Finished: finished, Estimate: 0.20})
+    
+    changed, err := l.ApplyCredits([]Credit{
+        {JobID: "a", Amount: 0.10, CreditedAt: finished.Add(time.Hour)},
+        {JobID: "z", Amount: 0.05, CreditedAt: finished}, // A job the ledger never saw
+    }, "cursor-1")
+    if err != nil || changed != 2 {
+        t.Fatalf("ApplyCredits = %d, %v; want 2, nil", changed, err)
+    }
+    
+    // The same credits again change nothing
+    if changed, _ := l.ApplyCredits([]Credit{{JobID: "a", Amount: 0.10}}, "cursor-1"); changed != 0 {
+        t.Errorf("repeated credit changed %d entries, want 0", changed)
+    }
+    
+    // Re-recording a credited job keeps its credit
+    l.Record(Entry{JobID: "a", Status: "ok", Finished: finished, Estimate: 0.11})
+    l.Close()
+    
+    reopened, existed, err := Open(dir)
+    if err != nil || !existed {
+        t.Fatalf("reopen = %v, %v; want the existing ledger", existed, err)
+    }
+    defer reopened.Close()
+    
+    if reopened.Cursor() != "cursor-1" {
+        t.Errorf("Cursor = %q, want cursor-1", reopened.Cursor())
+    }
+    if a, _ := entry(reopened, "a"); !a.Confirmed || a.Credited != 0.10 || a.Estimate != 0.11 {
+        t.Errorf("a = %+v, want the new estimate with the credit kept", a)
+    }
+    if b, _ := entry(reopened, "b"); b.Confirmed {
+        t.Errorf("b confirmed without a credit: %+v", b)
+    }
+    if z, ok := entry(reopened, "z"); !ok || !z.Confirmed || z.Credited != 0.05 {
+        t.Errorf("z = %+v, want the credit kept for a job we had no record of", z)
+    }
+}
+
+func TestReplaySkipsTornLine(t *testing.T) {
+    dir := t.TempDir()
+    l := openTest(t, dir)
+    l.Record(Entry{JobID: "a", Status: "ok", Estimate: 0.10})
+    l.Close()
+    
+    file, err := os.OpenFile(filepath.Join(dir, "ledger.jsonl"), os.O_WRONLY|os.O_APPEND, 0600)
+    if err != nil {
+        t.Fatal(err)
+    }
+    file.WriteString(`{"op":"job","entry":{"job_id":"b","est`)
+    file.Close()
+    
+    if got := len(openTest(t, dir).Entries()); got != 1 {
+        t.Errorf("%d entries after a torn write, want 1", got)
+    }
+}
+
+func TestDiscrepancies(t *testing.T) {
+    l := openTest(t, t.TempDir())
+    now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+    old := now.Add(-2 * creditGrace)
+    
+    l.Record(Entry{JobID: "missing", Status: "ok", Finished: old, Estimate: 0.10})
+    l.Record(Entry{JobID: "recent", Status: "ok", Finished: now.Add(-time.Hour), Estimate: 0.10})
+    l.Record(Entry{JobID: "failed", Status: "error", Finished: old, Estimate: 0.10})
+    l.Record(Entry{JobID: "unpriced", Status: "ok", Finished: old})
+    l.Record(Entry{JobID: "short", Status: "ok", Finished: old.Add(time.Minute), Estimate: 1.00})
+    l.Record(Entry{JobID: "rounded", Status: "ok", Finished: old, Estimate: 1.00})
+    l.ApplyCredits([]Credit{
+        {JobID: "short", Amount: 0.80},
+        {JobID: "rounded", Amount: 0.995}, // Within the 1% allowed for rounding
+    }, "")
+    
+    found := l.Discrepancies(now)
+    if len(found) != 2 {
+        t.Fatalf("Discrepancies = %v, want missing and short", found)
+    }
+    if found[0].Kind != Missing || found[0].Entry.JobID != "missing" {
+        t.Errorf("first = %s %s, want the older missing credit", found[0].Kind, found[0].Entry.JobID)
+    }
+    if found[1].Kind != Differs || found[1].Entry.JobID != "short" {
+        t.Errorf("second = %s %s, want the short credit", found[1].Kind, found[1].Entry.JobID)
+    }
+}
+
+func TestSummaryPeriods(t *testing.T) {
+    l := openTest(t, t.TempDir())
+    // A Wednesday; the week started on Monday the 7th
+    now := time.Date(2026, 9, 9, 15, 0, 0, 0, time.Local)
+    
+    l.Record(Entry{JobID: "today", Status: "ok", Finished: now.Add(-time.Hour), Estimate: 1})
+    l.Record(Entry{JobID: "monday", Status: "ok", Finished: time.Date(2026, 9, 7, 0, 30, 0, 0, time.Local), Estimate: 2})
+    l.Record(Entry{JobID: "sunday", Status: "ok", Finished: time.Date(2026, 9, 6, 23, 30, 0, 0, time.Local), Estimate: 4})
+    l.Record(Entry{JobID: "august", Status: "ok", Finished: time.Date(2026, 8, 31, 12, 0, 0, 0, time.Local), Estimate: 8})
+    l.ApplyCredits([]Credit{{JobID: "monday", Amount: 1.5}, {JobID: "august", Amount: 8}}, "")
+    
+    s := l.Summary(now)
+    tests := []struct {
+        name string
+        got  Totals
+        want Totals
+    }{
+        {"today", s.Today, Totals{Jobs: 1, Estimated: 1, Pending: 1}},
+        {"week", s.Week, Totals{Jobs: 2, Estimated: 3, Confirmed: 1.5, Pending: 1}},
+        {"month", s.Month, Totals{Jobs: 3, Estimated: 7, Confirmed: 1.5, Pending: 5}},
+        {"lifetime", s.Lifetime, Totals{Jobs: 4, Estimated: 15, Confirmed: 9.5, Pending: 5}},
+    }
+    for _, test := range tests {
+        if test.got != test.want {
+            t.Errorf("%s = %+v, want %+v", test.name, test.got, test.want)
+        }
+    }
+}
+
+func TestImportJobFiles(t *testing.T) {
+    dir := t.TempDir()
+    os.WriteFile(filepath.Join(dir, "jobs_2026-01-02.json"), []byte(
+        `{"job_id":"a","job_type":"hash","end_time":"2026-01-02T10:00:00Z","success":true,"credited_units":2,"earnings":0.02}
+{"job_id":"b","end_time":"2026-01-02T11:00:00Z","success":false,"credited_units":1,"earnings":0.01}
+not json
+`), 0600)
+    
+    file, _ := os.Create(filepath.Join(dir, "jobs_2026-01-01.json.gz"))
+    gz := gzip.NewWriter(file)
+    gz.Write([]byte(`{"job_id":"c","end_time":"2026-01-01T10:00:00Z","success":true,"earnings":0.03}` + "\n"))
+    gz.Close()
+    file.Close()
+    
+    l := openTest(t, t.TempDir())
+    l.Record(Entry{JobID: "c", Status: "ok", Estimate: 0.05})
+    
+    imported, err := l.ImportJobFiles(dir)
+    if err != nil || imported != 2 {
+        t.Fatalf("ImportJobFiles = %d, %v; want 2, nil", imported, err)
+    }
+    if a, _ := entry(l, "a"); a.Status != "ok" || a.Units != 2 || a.Estimate != 0.02 {
+        t.Errorf("a = %+v", a)
+    }
+    if b, _ := entry(l, "b"); b.Status != "error" || b.Units != 0 || b.Estimate != 0 {
+        t.Errorf("b = %+v, want a failed job imported as earning nothing", b)
+    }
+    if c, _ := entry(l, "c"); c.Estimate != 0.05 {
+        t.Errorf("c = %+v, want the ledger's own entry left alone", c)
+    }
+    
+    if imported, _ := l.ImportJobFiles(dir); imported != 0 {
+        t.Errorf("second import added %d jobs, want 0", imported)
+    }
+}
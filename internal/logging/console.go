@@ -0,0 +1,116 @@
+package logging
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "io"
+    "log/slog"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// consoleHandler writes short lines for a person watching the terminal:
+//
+//   15:04:05 Heartbeat OK
+//   15:04:05 WARN Heartbeat failed error="connection refused"
+type consoleHandler struct {
+    w      io.Writer
+    mu     *sync.Mutex
+    level  slog.Leveler
+    attrs  string // Preformatted attributes from WithAttrs
+    prefix string // Group prefix for keys, e.g. "job."
+}
+
+func newConsoleHandler(w io.Writer, level slog.Leveler) *consoleHandler {
+    return &consoleHandler{w: w, mu: &sync.Mutex{}, level: level}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+    return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+    var buf bytes.Buffer
+    t := r.Time
+    if t.IsZero() {
+        t = time.Now()
+    }
+    buf.WriteString(t.Format("15:04:05"))
+    if r.Level != slog.LevelInfo {
+        buf.WriteByte(' ')
+        buf.WriteString(r.Level.String())
+    }
+    buf.WriteByte(' ')
+    buf.WriteString(r.Message)
+    buf.WriteString(h.attrs)
+    r.Attrs(func(attr slog.Attr) bool {
+        appendAttr(&buf, h.prefix, attr)
+        return true
+    })
+    buf.WriteByte('\n')
+    
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    _, err := h.w.Write(buf.Bytes())
+    return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    var buf bytes.Buffer
+    for _, attr := range attrs {
+        // The subsystem and device are in the log file; on the console they're noise
+        if h.prefix == "" && (attr.Key == "subsystem" || attr.Key == "device_id") {
+            continue
+        }
+        appendAttr(&buf, h.prefix, attr)
+    }
+    next := *h
+    next.attrs += buf.String()
+    return &next
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+    if name == "" {
+        return h
+    }
+    next := *h
+    next.prefix += name + "."
+    return &next
+}
+
+func appendAttr(buf *bytes.Buffer, prefix string, attr slog.Attr) {
+    value := attr.Value.Resolve()
+    if attr.Equal(slog.Attr{}) {
+        return
+    }
+    if value.Kind() == slog.KindGroup {
+        for _, member := range value.Group() {
+            appendAttr(buf, prefix+attr.Key+".", member)
+        }
+        return
+    }
+    
+    buf.WriteByte(' ')
+    buf.WriteString(prefix)
+    buf.WriteString(attr.Key)
+    buf.WriteByte('=')
+    
+    var text string
+    switch value.Kind() {
+    case slog.KindDuration:
+        text = value.Duration().String()
+    case slog.KindTime:
+        text = value.Time().Format(time.RFC3339)
+    case slog.KindFloat64:
+        text = strconv.FormatFloat(value.Float64(), 'f', -1, 64)
+    default:
+        text = fmt.Sprint(value.Any())
+    }
+    if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+        text = strconv.Quote(text)
+    }
+    buf.WriteString(text)
+}
@@ -0,0 +1,139 @@
+// Package logging sets up the agent's structured logs: leveled slog records
+// to the console and to rotating files in the data directory, with emails
+// and credentials redacted before anything is written
+package logging
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Options controls where logs go and how much is kept
+type Options struct {
+    Level    slog.Level
+    Format   string    // "text" or "json", for the log file
+    Dir      string    // Where agent.log is written, "" for console only
+    MaxBytes int64     // Size at which agent.log is rotated
+    MaxFiles int       // Rotated files kept besides agent.log
+    Console  io.Writer // Human-readable copy, usually stdout; nil for none
+}
+
+// Defaults for Options
+const (
+    DefaultMaxBytes = 10 << 20
+    DefaultMaxFiles = 5
+)
+
+// ParseLevel reads debug, info, warn or error; "" is info
+func ParseLevel(s string) (slog.Level, error) {
+    var level slog.Level
+    if s == "" {
+        return slog.LevelInfo, nil
+    }
+    if err := level.UnmarshalText([]byte(s)); err != nil {
+        return slog.LevelInfo, fmt.Errorf("unknown log level %q", s)
+    }
+    return level, nil
+}
+
+// Setup builds the logger described by opts and makes it slog's default
+// The returned closer flushes and closes the log file
+func Setup(opts Options) (*slog.Logger, io.Closer, error) {
+    var handlers []slog.Handler
+    var closer io.Closer = nopCloser{}
+    
+    if opts.Console != nil {
+        handlers = append(handlers, newConsoleHandler(opts.Console, opts.Level))
+    }
+    
+    if opts.Dir != "" {
+        if opts.MaxBytes <= 0 {
+            opts.MaxBytes = DefaultMaxBytes
+        }
+        if opts.MaxFiles <= 0 {
+            opts.MaxFiles = DefaultMaxFiles
+        }
+        file, err := OpenRotating(filepath.Join(opts.Dir, "agent.log"), opts.MaxBytes, opts.MaxFiles)
+        if err != nil {
+            return nil, nil, err
+        }
+        closer = file
+    
+        handlerOpts := &slog.HandlerOptions{Level: opts.Level}
+        switch strings.ToLower(opts.Format) {
+        case "", "text":
+            handlers = append(handlers, slog.NewTextHandler(file, handlerOpts))
+        case "json":
+            handlers = append(handlers, slog.NewJSONHandler(file, handlerOpts))
+        default:
+            file.Close()
+            return nil, nil, fmt.Errorf("unknown log format %q, want text or json", opts.Format)
+        }
+    }
+    
+    logger := slog.New(Redact(fanout(handlers)))
+    slog.SetDefault(logger)
+    return logger, closer, nil
+}
+
+// Subsystem returns the default logger tagged with a subsystem name
+// It's looked up on each call so packages pick up the logger Setup installed
+func Subsystem(name string) *slog.Logger {
+    return slog.Default().With("subsystem", name)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// fanout sends each record to every handler that wants it
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+    for _, h := range f {
+        if h.Enabled(ctx, level) {
+            return true
+        }
+    }
+    return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+    var errs []error
+    for _, h := range f {
+        if h.Enabled(ctx, r.Level) {
+            if err := h.Handle(ctx, r.Clone()); err != nil {
+                errs = append(errs, err)
+            }
+        }
+    }
+    return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+    next := make(fanout, len(f))
+    for i, h := range f {
+        next[i] = h.WithAttrs(attrs)
+    }
+    return next
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+    next := make(fanout, len(f))
+    for i, h := range f {
+        next[i] = h.WithGroup(name)
+    }
+    return next
+}
+
+// Fatal logs at error level and exits, for failures the agent can't run without
+func Fatal(msg string, args ...any) {
+    slog.Error(msg, args...)
+    os.Exit(1)
+}
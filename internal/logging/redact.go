@@ -0,0 +1,115 @@
+package logging
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "regexp"
+    "strings"
+)
+
+// Redacted replaces anything the redactor removes
+const Redacted = "[redacted]"
+
+// sensitiveKeys are attribute keys whose values are never logged
+var sensitiveKeys = map[string]bool{
+    "email":         true,
+    "token":         true,
+    "access_token":  true,
+    "refresh_token": true,
+    "bypass":        true,
+    "bypass_token":  true,
+    "secret":        true,
+    "password":      true,
+    "authorization": true,
+    "cookie":        true,
+    "private_key":   true,
+}
+
+// scrubbers find credentials and emails inside free text such as error messages
+var scrubbers = []struct {
+    pattern     *regexp.Regexp
+    replacement string
+}{
+    {regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`), "Bearer " + Redacted},
+    {regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), Redacted},
+    {regexp.MustCompile(`(?i)\b((?:access_|refresh_)?token|x-vercel-protection-bypass|x-vercel-set-bypass-cookie|bypass|secret|password)=[^&\s"]+`), "$1=" + Redacted},
+    {regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), Redacted},
+}
+
+// Scrub removes emails and credentials from s
+func Scrub(s string) string {
+    for _, scrubber := range scrubbers {
+        s = scrubber.pattern.ReplaceAllString(s, scrubber.replacement)
+    }
+    return s
+}
+
+// Redact wraps next so records reach it with emails and credentials removed,
+// from the message and from every attribute, by key and by content
+func Redact(next slog.Handler) slog.Handler {
+    return &redactor{next: next}
+}
+
+type redactor struct {
+    next slog.Handler
+}
+
+func (h *redactor) Enabled(ctx context.Context, level slog.Level) bool {
+    return h.next.Enabled(ctx, level)
+}
+
+func (h *redactor) Handle(ctx context.Context, r slog.Record) error {
+    clean := slog.NewRecord(r.Time, r.Level, Scrub(r.Message), r.PC)
+    r.Attrs(func(attr slog.Attr) bool {
+        clean.AddAttrs(redactAttr(attr))
+        return true
+    })
+    return h.next.Handle(ctx, clean)
+}
+
+func (h *redactor) WithAttrs(attrs []slog.Attr) slog.Handler {
+    clean := make([]slog.Attr, len(attrs))
+    for i, attr := range attrs {
+        clean[i] = redactAttr(attr)
+    }
+    return &redactor{next: h.next.WithAttrs(clean)}
+}
+
+func (h *redactor) WithGroup(name string) slog.Handler {
+    return &redactor{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+    if sensitiveKeys[strings.ToLower(attr.Key)] {
+        return slog.String(attr.Key, Redacted)
+    }
+    
+    value := attr.Value.Resolve()
+    switch value.Kind() {
+    case slog.KindString:
+        return slog.String(attr.Key, Scrub(value.String()))
+    case slog.KindGroup:
+        group := value.Group()
+        clean := make([]any, len(group))
+        for i, member := range group {
+            clean[i] = redactAttr(member)
+        }
+        return slog.Group(attr.Key, clean...)
+    case slog.KindAny:
+        // Errors and Stringers are logged as text, so scrub that text
+        switch v := value.Any().(type) {
+        case error:
+            return slog.String(attr.Key, Scrub(v.Error()))
+        case fmt.Stringer:
+            return slog.String(attr.Key, Scrub(v.String()))
+        case []string:
+            clean := make([]string, len(v))
+            for i, s := range v {
+                clean[i] = Scrub(s)
+            }
+            return slog.Any(attr.Key, clean)
+        }
+    }
+    return slog.Attr{Key: attr.Key, Value: value}
+}
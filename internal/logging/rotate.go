@@ -0,0 +1,100 @@
+package logging
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+// RotatingFile is a log file that moves aside when it grows too big:
+// agent.log becomes agent.log.1, agent.log.1 becomes agent.log.2, and so on,
+// keeping at most maxFiles old ones
+type RotatingFile struct {
+    path     string
+    maxBytes int64
+    maxFiles int
+    
+    mu   sync.Mutex
+    file *os.File
+    size int64
+}
+
+// OpenRotating opens path for appending, creating its directory if needed
+func OpenRotating(path string, maxBytes int64, maxFiles int) (*RotatingFile, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+        return nil, fmt.Errorf("failed to create log directory: %w", err)
+    }
+    r := &RotatingFile{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+    if err := r.open(); err != nil {
+        return nil, err
+    }
+    return r, nil
+}
+
+func (r *RotatingFile) open() error {
+    file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to open log file: %w", err)
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return fmt.Errorf("failed to open log file: %w", err)
+    }
+    r.file = file
+    r.size = info.Size()
+    return nil
+}
+
+// Write appends p, rotating first if it would take the file past maxBytes
+// A record is never split across files
+func (r *RotatingFile) Write(p []byte) (int, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    
+    if r.file == nil {
+        return 0, os.ErrClosed
+    }
+    if r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+        if err := r.rotate(); err != nil {
+            // Keep logging to the oversized file rather than lose records
+            fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+        }
+    }
+    
+    n, err := r.file.Write(p)
+    r.size += int64(n)
+    return n, err
+}
+
+// rotate shifts the old files along and starts a fresh one
+func (r *RotatingFile) rotate() error {
+    if err := r.file.Close(); err != nil {
+        return err
+    }
+    r.file = nil
+    
+    os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxFiles))
+    for i := r.maxFiles - 1; i >= 1; i-- {
+        os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+    }
+    renameErr := os.Rename(r.path, r.path+".1")
+    
+    if err := r.open(); err != nil {
+        return err
+    }
+    return renameErr
+}
+
+// Close closes the current file
+func (r *RotatingFile) Close() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.file == nil {
+        return nil
+    }
+    err := r.file.Close()
+    r.file = nil
+    return err
+}
@@ -0,0 +1,481 @@
+package metrics
+
+import (
+    "bufio"
+    "compress/gzip"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Retention decides how long job history is kept, and in what form
+// Days are kept as plain daily files, then gzipped, and finally only as part
+// of their month's summary
+type Retention struct {
+    PlainDays  int // Days kept uncompressed, counting today
+    DetailDays int // Days of per-job detail kept in all, compressed or not
+    Months     int // Monthly summaries kept, 0 for all of them
+    // Detail is dropped a month at a time once the month's last day is older than DetailDays
+}
+
+// DefaultRetention keeps two plain days, 90 days of detail and every monthly summary
+var DefaultRetention = Retention{PlainDays: 2, DetailDays: 90}
+
+// History is the on-disk job history under the agent's data directory:
+//
+//   jobs_2024-05-01.json      one JobMetrics per line
+//   jobs_2024-04-01.json.gz   the same, compressed
+//   summary_2024-03.json      a month rolled up into totals
+type History struct {
+    dir       string
+    retention Retention
+    mu        sync.Mutex
+}
+
+// MonthSummary is a month of jobs rolled up
+type MonthSummary struct {
+    Month          string                  `json:"month"` // 2006-01
+    Jobs           int                     `json:"jobs"`
+    Succeeded      int                     `json:"succeeded"`
+    Failed         int                     `json:"failed"`
+    ElapsedSeconds float64                 `json:"elapsed_seconds"`
+    CPUSeconds     float64                 `json:"cpu_seconds"`
+    CreditedUnits  float64                 `json:"credited_units"`
+    Earnings       float64                 `json:"earnings"` // Estimates
+    ByType         map[string]*TypeSummary `json:"by_type,omitempty"`
+}
+
+// TypeSummary is a month of one job type
+type TypeSummary struct {
+    Jobs       int     `json:"jobs"`
+    CPUSeconds float64 `json:"cpu_seconds"`
+    Earnings   float64 `json:"earnings"`
+}
+
+// HistoryDir is where job history lives under the data directory
+func HistoryDir(dataDir string) string {
+    return filepath.Join(dataDir, "metrics")
+}
+
+// LegacyHistoryDir is where agents before the data directory wrote job history
+func LegacyHistoryDir() string {
+    homeDir, _ := os.UserHomeDir()
+    return filepath.Join(homeDir, ".idlenet", "metrics")
+}
+
+// OpenHistory prepares the history in dir
+func OpenHistory(dir string, retention Retention) (*History, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, fmt.Errorf("failed to create metrics directory: %w", err)
+    }
+    if retention.PlainDays < 1 {
+        retention.PlainDays = 1
+    }
+    if retention.DetailDays < retention.PlainDays {
+        retention.DetailDays = retention.PlainDays
+    }
+    return &History{dir: dir, retention: retention}, nil
+}
+
+// Dir returns the directory the history is kept in
+func (h *History) Dir() string {
+    return h.dir
+}
+
+// Append adds job to the file for the day it finished
+func (h *History) Append(job *JobMetrics) error {
+    data, err := json.Marshal(job)
+    if err != nil {
+        return fmt.Errorf("failed to encode job metrics: %w", err)
+    }
+    
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    
+    path := filepath.Join(h.dir, dayFile(job.EndTime))
+    file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to save job metrics: %w", err)
+    }
+    if _, err := file.Write(append(data, '\n')); err != nil {
+        file.Close()
+        return fmt.Errorf("failed to save job metrics: %w", err)
+    }
+    if err := file.Close(); err != nil {
+        return fmt.Errorf("failed to save job metrics: %w", err)
+    }
+    return nil
+}
+
+func dayFile(t time.Time) string {
+    return fmt.Sprintf("jobs_%s.json", t.Local().Format("2006-01-02"))
+}
+
+// MigrateFrom moves daily files left in an older history directory into this one
+// Days present in both are merged. The old directory is removed once empty.
+func (h *History) MigrateFrom(oldDir string) (int, error) {
+    files, err := filepath.Glob(filepath.Join(oldDir, "jobs_*.json"))
+    if err != nil || len(files) == 0 {
+        return 0, err
+    }
+    
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    
+    moved := 0
+    for _, path := range files {
+        target := filepath.Join(h.dir, filepath.Base(path))
+        if _, err := os.Stat(target); os.IsNotExist(err) {
+            if err := os.Rename(path, target); err == nil {
+                os.Chmod(target, 0600)
+                moved++
+                continue
+            }
+        }
+    
+        // Already there, or on another filesystem: append and remove
+        if err := appendFile(target, path); err != nil {
+            return moved, err
+        }
+        os.Remove(path)
+        moved++
+    }
+    
+    // Only removes them if nothing else was kept there
+    os.Remove(oldDir)
+    os.Remove(filepath.Dir(oldDir))
+    return moved, nil
+}
+
+func appendFile(target, source string) error {
+    in, err := os.Open(source)
+    if err != nil {
+        return fmt.Errorf("failed to migrate %s: %w", filepath.Base(source), err)
+    }
+    defer in.Close()
+    
+    out, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to migrate %s: %w", filepath.Base(source), err)
+    }
+    if _, err := io.Copy(out, in); err != nil {
+        out.Close()
+        return fmt.Errorf("failed to migrate %s: %w", filepath.Base(source), err)
+    }
+    return out.Close()
+}
+
+// Maintain applies the retention policy as of now: old days are gzipped,
+// finished months are rolled up, and detail and summaries past retention
+// are deleted. It carries on past individual failures and returns the first.
+func (h *History) Maintain(now time.Time) error {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    
+    var firstErr error
+    note := func(err error) {
+        if err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    
+    now = now.Local()
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+    plainFrom := today.AddDate(0, 0, 1-h.retention.PlainDays)
+    detailFrom := today.AddDate(0, 0, 1-h.retention.DetailDays)
+    thisMonth := today.Format("2006-01")
+    
+    days, err := h.days()
+    if err != nil {
+        return err
+    }
+    
+    // Compress days that are no longer plain
+    for day, files := range days {
+        if !day.Before(plainFrom) || files.plain == "" {
+            continue
+        }
+        note(gzipInto(files.plain, filepath.Join(h.dir, dayFile(day)+".gz")))
+    }
+    
+    // Detail goes a whole month at a time, once the month's last day is past
+    // retention, so a summary is never rebuilt from part of its month
+    expired := func(month string) bool {
+        start, err := time.ParseInLocation("2006-01", month, now.Location())
+        return err == nil && start.AddDate(0, 1, 0).Before(detailFrom.AddDate(0, 0, 1))
+    }
+    
+    // Roll up every finished month whose summary is missing or out of date
+    months := make(map[string]bool)
+    for day := range days {
+        if month := day.Format("2006-01"); month < thisMonth {
+            months[month] = true
+        }
+    }
+    for month := range months {
+        if _, err := os.Stat(h.summaryPath(month)); err == nil && expired(month) {
+            continue // Only a straggler could have changed it, and it's past keeping
+        }
+        note(h.rollUp(month))
+    }
+    
+    // Drop detail past retention, but only once its month is summarised
+    days, err = h.days()
+    if err != nil {
+        return err
+    }
+    for day, files := range days {
+        month := day.Format("2006-01")
+        if month >= thisMonth || !expired(month) {
+            continue
+        }
+        if _, err := os.Stat(h.summaryPath(month)); err != nil {
+            continue
+        }
+        for _, path := range []string{files.plain, files.gz} {
+            if path != "" {
+                note(os.Remove(path))
+            }
+        }
+    }
+    
+    // Drop summaries past retention
+    if h.retention.Months > 0 {
+        oldest := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location()).
+            AddDate(0, -h.retention.Months, 0).Format("2006-01")
+        summaries, _ := filepath.Glob(filepath.Join(h.dir, "summary_*.json"))
+        for _, path := range summaries {
+            month := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "summary_"), ".json")
+            if month < oldest {
+                note(os.Remove(path))
+            }
+        }
+    }
+    
+    return firstErr
+}
+
+type dayFiles struct {
+    plain string
+    gz    string
+}
+
+// days lists the daily files on disk by day
+func (h *History) days() (map[time.Time]*dayFiles, error) {
+    entries, err := os.ReadDir(h.dir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read metrics directory: %w", err)
+    }
+    
+    days := make(map[time.Time]*dayFiles)
+    for _, entry := range entries {
+        name := entry.Name()
+        if !strings.HasPrefix(name, "jobs_") {
+            continue
+        }
+        base := strings.TrimSuffix(name, ".gz")
+        day, err := time.ParseInLocation("2006-01-02", strings.TrimSuffix(strings.TrimPrefix(base, "jobs_"), ".json"), time.Local)
+        if err != nil || !strings.HasSuffix(base, ".json") {
+            continue
+        }
+    
+        files := days[day]
+        if files == nil {
+            files = &dayFiles{}
+            days[day] = files
+        }
+        if base == name {
+            files.plain = filepath.Join(h.dir, name)
+        } else {
+            files.gz = filepath.Join(h.dir, name)
+        }
+    }
+    return days, nil
+}
+
+// gzipInto compresses plain onto the end of gzPath and removes plain
+// A .gz that already exists gains another gzip member, which readers
+// treat as one continuous stream
+func gzipInto(plain, gzPath string) error {
+    in, err := os.Open(plain)
+    if err != nil {
+        return fmt.Errorf("failed to compress %s: %w", filepath.Base(plain), err)
+    }
+    defer in.Close()
+    
+    info, statErr := os.Stat(gzPath)
+    out, err := os.OpenFile(gzPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to compress %s: %w", filepath.Base(plain), err)
+    }
+    
+    writer := gzip.NewWriter(out)
+    _, err = io.Copy(writer, in)
+    if err == nil {
+        err = writer.Close()
+    }
+    if err == nil {
+        err = out.Sync()
+    }
+    if closeErr := out.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        // Cut back to what was there so a half-written member can't corrupt the rest
+        if statErr == nil {
+            os.Truncate(gzPath, info.Size())
+        } else {
+            os.Remove(gzPath)
+        }
+        return fmt.Errorf("failed to compress %s: %w", filepath.Base(plain), err)
+    }
+    
+    in.Close()
+    return os.Remove(plain)
+}
+
+// rollUp writes month's summary from its daily files if it's missing or
+// older than any of them
+func (h *History) rollUp(month string) error {
+    var sources []string
+    for _, pattern := range []string{"jobs_" + month + "-*.json", "jobs_" + month + "-*.json.gz"} {
+        matches, _ := filepath.Glob(filepath.Join(h.dir, pattern))
+        sources = append(sources, matches...)
+    }
+    sort.Strings(sources)
+    
+    summaryPath := h.summaryPath(month)
+    if info, err := os.Stat(summaryPath); err == nil {
+        current := true
+        for _, source := range sources {
+            if sourceInfo, err := os.Stat(source); err == nil && sourceInfo.ModTime().After(info.ModTime()) {
+                current = false
+            }
+        }
+        if current {
+            return nil
+        }
+    }
+    
+    summary := &MonthSummary{Month: month, ByType: make(map[string]*TypeSummary)}
+    for _, source := range sources {
+        if err := readJobs(source, summary.add); err != nil {
+            return err
+        }
+    }
+    
+    data, err := json.MarshalIndent(summary, "", "  ")
+    if err != nil {
+        return err
+    }
+    tempPath := summaryPath + ".tmp"
+    if err := os.WriteFile(tempPath, data, 0600); err != nil {
+        return fmt.Errorf("failed to write %s summary: %w", month, err)
+    }
+    if err := os.Rename(tempPath, summaryPath); err != nil {
+        os.Remove(tempPath)
+        return fmt.Errorf("failed to write %s summary: %w", month, err)
+    }
+    return nil
+}
+
+func (h *History) summaryPath(month string) string {
+    return filepath.Join(h.dir, "summary_"+month+".json")
+}
+
+func (s *MonthSummary) add(job *JobMetrics) {
+    s.Jobs++
+    if job.Success {
+        s.Succeeded++
+    } else {
+        s.Failed++
+    }
+    s.ElapsedSeconds += job.ElapsedSeconds
+    s.CPUSeconds += job.CPUSeconds
+    s.CreditedUnits += job.CreditedUnits
+    s.Earnings += job.Earnings
+    
+    jobType := job.JobType
+    if jobType == "" {
+        jobType = "unknown"
+    }
+    byType := s.ByType[jobType]
+    if byType == nil {
+        byType = &TypeSummary{}
+        s.ByType[jobType] = byType
+    }
+    byType.Jobs++
+    byType.CPUSeconds += job.CPUSeconds
+    byType.Earnings += job.Earnings
+}
+
+// readJobs calls fn for each job in a daily file, plain or gzipped
+func readJobs(path string, fn func(*JobMetrics)) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+    }
+    defer file.Close()
+    
+    var reader io.Reader = file
+    if strings.HasSuffix(path, ".gz") {
+        gz, err := gzip.NewReader(file)
+        if err != nil {
+            return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+        }
+        defer gz.Close()
+        reader = gz
+    }
+    
+    scanner := bufio.NewScanner(reader)
+    scanner.Buffer(make([]byte, 64<<10), 1<<20)
+    for scanner.Scan() {
+        var job JobMetrics
+        if json.Unmarshal(scanner.Bytes(), &job) != nil {
+            continue
+        }
+        fn(&job)
+    }
+    if err := scanner.Err(); err != nil {
+        return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+    }
+    return nil
+}
+
+// Jobs calls fn for every job still kept in detail, oldest day first
+// The history is locked throughout so Maintain can't compress or delete a
+// file partway through; fn mustn't call back into the History
+func (h *History) Jobs(fn func(*JobMetrics)) error {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    
+    days, err := h.days()
+    if err != nil {
+        return err
+    }
+    
+    ordered := make([]time.Time, 0, len(days))
+    for day := range days {
+        ordered = append(ordered, day)
+    }
+    sort.Slice(ordered, func(i, j int) bool { return ordered[i].Before(ordered[j]) })
+    
+    for _, day := range ordered {
+        // The compressed part of a day is older than its plain part
+        for _, path := range []string{days[day].gz, days[day].plain} {
+            if path == "" {
+                continue
+            }
+            if err := readJobs(path, fn); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
@@ -0,0 +1,190 @@
+package metrics
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func openTestHistory(t *testing.T, retention Retention) *History {
+    t.Helper()
+    h, err := OpenHistory(t.TempDir(), retention)
+    if err != nil {
+        t.Fatalf("OpenHistory: %v", err)
+    }
+    return h
+}
+
+func at(year int, month time.Month, day, hour int) time.Time {
+    return time.Date(year, month, day, hour, 0, 0, 0, time.Local)
+}
+
+func appendJob(t *testing.T, h *History, id, jobType string, end time.Time, success bool, earnings float64) {
+    t.Helper()
+    job := &JobMetrics{JobID: id, JobType: jobType, EndTime: end, Success: success, CPUSeconds: 10, Earnings: earnings}
+    if err := h.Append(job); err != nil {
+        t.Fatalf("Append %s: %v", id, err)
+    }
+}
+
+func files(t *testing.T, h *History) []string {
+    t.Helper()
+    entries, err := os.ReadDir(h.Dir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for _, entry := range entries {
+        names = append(names, entry.Name())
+    }
+    return names
+}
+
+func jobIDs(t *testing.T, h *History) string {
+    t.Helper()
+    var ids []string
+    if err := h.Jobs(func(job *JobMetrics) { ids = append(ids, job.JobID) }); err != nil {
+        t.Fatalf("Jobs: %v", err)
+    }
+    return strings.Join(ids, ",")
+}
+
+func readSummary(t *testing.T, h *History, month string) *MonthSummary {
+    t.Helper()
+    data, err := os.ReadFile(h.summaryPath(month))
+    if err != nil {
+        t.Fatalf("summary for %s: %v", month, err)
+    }
+    var summary MonthSummary
+    if err := json.Unmarshal(data, &summary); err != nil {
+        t.Fatal(err)
+    }
+    return &summary
+}
+
+func TestMaintainCompressesOldDays(t *testing.T) {
+    h := openTestHistory(t, Retention{PlainDays: 2, DetailDays: 90})
+    now := at(2026, 5, 20, 12)
+    appendJob(t, h, "old", "hash", at(2026, 5, 17, 9), true, 1)
+    appendJob(t, h, "yesterday", "hash", at(2026, 5, 19, 9), true, 1)
+    appendJob(t, h, "today", "hash", at(2026, 5, 20, 9), true, 1)
+    
+    if err := h.Maintain(now); err != nil {
+        t.Fatalf("Maintain: %v", err)
+    }
+    want := "jobs_2026-05-17.json.gz jobs_2026-05-19.json jobs_2026-05-20.json"
+    if got := strings.Join(files(t, h), " "); got != want {
+        t.Errorf("files = %s, want %s", got, want)
+    }
+    
+    // A job for a day that's already compressed lands in a new plain file,
+    // which the next pass appends to the .gz as another member
+    appendJob(t, h, "straggler", "hash", at(2026, 5, 17, 23), true, 1)
+    if err := h.Maintain(now); err != nil {
+        t.Fatalf("Maintain: %v", err)
+    }
+    if got := strings.Join(files(t, h), " "); got != want {
+        t.Errorf("files = %s, want %s", got, want)
+    }
+    if got := jobIDs(t, h); got != "old,straggler,yesterday,today" {
+        t.Errorf("Jobs = %s, want every job oldest day first", got)
+    }
+}
+
+func TestMaintainRollsUpFinishedMonths(t *testing.T) {
+    h := openTestHistory(t, DefaultRetention)
+    appendJob(t, h, "a", "hash", at(2026, 2, 27, 9), true, 0.5)
+    appendJob(t, h, "b", "wasm", at(2026, 2, 28, 23), false, 0)
+    appendJob(t, h, "c", "", at(2026, 2, 28, 23), true, 0.25)
+    appendJob(t, h, "d", "hash", at(2026, 3, 1, 0), true, 1)
+    
+    // Just past midnight on the first: February is done, March has begun
+    if err := h.Maintain(at(2026, 3, 1, 1)); err != nil {
+        t.Fatalf("Maintain: %v", err)
+    }
+    feb := readSummary(t, h, "2026-02")
+    if feb.Jobs != 3 || feb.Succeeded != 2 || feb.Failed != 1 || feb.Earnings != 0.75 || feb.CPUSeconds != 30 {
+        t.Errorf("February = %+v, want 3 jobs, 2 ok, 1 failed, 0.75 earned, 30 CPU seconds", feb)
+    }
+    if hash := feb.ByType["hash"]; hash == nil || hash.Jobs != 1 || hash.Earnings != 0.5 {
+        t.Errorf("February hash = %+v, want 1 job earning 0.5", hash)
+    }
+    if unknown := feb.ByType["unknown"]; unknown == nil || unknown.Jobs != 1 {
+        t.Errorf("February unknown = %+v, want the untyped job", unknown)
+    }
+    if _, err := os.Stat(h.summaryPath("2026-03")); !os.IsNotExist(err) {
+        t.Errorf("March rolled up while still under way: %v", err)
+    }
+    
+    // A straggler for February makes its summary stale
+    appendJob(t, h, "e", "hash", at(2026, 2, 28, 12), true, 0.25)
+    later := time.Now().Add(time.Minute)
+    os.Chtimes(filepath.Join(h.Dir(), "jobs_2026-02-28.json"), later, later)
+    if err := h.Maintain(at(2026, 3, 1, 2)); err != nil {
+        t.Fatalf("Maintain: %v", err)
+    }
+    if feb := readSummary(t, h, "2026-02"); feb.Jobs != 4 || feb.Earnings != 1 {
+        t.Errorf("February after straggler = %d jobs, %v earned; want 4 and 1", feb.Jobs, feb.Earnings)
+    }
+}
+
+func TestMaintainDropsDetailByWholeMonth(t *testing.T) {
+    h := openTestHistory(t, Retention{PlainDays: 1, DetailDays: 10, Months: 2})
+    appendJob(t, h, "jan", "hash", at(2026, 1, 15, 9), true, 1)
+    appendJob(t, h, "feb-early", "hash", at(2026, 2, 1, 9), true, 1)
+    appendJob(t, h, "feb-late", "hash", at(2026, 2, 28, 9), true, 1)
+    
+    // February's early days are past DetailDays, but its last day isn't
+    if err := h.Maintain(at(2026, 3, 5, 12)); err != nil {
+        t.Fatalf("Maintain: %v", err)
+    }
+    if got := jobIDs(t, h); got != "feb-early,feb-late" {
+        t.Errorf("Jobs on March 5 = %s, want all of February kept", got)
+    }
+    
+    // Once all of February is past retention only its summary is left,
+    // and January's summary is beyond the two months kept
+    if err := h.Maintain(at(2026, 4, 1, 12)); err != nil {
+        t.Fatalf("Maintain: %v", err)
+    }
+    if got := jobIDs(t, h); got != "" {
+        t.Errorf("Jobs on April 1 = %s, want no detail left", got)
+    }
+    if got := strings.Join(files(t, h), " "); got != "summary_2026-02.json" {
+        t.Errorf("files = %s, want only February's summary", got)
+    }
+    if feb := readSummary(t, h, "2026-02"); feb.Jobs != 2 {
+        t.Errorf("February summary has %d jobs, want 2", feb.Jobs)
+    }
+}
+
+func TestJobsWhileMaintaining(t *testing.T) {
+    h := openTestHistory(t, Retention{PlainDays: 1, DetailDays: 90})
+    now := at(2026, 5, 20, 12)
+    
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        // Each pass leaves a plain file behind that the next one compresses
+        for i := 0; i < 200; i++ {
+            h.Append(&JobMetrics{JobID: fmt.Sprintf("job-%d", i), EndTime: at(2026, 5, 1+i%15, 9)})
+            h.Maintain(now)
+        }
+    }()
+    
+    var err error
+    for err == nil {
+        select {
+        case <-done:
+            return
+        default:
+        }
+        err = h.Jobs(func(*JobMetrics) {})
+    }
+    <-done
+    t.Fatalf("Jobs while maintaining: %v", err)
+}
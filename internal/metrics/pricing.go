@@ -0,0 +1,136 @@
+package metrics
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+)
+
+// DefaultEarningsRate is what a CPU second pays until the server publishes a rate card
+const DefaultEarningsRate = 0.001
+
+// OverrideRateCardVersion marks the built-in card with its CPU rate changed by
+// remote config, so jobs priced that way can be told from the default price
+const OverrideRateCardVersion int64 = -1
+
+// RateCard is the server's published price list and the one place usage
+// turns into money. Everything computed from it locally is an estimate;
+// the server's ledger is what's actually paid.
+//
+// A job is credited units of CPU time, scaled by its type's multiplier and
+// the multiplier for the UTC hour it started in, plus a bonus per CPU second
+// for jobs that peak above BonusAboveMB; memory is paid on peak memory held
+// over the job's wall time. Waiting earns nothing by itself.
+type RateCard struct {
+    Version      int64              `json:"version"` // 0 is the built-in card, OverrideRateCardVersion with a remote-config rate
+    Currency     string             `json:"currency"`
+    PerCPUSecond float64            `json:"per_cpu_second"`
+    PerGBHour    float64            `json:"per_gb_hour"`
+    BonusAboveMB int                `json:"bonus_above_mb,omitempty"` // Peak memory above which MemoryBonus applies
+    MemoryBonus  float64            `json:"memory_bonus,omitempty"`   // Extra units per CPU second for such jobs
+    JobTypes     map[string]float64 `json:"job_types,omitempty"`   // Multiplier per job type, 1 if absent
+    TimeOfDay    []HourWindow       `json:"time_of_day,omitempty"` // First match wins, 1 if none
+    Published    time.Time          `json:"published"`
+}
+
+// HourWindow applies Multiplier to jobs starting from FromHour up to ToHour UTC
+// A window whose ToHour is before its FromHour wraps past midnight
+type HourWindow struct {
+    FromHour   int     `json:"from_hour"`
+    ToHour     int     `json:"to_hour"`
+    Multiplier float64 `json:"multiplier"`
+}
+
+// DefaultRateCard is used until the server publishes one
+// Memory-hungry jobs earn 10% more per CPU second, as they always have
+func DefaultRateCard() RateCard {
+    return RateCard{Currency: "USD", PerCPUSecond: DefaultEarningsRate, BonusAboveMB: 1024, MemoryBonus: 0.1}
+}
+
+// ParseRateCard decodes and sanity-checks a rate card from the API
+func ParseRateCard(data []byte) (RateCard, error) {
+    var card RateCard
+    if err := json.Unmarshal(data, &card); err != nil {
+        return RateCard{}, fmt.Errorf("invalid rate card: %w", err)
+    }
+    if card.Version <= 0 {
+        return RateCard{}, fmt.Errorf("invalid rate card: version %d", card.Version)
+    }
+    if card.PerCPUSecond < 0 || card.PerGBHour < 0 || card.MemoryBonus < 0 {
+        return RateCard{}, fmt.Errorf("invalid rate card: negative price")
+    }
+    for jobType, multiplier := range card.JobTypes {
+        if multiplier < 0 {
+            return RateCard{}, fmt.Errorf("invalid rate card: negative multiplier for %s", jobType)
+        }
+    }
+    for _, window := range card.TimeOfDay {
+        if window.FromHour < 0 || window.FromHour > 23 || window.ToHour < 0 || window.ToHour > 24 || window.Multiplier < 0 {
+            return RateCard{}, fmt.Errorf("invalid rate card: bad time-of-day window %+v", window)
+        }
+    }
+    if card.Currency == "" {
+        card.Currency = "USD"
+    }
+    return card, nil
+}
+
+// JobUsage is what a job measurably consumed
+type JobUsage struct {
+    JobType      string
+    Started      time.Time
+    Elapsed      time.Duration // Wall time, awake
+    CPUSeconds   float64
+    PeakMemoryMB int
+}
+
+// Credit is what a job is estimated to be paid
+type Credit struct {
+    Units         float64 // CPU seconds after multipliers and any memory bonus
+    MemoryGBHours float64 // Peak memory over wall time, after multipliers
+    Earnings      float64
+    Version       int64 // Rate card used
+}
+
+// Price estimates what usage earns under this card
+func (c RateCard) Price(usage JobUsage) Credit {
+    multiplier := c.multiplier(usage.JobType, usage.Started)
+    
+    cpu := usage.CPUSeconds
+    if cpu < 0 {
+        cpu = 0
+    }
+    units := cpu * multiplier
+    if c.BonusAboveMB > 0 && usage.PeakMemoryMB > c.BonusAboveMB {
+        units += cpu * c.MemoryBonus * multiplier
+    }
+    gbHours := float64(usage.PeakMemoryMB) / 1024 * usage.Elapsed.Hours() * multiplier
+    
+    return Credit{
+        Units:         units,
+        MemoryGBHours: gbHours,
+        Earnings:      units*c.PerCPUSecond + gbHours*c.PerGBHour,
+        Version:       c.Version,
+    }
+}
+
+// multiplier combines the job type and time-of-day multipliers
+func (c RateCard) multiplier(jobType string, started time.Time) float64 {
+    multiplier := 1.0
+    if m, ok := c.JobTypes[jobType]; ok {
+        multiplier *= m
+    }
+    
+    hour := started.UTC().Hour()
+    for _, window := range c.TimeOfDay {
+        inside := hour >= window.FromHour && hour < window.ToHour
+        if window.ToHour < window.FromHour {
+            inside = hour >= window.FromHour || hour < window.ToHour
+        }
+        if inside {
+            multiplier *= window.Multiplier
+            break
+        }
+    }
+    return multiplier
+}
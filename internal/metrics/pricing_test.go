@@ -0,0 +1,47 @@
+package metrics
+
+import (
+    "testing"
+    "time"
+)
+
+func TestDefaultCardPaysMemoryBonus(t *testing.T) {
+    card := DefaultRateCard()
+    small := card.Price(JobUsage{CPUSeconds: 100, PeakMemoryMB: 512, Elapsed: time.Minute})
+    large := card.Price(JobUsage{CPUSeconds: 100, PeakMemoryMB: 2048, Elapsed: time.Minute})
+    
+    if small.Units != 100 {
+        t.Errorf("units at 512MB = %v, want 100", small.Units)
+    }
+    if large.Units != 110 {
+        t.Errorf("units at 2GB = %v, want 110 with the 10%% memory bonus", large.Units)
+    }
+    if large.Earnings <= small.Earnings {
+        t.Errorf("earnings at 2GB = %v, want more than the %v at 512MB", large.Earnings, small.Earnings)
+    }
+}
+
+func TestRemoteRateIsStampedOnJobs(t *testing.T) {
+    tracker := NewTracker()
+    tracker.SetEarningsRate(DefaultEarningsRate)
+    if v := tracker.RateCard().Version; v != 0 {
+        t.Errorf("default rate: version %d, want 0", v)
+    }
+    
+    tracker.SetEarningsRate(0.002)
+    if card := tracker.RateCard(); card.Version != OverrideRateCardVersion || card.PerCPUSecond != 0.002 {
+        t.Errorf("remote rate: version %d at %v, want %d at 0.002", card.Version, card.PerCPUSecond, OverrideRateCardVersion)
+    }
+    
+    tracker.SetEarningsRate(DefaultEarningsRate)
+    if v := tracker.RateCard().Version; v != 0 {
+        t.Errorf("back to the default rate: version %d, want 0", v)
+    }
+    
+    // A server card isn't touched
+    tracker.SetRateCard(RateCard{Version: 3, PerCPUSecond: 0.005})
+    tracker.SetEarningsRate(0.002)
+    if card := tracker.RateCard(); card.Version != 3 || card.PerCPUSecond != 0.005 {
+        t.Errorf("server card changed to v%d at %v", card.Version, card.PerCPUSecond)
+    }
+}
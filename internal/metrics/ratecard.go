@@ -0,0 +1,44 @@
+package metrics
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// rateCardFile caches the last rate card in the data directory so estimates
+// stay consistent across restarts and while offline
+const rateCardFile = "ratecard.json"
+
+// LoadRateCard returns the cached rate card in dataDir, or the built-in one
+func LoadRateCard(dataDir string) RateCard {
+    data, err := os.ReadFile(filepath.Join(dataDir, rateCardFile))
+    if err != nil {
+        return DefaultRateCard()
+    }
+    card, err := ParseRateCard(data)
+    if err != nil {
+        return DefaultRateCard()
+    }
+    return card
+}
+
+// SaveRateCard caches card in dataDir
+func SaveRateCard(dataDir string, card RateCard) error {
+    data, err := json.MarshalIndent(card, "", "  ")
+    if err != nil {
+        return err
+    }
+    
+    path := filepath.Join(dataDir, rateCardFile)
+    tempPath := path + ".tmp"
+    if err := os.WriteFile(tempPath, data, 0600); err != nil {
+        return fmt.Errorf("failed to cache rate card: %w", err)
+    }
+    if err := os.Rename(tempPath, path); err != nil {
+        os.Remove(tempPath)
+        return fmt.Errorf("failed to cache rate card: %w", err)
+    }
+    return nil
+}
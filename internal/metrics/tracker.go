@@ -1,10 +1,6 @@
 package metrics
 
 import (
-    "encoding/json"
-    "fmt"
-    "os"
-    "path/filepath"
     "sync"
     "time"
 )
@@ -14,9 +10,14 @@ type Tracker struct {
     sessionStart time.Time
     jobsCompleted int
     jobsFailed    int
+    totalWallTime time.Duration
     totalCPUTime  time.Duration
+    totalUnits    float64
     totalEarnings float64
     currentMetrics *SystemMetrics
+    running       map[string]time.Time
+    card          RateCard
+    history       *History
 }
 
 type SystemMetrics struct {
@@ -29,16 +30,35 @@ type SystemMetrics struct {
     Earnings     float64   `json:"earnings"`
 }
 
+// JobMetrics is one finished job
+// StartTime and EndTime are wall clock for the record; Elapsed is measured on
+// the monotonic clock without suspended time. Wall time, CPU time and credited
+// units are kept apart so a user can see how one led to the other. Earnings
+// is a local estimate from the rate card named by RateCardVersion.
 type JobMetrics struct {
-    JobID        string    `json:"job_id"`
-    DeviceID     string    `json:"device_id"`
-    StartTime    time.Time `json:"start_time"`
-    EndTime      time.Time `json:"end_time"`
-    CPUSeconds   float64   `json:"cpu_seconds"`
-    MemoryMB     int       `json:"memory_mb"`
-    Success      bool      `json:"success"`
-    ErrorMessage string    `json:"error_message,omitempty"`
-    Earnings     float64   `json:"earnings"`
+    JobID            string        `json:"job_id"`
+    JobType          string        `json:"job_type"`
+    DeviceID         string        `json:"device_id"`
+    StartTime        time.Time     `json:"start_time"`
+    EndTime          time.Time     `json:"end_time"`
+    Elapsed          time.Duration `json:"-"`
+    ElapsedSeconds   float64       `json:"elapsed_seconds"` // Wall time, awake
+    SuspendedSeconds float64       `json:"suspended_seconds,omitempty"`
+    CPUSeconds       float64       `json:"cpu_seconds"` // Measured CPU time, user plus system
+    UserCPUSeconds   float64       `json:"user_cpu_seconds"`
+    SystemCPUSeconds float64       `json:"system_cpu_seconds"`
+    MemoryMB         int           `json:"memory_mb"`   // Peak memory
+    ReadBytes        uint64        `json:"read_bytes,omitempty"`
+    WriteBytes       uint64        `json:"write_bytes,omitempty"`
+    NetRxBytes       uint64        `json:"net_rx_bytes,omitempty"`
+    NetTxBytes       uint64        `json:"net_tx_bytes,omitempty"`
+    UsageSource      string        `json:"usage_source,omitempty"` // How usage was counted: per thread or for the whole agent
+    Success          bool          `json:"success"`
+    ErrorMessage     string        `json:"error_message,omitempty"`
+    CreditedUnits    float64       `json:"credited_units"`
+    MemoryGBHours    float64       `json:"memory_gb_hours"`
+    RateCardVersion  int64         `json:"rate_card_version"` // 0 for the built-in card, -1 for it with a remote-config rate
+    Earnings         float64       `json:"earnings"`          // Estimate until the server confirms it
 }
 
 func NewTracker() *Tracker {
@@ -47,9 +67,41 @@ func NewTracker() *Tracker {
         currentMetrics: &SystemMetrics{
             Timestamp: time.Now(),
         },
+        running: make(map[string]time.Time),
+        card: DefaultRateCard(),
     }
 }
 
+// SetRateCard prices jobs completed from now on with card
+func (t *Tracker) SetRateCard(card RateCard) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.card = card
+}
+
+// SetEarningsRate changes the dollars paid per CPU second on the built-in
+// rate card; a card published by the server takes precedence
+// A rate other than the default is stamped OverrideRateCardVersion
+func (t *Tracker) SetEarningsRate(perCPUSecond float64) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if perCPUSecond <= 0 || t.card.Version > 0 {
+        return
+    }
+    t.card.PerCPUSecond = perCPUSecond
+    t.card.Version = 0
+    if perCPUSecond != DefaultEarningsRate {
+        t.card.Version = OverrideRateCardVersion
+    }
+}
+
+// RateCard returns the rate card applied to jobs completed from now on
+func (t *Tracker) RateCard() RateCard {
+    t.mu.RLock()
+    defer t.mu.RUnlock()
+    return t.card
+}
+
 func (t *Tracker) RecordJobStart(jobID string) {
     t.mu.Lock()
     defer t.mu.Unlock()
@@ -58,9 +110,19 @@ func (t *Tracker) RecordJobStart(jobID string) {
         t.currentMetrics.JobsRunning = 0
     }
     t.currentMetrics.JobsRunning++
+    t.running[jobID] = time.Now()
+}
+
+// SetHistory sets where finished jobs are written; without one they aren't kept
+func (t *Tracker) SetHistory(history *History) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.history = history
 }
 
-func (t *Tracker) RecordJobComplete(job *JobMetrics) {
+// RecordJobComplete counts and prices a finished job and writes it to the
+// history. The job is counted even if writing it fails.
+func (t *Tracker) RecordJobComplete(job *JobMetrics) error {
     t.mu.Lock()
     defer t.mu.Unlock()
     
@@ -70,23 +132,41 @@ func (t *Tracker) RecordJobComplete(job *JobMetrics) {
         t.jobsFailed++
     }
     
-    duration := job.EndTime.Sub(job.StartTime)
-    t.totalCPUTime += duration
+    // Not EndTime minus StartTime, which a clock step or a suspend would distort
+    job.ElapsedSeconds = job.Elapsed.Seconds()
+    t.totalWallTime += job.Elapsed
+    t.totalCPUTime += time.Duration(job.CPUSeconds * float64(time.Second))
     
-    // Calculate earnings: $0.001 per CPU second
-    earnings := duration.Seconds() * 0.001
-    job.Earnings = earnings
-    t.totalEarnings += earnings
+    // Priced on measured CPU time and peak memory
+    // Failed and cancelled jobs aren't paid, so they earn nothing here either
+    if job.Success {
+        credit := t.card.Price(JobUsage{
+            JobType:      job.JobType,
+            Started:      job.StartTime,
+            Elapsed:      job.Elapsed,
+            CPUSeconds:   job.CPUSeconds,
+            PeakMemoryMB: job.MemoryMB,
+        })
+        job.CreditedUnits = credit.Units
+        job.MemoryGBHours = credit.MemoryGBHours
+        job.RateCardVersion = credit.Version
+        job.Earnings = credit.Earnings
+        t.totalUnits += credit.Units
+        t.totalEarnings += credit.Earnings
+    }
     
     if t.currentMetrics.JobsRunning > 0 {
         t.currentMetrics.JobsRunning--
     }
+    delete(t.running, job.JobID)
     
     t.currentMetrics.TotalJobs = t.jobsCompleted + t.jobsFailed
     t.currentMetrics.Earnings = t.totalEarnings
     
-    // Save job metrics to file
-    t.saveJobMetrics(job)
+    if t.history == nil {
+        return nil
+    }
+    return t.history.Append(job)
 }
 
 func (t *Tracker) GetCurrentMetrics() *SystemMetrics {
@@ -101,42 +181,50 @@ func (t *Tracker) GetCurrentMetrics() *SystemMetrics {
     return &metrics
 }
 
-func (t *Tracker) GetStats() (completed, failed int, cpuTime time.Duration, earnings float64) {
+// RunningJobs returns how many jobs have started but not yet completed
+func (t *Tracker) RunningJobs() int {
     t.mu.RLock()
     defer t.mu.RUnlock()
     
-    return t.jobsCompleted, t.jobsFailed, t.totalCPUTime, t.totalEarnings
+    return t.currentMetrics.JobsRunning
 }
 
-func (t *Tracker) saveJobMetrics(job *JobMetrics) {
-    homeDir, _ := os.UserHomeDir()
-    metricsDir := filepath.Join(homeDir, ".idlenet", "metrics")
-    os.MkdirAll(metricsDir, 0755)
-    
-    // Save to daily file
-    filename := fmt.Sprintf("jobs_%s.json", time.Now().Format("2006-01-02"))
-    filepath := filepath.Join(metricsDir, filename)
+// RunningJobIDs returns the IDs of jobs currently in flight
+func (t *Tracker) RunningJobIDs() []string {
+    t.mu.RLock()
+    defer t.mu.RUnlock()
     
-    file, err := os.OpenFile(filepath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-    if err != nil {
-        return
+    ids := make([]string, 0, len(t.running))
+    for id := range t.running {
+        ids = append(ids, id)
     }
-    defer file.Close()
+    return ids
+}
+
+func (t *Tracker) GetStats() (completed, failed int, cpuTime time.Duration, earnings float64) {
+    t.mu.RLock()
+    defer t.mu.RUnlock()
     
-    data, _ := json.Marshal(job)
-    file.Write(data)
-    file.WriteString("\n")
+    return t.jobsCompleted, t.jobsFailed, t.totalCPUTime, t.totalEarnings
+}
+
+// Usage is the session's work, from what ran to what it was credited
+type Usage struct {
+    WallTime      time.Duration
+    CPUTime       time.Duration
+    CreditedUnits float64
+    Earnings      float64
 }
 
-func CalculateEarnings(cpuSeconds float64, memoryMB int) float64 {
-    // Base rate: $0.001 per CPU second
-    baseRate := cpuSeconds * 0.001
+// Usage returns the session totals kept separately for reconciliation
+func (t *Tracker) Usage() Usage {
+    t.mu.RLock()
+    defer t.mu.RUnlock()
     
-    // Memory bonus: +10% for high memory jobs
-    memoryBonus := 0.0
-    if memoryMB > 1024 {
-        memoryBonus = baseRate * 0.1
+    return Usage{
+        WallTime:      t.totalWallTime,
+        CPUTime:       t.totalCPUTime,
+        CreditedUnits: t.totalUnits,
+        Earnings:      t.totalEarnings,
     }
-    
-    return baseRate + memoryBonus
-}
\ No newline at end of file
+}
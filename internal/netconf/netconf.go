@@ -0,0 +1,232 @@
+package netconf
+
+import (
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+)
+
+// Options describes how the agent reaches the network
+// The zero value behaves like a plain http.Transport honoring HTTP(S)_PROXY
+type Options struct {
+    // Proxy is an http://, https:// or socks5:// URL, optionally with
+    // user:password for authenticating proxies. Empty means use the
+    // HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment, "direct" means no proxy.
+    Proxy   string
+    NoProxy string // Comma-separated hosts or .domain suffixes that skip Proxy
+    
+    CABundles  []string // Extra PEM files trusted alongside the system roots
+    ClientCert string   // PEM certificate presented for mTLS
+    ClientKey  string   // PEM key for ClientCert
+    
+    // PinHost only completes TLS handshakes whose verified chain contains one
+    // of Pins (base64 SHA-256 of a certificate's SubjectPublicKeyInfo)
+    PinHost string
+    Pins    []string
+}
+
+// NewTransport builds the http.Transport shared by the API client and the updater
+func NewTransport(opts Options) (*http.Transport, error) {
+    proxy, err := proxyFunc(opts.Proxy, opts.NoProxy)
+    if err != nil {
+        return nil, err
+    }
+    
+    tlsConfig, err := tlsConfig(opts)
+    if err != nil {
+        return nil, err
+    }
+    
+    // Only bound the connection phases here; callers bound whole requests
+    // with client timeouts or contexts since downloads can be long
+    return &http.Transport{
+        Proxy: proxy,
+        DialContext: (&net.Dialer{
+            Timeout:   30 * time.Second,
+            KeepAlive: 30 * time.Second,
+        }).DialContext,
+        TLSClientConfig:       tlsConfig,
+        TLSHandshakeTimeout:   15 * time.Second,
+        ResponseHeaderTimeout: 30 * time.Second,
+        IdleConnTimeout:       90 * time.Second,
+        MaxIdleConns:          10,
+        ForceAttemptHTTP2:     true,
+    }, nil
+}
+
+// Default is the transport used until the agent's network settings are applied
+func Default() *http.Transport {
+    transport, err := NewTransport(Options{})
+    if err != nil {
+        // The zero Options never fail; fall back rather than panic if that changes
+        return http.DefaultTransport.(*http.Transport).Clone()
+    }
+    return transport
+}
+
+// proxyFunc resolves the configured proxy; net/http speaks socks5 natively
+func proxyFunc(proxy, noProxy string) (func(*http.Request) (*url.URL, error), error) {
+    switch proxy {
+    case "":
+        return http.ProxyFromEnvironment, nil
+    case "direct":
+        return nil, nil
+    }
+    
+    proxyURL, err := url.Parse(proxy)
+    if err != nil {
+        return nil, fmt.Errorf("invalid proxy %q: %w", redact(proxy), err)
+    }
+    switch proxyURL.Scheme {
+    case "http", "https", "socks5", "socks5h":
+    default:
+        return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+    }
+    if proxyURL.Host == "" {
+        return nil, fmt.Errorf("invalid proxy %q: missing host", redact(proxy))
+    }
+    
+    bypass := splitList(noProxy)
+    return func(req *http.Request) (*url.URL, error) {
+        if skipProxy(req.URL.Hostname(), bypass) {
+            return nil, nil
+        }
+        return proxyURL, nil
+    }, nil
+}
+
+// skipProxy matches host against NO_PROXY style entries: "*", exact
+// hosts, and ".example.com" or "example.com" for a domain and its subdomains
+func skipProxy(host string, bypass []string) bool {
+    host = strings.ToLower(host)
+    if host == "localhost" || net.ParseIP(host).IsLoopback() {
+        return true
+    }
+    
+    for _, entry := range bypass {
+        entry = strings.ToLower(strings.TrimPrefix(entry, "."))
+        if entry == "*" || host == entry || strings.HasSuffix(host, "."+entry) {
+            return true
+        }
+    }
+    return false
+}
+
+func tlsConfig(opts Options) (*tls.Config, error) {
+    config := &tls.Config{MinVersion: tls.VersionTLS12}
+    
+    if len(opts.CABundles) > 0 {
+        // Keep the system roots so public hosts like GitHub still verify
+        pool, err := x509.SystemCertPool()
+        if err != nil || pool == nil {
+            pool = x509.NewCertPool()
+        }
+        for _, path := range opts.CABundles {
+            data, err := os.ReadFile(path)
+            if err != nil {
+                return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+            }
+            if !pool.AppendCertsFromPEM(data) {
+                return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+            }
+        }
+        config.RootCAs = pool
+    }
+    
+    if opts.ClientCert != "" || opts.ClientKey != "" {
+        if opts.ClientCert == "" || opts.ClientKey == "" {
+            return nil, errors.New("client certificate and key must be set together")
+        }
+        cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+        if err != nil {
+            return nil, fmt.Errorf("failed to load client certificate: %w", err)
+        }
+        config.Certificates = []tls.Certificate{cert}
+    }
+    
+    if len(opts.Pins) > 0 {
+        if opts.PinHost == "" {
+            return nil, errors.New("certificate pins need a host to apply to")
+        }
+        verify, err := pinVerifier(opts.PinHost, opts.Pins)
+        if err != nil {
+            return nil, err
+        }
+        config.VerifyConnection = verify
+    }
+    
+    return config, nil
+}
+
+// pinVerifier runs after normal chain verification, so a pin can never make
+// an otherwise untrusted certificate acceptable
+func pinVerifier(host string, pins []string) (func(tls.ConnectionState) error, error) {
+    allowed := make(map[string]bool, len(pins))
+    for _, pin := range pins {
+        pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+        raw, err := base64.StdEncoding.DecodeString(pin)
+        if err != nil || len(raw) != sha256.Size {
+            return nil, fmt.Errorf("invalid certificate pin %q: want base64 SHA-256", pin)
+        }
+        allowed[pin] = true
+    }
+    
+    return func(state tls.ConnectionState) error {
+        // Any certificate valid for the pinned host must carry a pinned key;
+        // ServerName alone isn't enough as it's empty when dialing an IP
+        if len(state.VerifiedChains) == 0 || state.VerifiedChains[0][0].VerifyHostname(host) != nil {
+            return nil
+        }
+        for _, chain := range state.VerifiedChains {
+            for _, cert := range chain {
+                if allowed[SPKIPin(cert)] {
+                    return nil
+                }
+            }
+        }
+        return fmt.Errorf("certificate for %s does not match any pinned key", host)
+    }, nil
+}
+
+// SPKIPin is the pin value for cert, as used in Options.Pins
+func SPKIPin(cert *x509.Certificate) string {
+    sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+    return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// HostOf returns the hostname of rawURL, for use as Options.PinHost
+func HostOf(rawURL string) string {
+    parsed, err := url.Parse(rawURL)
+    if err != nil {
+        return ""
+    }
+    return parsed.Hostname()
+}
+
+func splitList(list string) []string {
+    var entries []string
+    for _, entry := range strings.Split(list, ",") {
+        if entry = strings.TrimSpace(entry); entry != "" {
+            entries = append(entries, entry)
+        }
+    }
+    return entries
+}
+
+// redact hides proxy passwords in error messages
+func redact(proxy string) string {
+    parsed, err := url.Parse(proxy)
+    if err != nil {
+        return "(unparseable)"
+    }
+    return parsed.Redacted()
+}
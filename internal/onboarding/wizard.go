@@ -7,6 +7,7 @@ import (
     "strings"
     
     "github.com/ifruncillo/idlenet-agent/internal/config"
+    "github.com/ifruncillo/idlenet-agent/internal/logging"
 )
 
 // SetupWizard guides new users through initial configuration
@@ -20,7 +21,10 @@ func NewSetupWizard() *SetupWizard {
 }
 
 // Run executes the setup wizard
+// The prompts are for the person at the keyboard; what was chosen is logged
 func (w *SetupWizard) Run() (*config.Config, error) {
+    logger := logging.Subsystem("onboarding")
+    
     fmt.Println("========================================")
     fmt.Println("   Welcome to IdleNet Agent Setup!")
     fmt.Println("========================================")
@@ -80,13 +84,18 @@ func (w *SetupWizard) Run() (*config.Config, error) {
     
     // Save configuration
     if err := config.Save(cfg); err != nil {
+        logger.Error("Failed to save configuration", "error", err)
         return nil, err
     }
     
     // Set up autostart if requested
     if enableAutostart {
-        w.enableAutostart()
+        if err := w.enableAutostart(); err != nil {
+            logger.Warn("Failed to enable autostart", "error", err)
+        }
     }
+    logger.Info("Setup complete", "email", email, "resource_mode", resourceMode,
+        "referral", referral != "", "autostart", enableAutostart)
     
     fmt.Println()
     fmt.Println("========================================")
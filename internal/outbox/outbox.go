@@ -0,0 +1,557 @@
+package outbox
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "sort"
+    "sync"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/logging"
+)
+
+// Kinds of entries, in the order they're sacrificed when the outbox is full
+const (
+    KindTelemetry = "telemetry" // Performance samples and job metrics
+    KindResult    = "result"    // Job results, which is what volunteers are paid from
+)
+
+// DefaultMaxBytes bounds the payloads kept while offline
+const DefaultMaxBytes = 16 << 20
+
+// BatchPolicy decides when queued telemetry is worth an upload
+// Telemetry waits until any limit is reached; results never wait, and
+// take any telemetry queued ahead of them along
+type BatchPolicy struct {
+    MaxEntries int           // Upload once this many are queued, and never send more at once
+    MaxBytes   int64         // Upload once this many payload bytes are queued
+    MaxAge     time.Duration // Upload once the oldest has waited this long
+}
+
+// DefaultBatch uploads telemetry every 15 minutes or every 200 records
+var DefaultBatch = BatchPolicy{
+    MaxEntries: 200,
+    MaxBytes:   256 << 10,
+    MaxAge:     15 * time.Minute,
+}
+
+// Entry is a single record waiting to be delivered
+type Entry struct {
+    Seq     uint64          `json:"seq"`
+    Key     string          `json:"key"` // Idempotency key; also sent to the server
+    Kind    string          `json:"kind"`
+    Created time.Time       `json:"created"`
+    Payload json.RawMessage `json:"payload"`
+}
+
+// record is one line of the log: an added entry or an acknowledgement
+type record struct {
+    Op    string `json:"op"` // "add" | "ack"
+    Seq   uint64 `json:"seq"`
+    Entry *Entry `json:"entry,omitempty"`
+}
+
+// Outbox is a durable, ordered queue of records for the API
+// It is an append-only log of adds and acks, fsynced on every write and
+// compacted down to what's still pending on open and as acks pile up
+type Outbox struct {
+    path     string
+    maxBytes int64
+    
+    mu      sync.Mutex
+    batch   BatchPolicy
+    file    *os.File
+    pending []Entry              // In sequence order
+    keys    map[string]bool      // Keys of pending entries
+    held    map[uint64]time.Time // Sequence numbers set aside by Hold, and until when
+    size    int64                // Payload bytes pending
+    acked   int                  // Acks in the log since the last compaction
+    nextSeq uint64
+    kick    chan struct{}
+}
+
+// permanentError marks a delivery the server will never accept
+type permanentError struct {
+    err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps a delivery error so Flush drops the entry instead of retrying it
+func Permanent(err error) error {
+    return &permanentError{err: err}
+}
+
+// heldError marks a delivery worth retrying, but not for a while
+type heldError struct {
+    err  error
+    wait time.Duration
+}
+
+func (e *heldError) Error() string { return e.err.Error() }
+func (e *heldError) Unwrap() error { return e.err }
+
+// Hold wraps a delivery error so the entry is kept but set aside for at
+// least wait, letting the entries behind it go first. Holds aren't logged,
+// so a restart offers held entries once more straight away
+func Hold(err error, wait time.Duration) error {
+    return &heldError{err: err, wait: wait}
+}
+
+// Open loads the outbox in dir, replaying anything left from a previous run
+func Open(dir string, maxBytes int64) (*Outbox, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+    }
+    if maxBytes <= 0 {
+        maxBytes = DefaultMaxBytes
+    }
+    
+    o := &Outbox{
+        path:     filepath.Join(dir, "outbox.log"),
+        maxBytes: maxBytes,
+        batch:    DefaultBatch,
+        keys:     make(map[string]bool),
+        held:     make(map[uint64]time.Time),
+        nextSeq:  1,
+        kick:     make(chan struct{}, 1),
+    }
+    if err := o.replay(); err != nil {
+        return nil, err
+    }
+    
+    // Rewriting also drops a torn last line left by a crash mid-append
+    if err := o.compact(); err != nil {
+        return nil, err
+    }
+    return o, nil
+}
+
+// replay rebuilds the pending list from the log
+func (o *Outbox) replay() error {
+    file, err := os.Open(o.path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to open outbox: %w", err)
+    }
+    defer file.Close()
+    
+    entries := make(map[uint64]Entry)
+    scanner := bufio.NewScanner(file)
+    scanner.Buffer(make([]byte, 64<<10), 8<<20)
+    for scanner.Scan() {
+        var rec record
+        if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+            continue
+        }
+        switch rec.Op {
+        case "add":
+            if rec.Entry == nil {
+                continue
+            }
+            entries[rec.Seq] = *rec.Entry
+        case "ack":
+            delete(entries, rec.Seq)
+        }
+        if rec.Seq >= o.nextSeq {
+            o.nextSeq = rec.Seq + 1
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return fmt.Errorf("failed to read outbox: %w", err)
+    }
+    
+    for _, entry := range entries {
+        o.pending = append(o.pending, entry)
+        o.keys[entry.Key] = true
+        o.size += int64(len(entry.Payload))
+    }
+    sort.Slice(o.pending, func(i, j int) bool { return o.pending[i].Seq < o.pending[j].Seq })
+    return nil
+}
+
+// Add durably queues payload under key; a key that's already pending is ignored
+func (o *Outbox) Add(kind, key string, payload interface{}) error {
+    data, err := json.Marshal(payload)
+    if err != nil {
+        return fmt.Errorf("failed to encode %s: %w", kind, err)
+    }
+    
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    
+    if o.keys[key] {
+        return nil
+    }
+    
+    entry := Entry{Seq: o.nextSeq, Key: key, Kind: kind, Created: time.Now().UTC(), Payload: data}
+    if err := o.append(record{Op: "add", Seq: entry.Seq, Entry: &entry}); err != nil {
+        return err
+    }
+    o.nextSeq++
+    o.pending = append(o.pending, entry)
+    o.keys[key] = true
+    o.size += int64(len(data))
+    
+    o.evict()
+    o.notify()
+    return nil
+}
+
+// evict drops the oldest entries once over the size cap, telemetry before results
+func (o *Outbox) evict() {
+    for _, kind := range []string{KindTelemetry, KindResult} {
+        for i := 0; i < len(o.pending) && o.size > o.maxBytes; {
+            // Never evict the entry just added
+            if o.pending[i].Kind != kind || i == len(o.pending)-1 {
+                i++
+                continue
+            }
+            entry := o.pending[i]
+            if err := o.append(record{Op: "ack", Seq: entry.Seq}); err != nil {
+                return
+            }
+            o.remove(i)
+            logging.Subsystem("outbox").Warn("Outbox full, dropped entry",
+                "kind", entry.Kind, "key", entry.Key, "created", entry.Created)
+        }
+    }
+}
+
+// SetBatchPolicy changes when telemetry is uploaded
+func (o *Outbox) SetBatchPolicy(policy BatchPolicy) {
+    if policy.MaxEntries < 1 {
+        policy.MaxEntries = 1
+    }
+    
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    o.batch = policy
+}
+
+// Len returns how many entries are waiting
+func (o *Outbox) Len() int {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    return len(o.pending)
+}
+
+// Flush delivers everything pending in order, stopping at the first failure
+// so nothing overtakes an earlier record. Results go one at a time; runs of
+// telemetry go together in batches of up to BatchPolicy.MaxEntries. deliver
+// may wrap an error with Permanent to have the batch dropped instead, or with
+// Hold to have it set aside while the rest carries on.
+func (o *Outbox) Flush(ctx context.Context, deliver func(context.Context, []Entry) error) (int, error) {
+    logger := logging.Subsystem("outbox")
+    delivered := 0
+    for ctx.Err() == nil {
+        batch := o.next(time.Now())
+        if len(batch) == 0 {
+            break
+        }
+    
+        deliverErr := deliver(ctx, batch)
+        var permanent *permanentError
+        var held *heldError
+        if errors.As(deliverErr, &permanent) {
+            logger.Warn("Server rejected entries, dropping",
+                "count", len(batch), "kind", batch[0].Kind, "key", batch[0].Key, "error", deliverErr)
+        } else if errors.As(deliverErr, &held) && held.wait > 0 {
+            logger.Warn("Server turned away entries, holding them",
+                "count", len(batch), "kind", batch[0].Kind, "key", batch[0].Key, "retry_in", held.wait, "error", deliverErr)
+            o.hold(batch, time.Now().Add(held.wait))
+            continue
+        } else if deliverErr != nil {
+            return delivered, deliverErr
+        }
+    
+        if err := o.ack(batch); err != nil {
+            return delivered, err
+        }
+        if deliverErr == nil {
+            delivered += len(batch)
+        }
+    }
+    return delivered, ctx.Err()
+}
+
+// next returns the first batch in the queue that isn't held at now
+func (o *Outbox) next(now time.Time) []Entry {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    
+    var batch []Entry
+    var size int64
+    for _, entry := range o.pending {
+        if o.isHeld(entry, now) {
+            continue
+        }
+        if entry.Kind != KindTelemetry {
+            if len(batch) == 0 {
+                batch = append(batch, entry)
+            }
+            break
+        }
+    
+        // Always take at least one, however large
+        size += int64(len(entry.Payload))
+        if len(batch) > 0 && size > o.batch.MaxBytes {
+            break
+        }
+        batch = append(batch, entry)
+        if len(batch) >= o.batch.MaxEntries {
+            break
+        }
+    }
+    return batch
+}
+
+// hold sets batch aside until the given time
+func (o *Outbox) hold(batch []Entry, until time.Time) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    
+    for _, entry := range batch {
+        if o.keys[entry.Key] {
+            o.held[entry.Seq] = until
+        }
+    }
+}
+
+func (o *Outbox) isHeld(entry Entry, now time.Time) bool {
+    until, ok := o.held[entry.Seq]
+    return ok && now.Before(until)
+}
+
+// due reports whether anything should be uploaded now, and if not how long
+// until the oldest telemetry is old enough to go anyway or a held entry can
+// be offered again
+func (o *Outbox) due(now time.Time) (bool, time.Duration) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    
+    var count int
+    var size int64
+    var oldest, release time.Time
+    for _, entry := range o.pending {
+        if o.isHeld(entry, now) {
+            if until := o.held[entry.Seq]; release.IsZero() || until.Before(release) {
+                release = until
+            }
+            continue
+        }
+        if entry.Kind != KindTelemetry {
+            return true, 0
+        }
+        count++
+        size += int64(len(entry.Payload))
+        if oldest.IsZero() || entry.Created.Before(oldest) {
+            oldest = entry.Created
+        }
+    }
+    
+    var wait time.Duration
+    if count > 0 {
+        if count >= o.batch.MaxEntries || size >= o.batch.MaxBytes {
+            return true, 0
+        }
+        wait = o.batch.MaxAge - now.Sub(oldest)
+        if wait <= 0 {
+            return true, 0
+        }
+    }
+    if !release.IsZero() {
+        if untilRelease := release.Sub(now); wait == 0 || untilRelease < wait {
+            wait = untilRelease
+        }
+    }
+    return false, wait
+}
+
+// Run uploads whenever BatchPolicy says entries are due and retries on
+// interval until ctx is done. interval is called before each wait so callers
+// can back off while the API is down.
+func (o *Outbox) Run(ctx context.Context, deliver func(context.Context, []Entry) error, interval func() time.Duration) {
+    logger := logging.Subsystem("outbox")
+    failing := false
+    for {
+        wait := interval()
+        due, untilDue := o.due(time.Now())
+        if due {
+            delivered, err := o.Flush(ctx, deliver)
+            if err != nil && ctx.Err() == nil {
+                if !failing {
+                    logger.Warn("Upload failed, will retry", "waiting", o.Len(), "error", err)
+                }
+                failing = true
+            } else if failing && err == nil {
+                logger.Info("Back online", "delivered", delivered)
+                failing = false
+            }
+        } else if untilDue > 0 {
+            // Only telemetry or held entries waiting, so sleep until one is due
+            wait = untilDue
+        }
+    
+        timer := time.NewTimer(wait)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case <-o.kick:
+        case <-timer.C:
+        }
+        timer.Stop()
+    }
+}
+
+// Close releases the log file
+func (o *Outbox) Close() error {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if o.file == nil {
+        return nil
+    }
+    err := o.file.Close()
+    o.file = nil
+    return err
+}
+
+func (o *Outbox) ack(batch []Entry) error {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    
+    for _, done := range batch {
+        for i, entry := range o.pending {
+            if entry.Seq != done.Seq {
+                continue
+            }
+            if err := o.append(record{Op: "ack", Seq: entry.Seq}); err != nil {
+                return err
+            }
+            o.remove(i)
+            break
+        }
+    }
+    
+    if o.acked > 256 && o.acked > len(o.pending) {
+        return o.compact()
+    }
+    return nil
+}
+
+func (o *Outbox) remove(i int) {
+    entry := o.pending[i]
+    o.pending = append(o.pending[:i], o.pending[i+1:]...)
+    delete(o.keys, entry.Key)
+    o.size -= int64(len(entry.Payload))
+    delete(o.held, entry.Seq)
+}
+
+func (o *Outbox) notify() {
+    select {
+    case o.kick <- struct{}{}:
+    default:
+    }
+}
+
+// append writes one record and fsyncs before returning
+func (o *Outbox) append(rec record) error {
+    if o.file == nil {
+        file, err := os.OpenFile(o.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+        if err != nil {
+            return fmt.Errorf("failed to open outbox: %w", err)
+        }
+        o.file = file
+    }
+    
+    line, err := json.Marshal(rec)
+    if err != nil {
+        return err
+    }
+    if _, err := o.file.Write(append(line, '\n')); err != nil {
+        return fmt.Errorf("failed to write outbox: %w", err)
+    }
+    if err := o.file.Sync(); err != nil {
+        return fmt.Errorf("failed to sync outbox: %w", err)
+    }
+    
+    if rec.Op == "ack" {
+        o.acked++
+    }
+    return nil
+}
+
+// compact rewrites the log with only pending entries
+func (o *Outbox) compact() error {
+    tempPath := o.path + ".tmp"
+    file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to compact outbox: %w", err)
+    }
+    
+    writer := bufio.NewWriter(file)
+    for _, entry := range o.pending {
+        line, err := json.Marshal(record{Op: "add", Seq: entry.Seq, Entry: &entry})
+        if err != nil {
+            file.Close()
+            os.Remove(tempPath)
+            return err
+        }
+        writer.Write(append(line, '\n'))
+    }
+    // Flush returns any error from the writes above too
+    err = writer.Flush()
+    if err == nil {
+        err = file.Sync()
+    }
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(tempPath)
+        return fmt.Errorf("failed to compact outbox: %w", err)
+    }
+    
+    if o.file != nil {
+        o.file.Close()
+        o.file = nil
+    }
+    if err := os.Rename(tempPath, o.path); err != nil {
+        os.Remove(tempPath)
+        return fmt.Errorf("failed to compact outbox: %w", err)
+    }
+    o.acked = 0
+    
+    // The rename is only durable once the directory is
+    if err := syncDir(filepath.Dir(o.path)); err != nil {
+        return fmt.Errorf("failed to sync outbox directory: %w", err)
+    }
+    return nil
+}
+
+// syncDir fsyncs a directory so entries renamed into it survive a crash
+// Windows can't open directories for syncing and makes renames durable itself
+func syncDir(dir string) error {
+    if runtime.GOOS == "windows" {
+        return nil
+    }
+    d, err := os.Open(dir)
+    if err != nil {
+        return err
+    }
+    err = d.Sync()
+    if closeErr := d.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
@@ -0,0 +1,286 @@
+package outbox
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func openTest(t *testing.T, dir string, maxBytes int64) *Outbox {
+    t.Helper()
+    o, err := Open(dir, maxBytes)
+    if err != nil {
+        t.Fatalf("Open: %v", err)
+    }
+    t.Cleanup(func() { o.Close() })
+    return o
+}
+
+func logLines(t *testing.T, o *Outbox) []string {
+    t.Helper()
+    file, err := os.Open(o.path)
+    if err != nil {
+        t.Fatalf("open log: %v", err)
+    }
+    defer file.Close()
+    
+    var lines []string
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        lines = append(lines, scanner.Text())
+    }
+    return lines
+}
+
+func keys(batch []Entry) []string {
+    var out []string
+    for _, entry := range batch {
+        out = append(out, entry.Key)
+    }
+    return out
+}
+
+func TestReopenReplaysOnlyPending(t *testing.T) {
+    dir := t.TempDir()
+    o := openTest(t, dir, 0)
+    for _, key := range []string{"result:a", "result:b", "result:c"} {
+        if err := o.Add(KindResult, key, map[string]string{"id": key}); err != nil {
+            t.Fatalf("Add %s: %v", key, err)
+        }
+    }
+    if err := o.ack([]Entry{o.pending[1]}); err != nil {
+        t.Fatalf("ack: %v", err)
+    }
+    o.Close()
+    
+    reopened := openTest(t, dir, 0)
+    if got := keys(reopened.pending); strings.Join(got, ",") != "result:a,result:c" {
+        t.Fatalf("pending after reopen = %v, want [result:a result:c]", got)
+    }
+    if reopened.nextSeq != 4 {
+        t.Errorf("nextSeq = %d, want 4 so acked sequence numbers aren't reused", reopened.nextSeq)
+    }
+    if n := len(logLines(t, reopened)); n != 2 {
+        t.Errorf("log has %d lines after reopening, want 2 once compacted", n)
+    }
+}
+
+func TestAddIgnoresPendingKey(t *testing.T) {
+    o := openTest(t, t.TempDir(), 0)
+    o.Add(KindResult, "result:a", 1)
+    o.Add(KindResult, "result:a", 2)
+    if o.Len() != 1 {
+        t.Errorf("Len = %d, want 1", o.Len())
+    }
+}
+
+func TestOpenDropsTornLastLine(t *testing.T) {
+    dir := t.TempDir()
+    o := openTest(t, dir, 0)
+    o.Add(KindResult, "result:a", 1)
+    o.Close()
+    
+    // A crash partway through appending the next record
+    file, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0600)
+    if err != nil {
+        t.Fatal(err)
+    }
+    file.WriteString(`{"op":"add","seq":2,"entry":{"key":"res`)
+    file.Close()
+    
+    reopened := openTest(t, dir, 0)
+    if reopened.Len() != 1 {
+        t.Fatalf("Len = %d, want 1", reopened.Len())
+    }
+    for _, line := range logLines(t, reopened) {
+        if strings.Contains(line, `"seq":2`) {
+            t.Errorf("torn record survived compaction: %s", line)
+        }
+    }
+    
+    // And the log is still good to append to
+    if err := reopened.Add(KindResult, "result:b", 2); err != nil {
+        t.Fatalf("Add after recovery: %v", err)
+    }
+}
+
+func TestAcksCompactTheLog(t *testing.T) {
+    dir := t.TempDir()
+    o := openTest(t, dir, 0)
+    for i := 0; i < 300; i++ {
+        o.Add(KindResult, fmt.Sprintf("result:%d", i), i)
+    }
+    o.Add(KindResult, "result:last", "kept")
+    
+    delivered, err := o.Flush(context.Background(), func(_ context.Context, batch []Entry) error {
+        if batch[0].Key == "result:last" {
+            return errors.New("offline")
+        }
+        return nil
+    })
+    if delivered != 300 || err == nil {
+        t.Fatalf("Flush = %d, %v; want 300 and the offline error", delivered, err)
+    }
+    
+    lines := logLines(t, o)
+    if len(lines) > 300 {
+        t.Errorf("log has %d lines after 300 acks, want it compacted", len(lines))
+    }
+    if o.acked > 256 {
+        t.Errorf("acked = %d, want the count reset by compaction", o.acked)
+    }
+    if _, err := os.Stat(o.path + ".tmp"); !os.IsNotExist(err) {
+        t.Errorf("temporary compaction file left behind: %v", err)
+    }
+    
+    o.Close()
+    
+    reopened := openTest(t, dir, 0)
+    if got := keys(reopened.pending); len(got) != 1 || got[0] != "result:last" {
+        t.Errorf("pending after compaction = %v, want [result:last]", got)
+    }
+}
+
+func TestFlushBatchesTelemetryBehindResults(t *testing.T) {
+    o := openTest(t, t.TempDir(), 0)
+    o.SetBatchPolicy(BatchPolicy{MaxEntries: 2, MaxBytes: 1 << 20})
+    o.Add(KindTelemetry, "t1", 1)
+    o.Add(KindTelemetry, "t2", 2)
+    o.Add(KindTelemetry, "t3", 3)
+    o.Add(KindResult, "r1", 4)
+    o.Add(KindTelemetry, "t4", 5)
+    
+    var batches []string
+    delivered, err := o.Flush(context.Background(), func(_ context.Context, batch []Entry) error {
+        batches = append(batches, strings.Join(keys(batch), "+"))
+        return nil
+    })
+    if err != nil || delivered != 5 {
+        t.Fatalf("Flush = %d, %v; want 5, nil", delivered, err)
+    }
+    if got := strings.Join(batches, " "); got != "t1+t2 t3 r1 t4" {
+        t.Errorf("batches = %q, want %q", got, "t1+t2 t3 r1 t4")
+    }
+}
+
+func TestFlushStopsAtFailureAndDropsPermanent(t *testing.T) {
+    o := openTest(t, t.TempDir(), 0)
+    o.Add(KindResult, "bad", 1)
+    o.Add(KindResult, "stuck", 2)
+    o.Add(KindResult, "after", 3)
+    
+    var sent []string
+    delivered, err := o.Flush(context.Background(), func(_ context.Context, batch []Entry) error {
+        sent = append(sent, batch[0].Key)
+        switch batch[0].Key {
+        case "bad":
+            return Permanent(errors.New("malformed"))
+        case "stuck":
+            return errors.New("offline")
+        }
+        return nil
+    })
+    if err == nil || delivered != 0 {
+        t.Fatalf("Flush = %d, %v; want 0 and the offline error", delivered, err)
+    }
+    if got := strings.Join(sent, ","); got != "bad,stuck" {
+        t.Errorf("sent %s, want bad,stuck with nothing overtaking the failure", got)
+    }
+    if got := keys(o.pending); strings.Join(got, ",") != "stuck,after" {
+        t.Errorf("pending = %v, want [stuck after]", got)
+    }
+}
+
+func TestEvictsTelemetryBeforeResults(t *testing.T) {
+    o := openTest(t, t.TempDir(), 40)
+    o.Add(KindResult, "r1", "0123456789")
+    o.Add(KindTelemetry, "t1", "0123456789")
+    o.Add(KindTelemetry, "t2", "0123456789")
+    o.Add(KindResult, "r2", "0123456789")
+    
+    if got := strings.Join(keys(o.pending), ","); got != "r1,t2,r2" {
+        t.Fatalf("pending = %s, want the oldest telemetry dropped first", got)
+    }
+    
+    o.Add(KindResult, "r3", "0123456789")
+    o.Add(KindResult, "r4", "0123456789")
+    if got := strings.Join(keys(o.pending), ","); got != "r2,r3,r4" {
+        t.Errorf("pending = %s, want telemetry gone before the oldest results", got)
+    }
+}
+
+func TestFlushSetsHeldEntriesAside(t *testing.T) {
+    o := openTest(t, t.TempDir(), 0)
+    o.Add(KindResult, "disputed", 1)
+    o.Add(KindResult, "fine", 2)
+    o.Add(KindTelemetry, "t1", 3)
+    
+    var sent []string
+    deliver := func(_ context.Context, batch []Entry) error {
+        sent = append(sent, strings.Join(keys(batch), "+"))
+        if batch[0].Key == "disputed" {
+            return Hold(errors.New("422 Unprocessable Entity"), time.Hour)
+        }
+        return nil
+    }
+    delivered, err := o.Flush(context.Background(), deliver)
+    if err != nil || delivered != 2 {
+        t.Fatalf("Flush = %d, %v; want 2, nil", delivered, err)
+    }
+    if got := strings.Join(sent, " "); got != "disputed fine t1" {
+        t.Errorf("sent %s, want the held result followed by everything behind it", got)
+    }
+    if got := keys(o.pending); strings.Join(got, ",") != "disputed" {
+        t.Errorf("pending = %v, want only the held result", got)
+    }
+    
+    // Until the hold runs out it's skipped, and then it's offered again
+    if due, wait := o.due(time.Now()); due || wait <= 0 || wait > time.Hour {
+        t.Errorf("due = %v, %v; want to wait out the hour", due, wait)
+    }
+    if batch := o.next(time.Now().Add(2 * time.Hour)); len(batch) != 1 || batch[0].Key != "disputed" {
+        t.Errorf("next after the hold = %v, want the held result", keys(batch))
+    }
+}
+
+func TestHeldEntryWaitsOutNewArrivals(t *testing.T) {
+    o := openTest(t, t.TempDir(), 0)
+    o.Add(KindResult, "disputed", 1)
+    
+    var disputed, next atomic.Int32
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        o.Run(ctx, func(_ context.Context, batch []Entry) error {
+            if batch[0].Key == "disputed" {
+                disputed.Add(1)
+                return Hold(errors.New("422 Unprocessable Entity"), time.Hour)
+            }
+            next.Add(1)
+            return nil
+        }, func() time.Duration { return 10 * time.Millisecond })
+    }()
+    
+    time.Sleep(50 * time.Millisecond)
+    o.Add(KindResult, "next", 2)
+    time.Sleep(50 * time.Millisecond)
+    cancel()
+    <-done
+    
+    if n := disputed.Load(); n != 1 {
+        t.Errorf("held entry offered %d times, want 1", n)
+    }
+    if n := next.Load(); n != 1 {
+        t.Errorf("new result offered %d times, want 1", n)
+    }
+    if o.Len() != 1 {
+        t.Errorf("Len = %d, want only the held result kept", o.Len())
+    }
+}
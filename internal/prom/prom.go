@@ -0,0 +1,310 @@
+// Package prom exposes agent metrics in the Prometheus text format
+// It's a small subset of the client library: counters, gauges and
+// histograms with labels, and gauges read at scrape time
+package prom
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// ContentType is the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets suit request latencies in seconds
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds metric families in the order they were created
+type Registry struct {
+    mu       sync.Mutex
+    families []family
+    collect  []func()
+}
+
+type family interface {
+    write(w *bufio.Writer)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+    return &Registry{}
+}
+
+func (r *Registry) add(f family) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.families = append(r.families, f)
+}
+
+// OnScrape runs fn before every scrape, to bring gauges up to date
+// fn is called from the scraping goroutine so must be safe for that
+func (r *Registry) OnScrape(fn func()) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.collect = append(r.collect, fn)
+}
+
+// WriteText writes every metric in the text format
+func (r *Registry) WriteText(w io.Writer) error {
+    r.mu.Lock()
+    families := append([]family(nil), r.families...)
+    collect := append([]func(){}, r.collect...)
+    r.mu.Unlock()
+    
+    for _, fn := range collect {
+        fn()
+    }
+    
+    buf := bufio.NewWriter(w)
+    for _, f := range families {
+        f.write(buf)
+    }
+    return buf.Flush()
+}
+
+// Handler serves the registry for scraping
+func (r *Registry) Handler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        w.Header().Set("Content-Type", ContentType)
+        r.WriteText(w)
+    })
+}
+
+// desc is what every family has in common
+type desc struct {
+    name   string
+    help   string
+    kind   string // counter | gauge | histogram
+    labels []string
+}
+
+func (d *desc) header(w *bufio.Writer) {
+    fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+    fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// key joins label values into a map key, checking there are the right number
+func (d *desc) key(values []string) string {
+    if len(values) != len(d.labels) {
+        panic(fmt.Sprintf("prom: %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+    }
+    return strings.Join(values, "\xff")
+}
+
+// labelPairs renders {a="x",b="y"} for a key, with any extra pairs appended
+func (d *desc) labelPairs(key string, extra ...string) string {
+    var pairs []string
+    if len(d.labels) > 0 {
+        for i, value := range strings.Split(key, "\xff") {
+            pairs = append(pairs, d.labels[i]+`="`+escapeLabel(value)+`"`)
+        }
+    }
+    for i := 0; i+1 < len(extra); i += 2 {
+        pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+    }
+    if len(pairs) == 0 {
+        return ""
+    }
+    return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// values is a set of labelled float series
+type values struct {
+    desc
+    mu     sync.Mutex
+    series map[string]float64
+}
+
+func (v *values) write(w *bufio.Writer) {
+    v.mu.Lock()
+    defer v.mu.Unlock()
+    
+    v.header(w)
+    for _, key := range sortedKeys(v.series) {
+        fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(key), formatFloat(v.series[key]))
+    }
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+    values
+}
+
+// Counter creates a counter; name should end in _total
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+    c := &CounterVec{values{desc: desc{name, help, "counter", labels}, series: make(map[string]float64)}}
+    r.add(c)
+    return c
+}
+
+// Add increases the counter for labelValues by delta, which must not be negative
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+    if delta < 0 {
+        return
+    }
+    key := c.key(labelValues)
+    c.mu.Lock()
+    c.series[key] += delta
+    c.mu.Unlock()
+}
+
+// Inc adds one
+func (c *CounterVec) Inc(labelValues ...string) {
+    c.Add(1, labelValues...)
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+    values
+}
+
+// Gauge creates a gauge
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+    g := &GaugeVec{values{desc: desc{name, help, "gauge", labels}, series: make(map[string]float64)}}
+    r.add(g)
+    return g
+}
+
+// Set sets the gauge for labelValues
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+    key := g.key(labelValues)
+    g.mu.Lock()
+    g.series[key] = value
+    g.mu.Unlock()
+}
+
+// Reset forgets every series, e.g. before setting a one-hot state again
+func (g *GaugeVec) Reset() {
+    g.mu.Lock()
+    g.series = make(map[string]float64)
+    g.mu.Unlock()
+}
+
+// valueFunc is read when scraped
+type valueFunc struct {
+    desc
+    fn func() float64
+}
+
+// GaugeFunc creates an unlabelled gauge whose value fn returns at scrape time
+// fn is called from the scraping goroutine so must be safe for that
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+    r.add(&valueFunc{desc: desc{name: name, help: help, kind: "gauge"}, fn: fn})
+}
+
+// CounterFunc is GaugeFunc for a count kept elsewhere that only goes up
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+    r.add(&valueFunc{desc: desc{name: name, help: help, kind: "counter"}, fn: fn})
+}
+
+func (g *valueFunc) write(w *bufio.Writer) {
+    g.header(w)
+    fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+    desc
+    buckets []float64
+    mu      sync.Mutex
+    series  map[string]*histogram
+}
+
+type histogram struct {
+    counts []uint64 // Per bucket, not cumulative
+    count  uint64
+    sum    float64
+}
+
+// Histogram creates a histogram with the given upper bounds, in increasing order
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+    h := &HistogramVec{
+        desc:    desc{name, help, "histogram", labels},
+        buckets: append([]float64(nil), buckets...),
+        series:  make(map[string]*histogram),
+    }
+    sort.Float64s(h.buckets)
+    r.add(h)
+    return h
+}
+
+// Observe records one value for labelValues
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+    key := h.key(labelValues)
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    
+    s, ok := h.series[key]
+    if !ok {
+        s = &histogram{counts: make([]uint64, len(h.buckets))}
+        h.series[key] = s
+    }
+    if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+        s.counts[i]++
+    }
+    s.count++
+    s.sum += value
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    
+    h.header(w)
+    keys := make([]string, 0, len(h.series))
+    for key := range h.series {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    
+    for _, key := range keys {
+        s := h.series[key]
+        var cumulative uint64
+        for i, bound := range h.buckets {
+            cumulative += s.counts[i]
+            fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatFloat(bound)), cumulative)
+        }
+        fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), s.count)
+        fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(s.sum))
+        fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), s.count)
+    }
+}
+
+func sortedKeys(m map[string]float64) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func formatFloat(f float64) string {
+    switch {
+    case math.IsInf(f, 1):
+        return "+Inf"
+    case math.IsInf(f, -1):
+        return "-Inf"
+    case math.IsNaN(f):
+        return "NaN"
+    }
+    return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+    return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+    return helpEscaper.Replace(s)
+}
@@ -0,0 +1,82 @@
+package prom
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "net/http"
+    "time"
+)
+
+// DefaultPort is used when the listen address is just a host, or empty
+const DefaultPort = "9464"
+
+// ListenAddr normalises a configured address: a bare port listens on
+// loopback, and loopback reports whether the result stays on this machine
+func ListenAddr(configured string) (addr string, loopback bool, err error) {
+    host, port, err := net.SplitHostPort(configured)
+    if err != nil {
+        // A bare port, or a host without one
+        if isPort(configured) {
+            host, port = "", configured
+        } else {
+            host, port = configured, DefaultPort
+        }
+    }
+    if port == "" {
+        port = DefaultPort
+    }
+    if host == "" {
+        host = "127.0.0.1"
+    }
+    
+    addr = net.JoinHostPort(host, port)
+    if host == "localhost" {
+        return addr, true, nil
+    }
+    ip := net.ParseIP(host)
+    if ip == nil {
+        return "", false, fmt.Errorf("metrics listen address %q: host must be an IP address or localhost", configured)
+    }
+    return addr, ip.IsLoopback(), nil
+}
+
+func isPort(s string) bool {
+    if s == "" {
+        return false
+    }
+    for _, r := range s {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// Serve serves the registry at /metrics on addr until ctx is done
+func Serve(ctx context.Context, addr string, registry *Registry) error {
+    listener, err := net.Listen("tcp", addr)
+    if err != nil {
+        return fmt.Errorf("metrics endpoint: %w", err)
+    }
+    
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", registry.Handler())
+    server := &http.Server{
+        Handler:           mux,
+        ReadHeaderTimeout: 5 * time.Second,
+        WriteTimeout:      10 * time.Second,
+    }
+    
+    go func() {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+        defer cancel()
+        server.Shutdown(shutdownCtx)
+    }()
+    
+    if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+        return fmt.Errorf("metrics endpoint: %w", err)
+    }
+    return nil
+}
@@ -0,0 +1,234 @@
+package remoteconfig
+
+import (
+    "crypto/ed25519"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "sync"
+    "time"
+)
+
+// publicKey is the base64 ed25519 key remote config is signed with
+// Set at build time: -ldflags "-X github.com/ifruncillo/idlenet-agent/internal/remoteconfig.publicKey=..."
+// Builds without one never fetch remote config, so nothing at run time can
+// swap in a key of its own
+var publicKey = ""
+
+// Enabled reports whether this build can verify remote config, i.e. was
+// built with a key; callers don't fetch it otherwise
+func Enabled() bool {
+    return publicKey != ""
+}
+
+// DefaultRefresh is how often the server is asked for new config unless it says otherwise
+const DefaultRefresh = time.Hour
+
+// ErrStale means a config older than the one in use was offered, e.g. a replay
+var ErrStale = errors.New("remote config is older than the current one")
+
+// Document is the signed remote configuration
+type Document struct {
+    Version        int64     `json:"version"` // Must increase; older documents are rejected
+    DeviceID       string    `json:"device_id,omitempty"` // If set, only that device may use it
+    IssuedAt       time.Time `json:"issued_at"`
+    ExpiresAt      time.Time `json:"expires_at,omitempty"`
+    RefreshSeconds int       `json:"refresh_seconds,omitempty"`
+    Defaults       Values    `json:"defaults"`
+    Locked         []string  `json:"locked,omitempty"` // Field names the user's config can't override
+}
+
+// Envelope carries a document with its signature over the exact payload bytes
+type Envelope struct {
+    Payload   string `json:"payload"`   // base64 JSON Document
+    Signature string `json:"signature"` // base64 ed25519 signature of the decoded payload
+}
+
+// Manager holds the layers and the effective settings built from them
+type Manager struct {
+    deviceID  string
+    cachePath string
+    
+    mu       sync.Mutex
+    builtin  Values
+    user     Values
+    policy   *Values
+    remote   *Document
+}
+
+// NewManager loads the admin policy and the last remote config seen by this agent
+// A cached config is verified again, so a tampered cache is simply ignored
+func NewManager(dataDir, deviceID string, builtin, user Values) (*Manager, error) {
+    m := &Manager{
+        deviceID:  deviceID,
+        cachePath: filepath.Join(dataDir, "remote-config.json"),
+        builtin:   builtin,
+        user:      user,
+    }
+    
+    policy, err := loadPolicy()
+    if err != nil {
+        return nil, err
+    }
+    m.policy = policy
+    
+    if data, err := os.ReadFile(m.cachePath); err == nil {
+        if doc, err := m.verify(data); err == nil {
+            m.remote = doc
+        }
+    }
+    
+    return m, nil
+}
+
+// Apply verifies a remote config envelope and makes it current
+func (m *Manager) Apply(data []byte) error {
+    doc, err := m.verify(data)
+    if err != nil {
+        return err
+    }
+    
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    if m.remote != nil {
+        if doc.Version < m.remote.Version {
+            return ErrStale
+        }
+        if doc.Version == m.remote.Version {
+            return nil
+        }
+    }
+    
+    // Only take it once it's cached, so a failure leaves everything as it was
+    // and the same version can be applied again
+    tempPath := m.cachePath + ".tmp"
+    if err := os.WriteFile(tempPath, data, 0600); err != nil {
+        return fmt.Errorf("failed to cache remote config: %w", err)
+    }
+    if err := os.Rename(tempPath, m.cachePath); err != nil {
+        os.Remove(tempPath)
+        return fmt.Errorf("failed to cache remote config: %w", err)
+    }
+    
+    m.remote = doc
+    return nil
+}
+
+// SetUser replaces the user layer, e.g. after settings were changed
+func (m *Manager) SetUser(user Values) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.user = user
+}
+
+// Effective returns the merged settings
+func (m *Manager) Effective() Effective {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    
+    remote := m.remote
+    if remote != nil && !remote.ExpiresAt.IsZero() && time.Now().After(remote.ExpiresAt) {
+        remote = nil
+    }
+    return resolve(m.builtin, remote, m.user, m.policy)
+}
+
+// Version returns the version of the remote config in use, 0 if none
+func (m *Manager) Version() int64 {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.remote == nil {
+        return 0
+    }
+    return m.remote.Version
+}
+
+// RefreshInterval is how long to wait before asking the server again
+func (m *Manager) RefreshInterval() time.Duration {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.remote == nil || m.remote.RefreshSeconds < 60 {
+        return DefaultRefresh
+    }
+    return time.Duration(m.remote.RefreshSeconds) * time.Second
+}
+
+// verify checks the envelope's signature and that the document is meant for us
+func (m *Manager) verify(data []byte) (*Document, error) {
+    key, err := signingKey()
+    if err != nil {
+        return nil, err
+    }
+    
+    var envelope Envelope
+    if err := json.Unmarshal(data, &envelope); err != nil {
+        return nil, fmt.Errorf("invalid remote config: %w", err)
+    }
+    payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+    if err != nil {
+        return nil, fmt.Errorf("invalid remote config payload: %w", err)
+    }
+    signature, err := base64.StdEncoding.DecodeString(envelope.Signature)
+    if err != nil || !ed25519.Verify(key, payload, signature) {
+        return nil, errors.New("remote config signature is invalid")
+    }
+    
+    var doc Document
+    if err := json.Unmarshal(payload, &doc); err != nil {
+        return nil, fmt.Errorf("invalid remote config document: %w", err)
+    }
+    if doc.DeviceID != "" && doc.DeviceID != m.deviceID {
+        return nil, errors.New("remote config is for another device")
+    }
+    return &doc, nil
+}
+
+func signingKey() (ed25519.PublicKey, error) {
+    if publicKey == "" {
+        return nil, errors.New("this build has no remote config key")
+    }
+    
+    key, err := base64.StdEncoding.DecodeString(publicKey)
+    if err != nil || len(key) != ed25519.PublicKeySize {
+        return nil, errors.New("invalid remote config key")
+    }
+    return ed25519.PublicKey(key), nil
+}
+
+// PolicyPath is where administrators put a policy that overrides everything else
+// It lives in a system location so a normal user can't edit it
+func PolicyPath() string {
+    switch runtime.GOOS {
+    case "windows":
+        programData := os.Getenv("ProgramData")
+        if programData == "" {
+            programData = `C:\ProgramData`
+        }
+        return filepath.Join(programData, "IdleNet", "policy.json")
+    case "darwin":
+        return "/Library/Application Support/IdleNet/policy.json"
+    default:
+        return "/etc/idlenet/policy.json"
+    }
+}
+
+func loadPolicy() (*Values, error) {
+    data, err := os.ReadFile(PolicyPath())
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read admin policy: %w", err)
+    }
+    
+    var policy Values
+    if err := json.Unmarshal(data, &policy); err != nil {
+        return nil, fmt.Errorf("invalid admin policy %s: %w", PolicyPath(), err)
+    }
+    return &policy, nil
+}
@@ -0,0 +1,177 @@
+package remoteconfig
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// withKey compiles in a fresh signing key for the test and returns its private half
+func withKey(t *testing.T) ed25519.PrivateKey {
+    t.Helper()
+    public, private, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    saved := publicKey
+    publicKey = base64.StdEncoding.EncodeToString(public)
+    t.Cleanup(func() { publicKey = saved })
+    return private
+}
+
+func sign(t *testing.T, key ed25519.PrivateKey, doc Document) []byte {
+    t.Helper()
+    payload, err := json.Marshal(doc)
+    if err != nil {
+        t.Fatal(err)
+    }
+    envelope, err := json.Marshal(Envelope{
+        Payload:   base64.StdEncoding.EncodeToString(payload),
+        Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload)),
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return envelope
+}
+
+func newTestManager(t *testing.T, dir string) *Manager {
+    t.Helper()
+    m, err := NewManager(dir, "device-abc", Values{HeartbeatSeconds: 30, JobTypes: []string{"hash", "wasm"}}, Values{})
+    if err != nil {
+        t.Fatalf("NewManager: %v", err)
+    }
+    return m
+}
+
+func TestApplyVerifiesSignature(t *testing.T) {
+    key := withKey(t)
+    m := newTestManager(t, t.TempDir())
+    
+    if err := m.Apply(sign(t, key, Document{Version: 1, Defaults: Values{HeartbeatSeconds: 45}})); err != nil {
+        t.Fatalf("Apply signed: %v", err)
+    }
+    if got := m.Effective().HeartbeatSeconds; got != 45 {
+        t.Errorf("HeartbeatSeconds = %d, want the remote 45", got)
+    }
+    
+    // Same payload, signature from someone else
+    _, other, _ := ed25519.GenerateKey(rand.Reader)
+    if err := m.Apply(sign(t, other, Document{Version: 2, Defaults: Values{HeartbeatSeconds: 5}})); err == nil {
+        t.Error("Apply accepted a config signed with another key")
+    }
+    
+    // Payload changed after signing
+    var envelope Envelope
+    json.Unmarshal(sign(t, key, Document{Version: 3}), &envelope)
+    tampered, _ := json.Marshal(Document{Version: 3, Defaults: Values{HeartbeatSeconds: 5}})
+    envelope.Payload = base64.StdEncoding.EncodeToString(tampered)
+    data, _ := json.Marshal(envelope)
+    if err := m.Apply(data); err == nil {
+        t.Error("Apply accepted a tampered payload")
+    }
+    
+    if m.Version() != 1 {
+        t.Errorf("Version = %d, want 1 after the rejected configs", m.Version())
+    }
+}
+
+func TestApplyRejectsStaleAndForeignConfigs(t *testing.T) {
+    key := withKey(t)
+    m := newTestManager(t, t.TempDir())
+    
+    m.Apply(sign(t, key, Document{Version: 5}))
+    if err := m.Apply(sign(t, key, Document{Version: 4})); !errors.Is(err, ErrStale) {
+        t.Errorf("older config: err = %v, want ErrStale", err)
+    }
+    if err := m.Apply(sign(t, key, Document{Version: 6, DeviceID: "device-xyz"})); err == nil {
+        t.Error("Apply accepted a config for another device")
+    }
+    if m.Version() != 5 {
+        t.Errorf("Version = %d, want 5", m.Version())
+    }
+}
+
+func TestBuildWithoutKeyIgnoresEnvironment(t *testing.T) {
+    key := withKey(t)
+    signed := sign(t, key, Document{Version: 1})
+    t.Setenv("IDLENET_CONFIG_KEY", publicKey)
+    publicKey = ""
+    
+    if Enabled() {
+        t.Error("Enabled without a compiled-in key")
+    }
+    m := newTestManager(t, t.TempDir())
+    if err := m.Apply(signed); err == nil {
+        t.Error("Apply accepted a config in a build without a key")
+    }
+}
+
+func TestCachedConfigIsVerifiedAgain(t *testing.T) {
+    key := withKey(t)
+    dir := t.TempDir()
+    newTestManager(t, dir).Apply(sign(t, key, Document{Version: 7, Defaults: Values{JobPollSeconds: 90}}))
+    
+    if m := newTestManager(t, dir); m.Version() != 7 || m.Effective().JobPollSeconds != 90 {
+        t.Fatalf("restart: version %d, poll %d; want the cached v7", m.Version(), m.Effective().JobPollSeconds)
+    }
+    
+    // Rewriting the cache by hand doesn't get past the signature
+    path := filepath.Join(dir, "remote-config.json")
+    data, _ := os.ReadFile(path)
+    var envelope Envelope
+    json.Unmarshal(data, &envelope)
+    forged, _ := json.Marshal(Document{Version: 8, Defaults: Values{JobPollSeconds: 1}})
+    envelope.Payload = base64.StdEncoding.EncodeToString(forged)
+    data, _ = json.Marshal(envelope)
+    os.WriteFile(path, data, 0600)
+    
+    if m := newTestManager(t, dir); m.Version() != 0 {
+        t.Errorf("tampered cache loaded as v%d", m.Version())
+    }
+}
+
+func TestExpiredConfigFallsBack(t *testing.T) {
+    key := withKey(t)
+    m := newTestManager(t, t.TempDir())
+    m.Apply(sign(t, key, Document{
+        Version:   1,
+        ExpiresAt: time.Now().Add(-time.Minute),
+        Defaults:  Values{HeartbeatSeconds: 45},
+    }))
+    
+    eff := m.Effective()
+    if eff.HeartbeatSeconds != 30 || eff.Sources["heartbeat_seconds"] != SourceDefault {
+        t.Errorf("heartbeat %d from %s, want the built-in 30 once the remote config expired",
+            eff.HeartbeatSeconds, eff.Sources["heartbeat_seconds"])
+    }
+}
+
+func TestFailedCacheWriteLeavesConfigUnchanged(t *testing.T) {
+    key := withKey(t)
+    dir := t.TempDir()
+    m := newTestManager(t, dir)
+    m.Apply(sign(t, key, Document{Version: 1, Defaults: Values{HeartbeatSeconds: 45}}))
+    
+    // Somewhere the cache can't be written
+    m.cachePath = filepath.Join(dir, "missing", "remote-config.json")
+    next := sign(t, key, Document{Version: 2, Defaults: Values{HeartbeatSeconds: 60}})
+    if err := m.Apply(next); err == nil {
+        t.Fatal("Apply succeeded without caching the config")
+    }
+    if m.Version() != 1 || m.Effective().HeartbeatSeconds != 45 {
+        t.Errorf("after a failed write: v%d, heartbeat %d; want v1 and 45", m.Version(), m.Effective().HeartbeatSeconds)
+    }
+    
+    // Once the cache can be written, the same version goes through
+    m.cachePath = filepath.Join(dir, "remote-config.json")
+    if err := m.Apply(next); err != nil || m.Version() != 2 {
+        t.Errorf("retry: v%d, %v; want v2 applied", m.Version(), err)
+    }
+}
@@ -0,0 +1,171 @@
+package remoteconfig
+
+import (
+    "sort"
+)
+
+// Values are the settings the server, the user and an admin policy can set
+// A zero value means "not set here", so a lower layer's value stands
+type Values struct {
+    HeartbeatSeconds     int             `json:"heartbeat_seconds,omitempty"`
+    JobPollSeconds       int             `json:"job_poll_seconds,omitempty"`
+    MetricsSeconds       int             `json:"metrics_seconds,omitempty"`
+    EarningsPerCPUSecond float64         `json:"earnings_per_cpu_second,omitempty"`
+    JobTypes             []string        `json:"job_types,omitempty"`       // Job types this agent may run
+    MaxCPUPercent        int             `json:"max_cpu_percent,omitempty"` // Ceiling on the resource manager's CPU limit
+    MaxMemoryMB          int             `json:"max_memory_mb,omitempty"`   // Largest job memory request accepted
+    Features             map[string]bool `json:"features,omitempty"`
+}
+
+// Sources of an effective value, lowest precedence first
+const (
+    SourceDefault = "default" // Compiled into the agent
+    SourceRemote  = "remote"  // Server default
+    SourceUser    = "user"    // config.json
+    SourceLocked  = "locked"  // Server value the user can't override
+    SourcePolicy  = "policy"  // Local admin policy
+)
+
+// field describes one setting so layers can be merged generically
+type field struct {
+    name    string
+    set     func(v *Values) bool
+    copy    func(dst, src *Values)
+    ceiling bool // When locked, caps the user's value rather than replacing it
+}
+
+var fields = []field{
+    {name: "heartbeat_seconds",
+        set:  func(v *Values) bool { return v.HeartbeatSeconds > 0 },
+        copy: func(dst, src *Values) { dst.HeartbeatSeconds = src.HeartbeatSeconds }},
+    {name: "job_poll_seconds",
+        set:  func(v *Values) bool { return v.JobPollSeconds > 0 },
+        copy: func(dst, src *Values) { dst.JobPollSeconds = src.JobPollSeconds }},
+    {name: "metrics_seconds",
+        set:  func(v *Values) bool { return v.MetricsSeconds > 0 },
+        copy: func(dst, src *Values) { dst.MetricsSeconds = src.MetricsSeconds }},
+    {name: "earnings_per_cpu_second",
+        set:  func(v *Values) bool { return v.EarningsPerCPUSecond > 0 },
+        copy: func(dst, src *Values) { dst.EarningsPerCPUSecond = src.EarningsPerCPUSecond }},
+    {name: "job_types",
+        set:  func(v *Values) bool { return v.JobTypes != nil },
+        copy: func(dst, src *Values) { dst.JobTypes = append([]string(nil), src.JobTypes...) }},
+    {name: "max_cpu_percent", ceiling: true,
+        set:  func(v *Values) bool { return v.MaxCPUPercent > 0 },
+        copy: func(dst, src *Values) { dst.MaxCPUPercent = src.MaxCPUPercent }},
+    {name: "max_memory_mb", ceiling: true,
+        set:  func(v *Values) bool { return v.MaxMemoryMB > 0 },
+        copy: func(dst, src *Values) { dst.MaxMemoryMB = src.MaxMemoryMB }},
+}
+
+// Effective is the merged result, with where each value came from
+type Effective struct {
+    Values
+    Sources map[string]string
+}
+
+// Feature reports whether a feature flag is on
+func (e Effective) Feature(name string) bool {
+    return e.Features[name]
+}
+
+// AllowsJobType reports whether jobs of type t may run
+func (e Effective) AllowsJobType(t string) bool {
+    for _, allowed := range e.JobTypes {
+        if allowed == t {
+            return true
+        }
+    }
+    return false
+}
+
+// resolve merges the layers. From lowest to highest precedence:
+// compiled defaults, server defaults, the user's config, server values
+// marked locked, and finally the local admin policy, which locks everything it sets.
+func resolve(builtin Values, remote *Document, user Values, policy *Values) Effective {
+    effective := Effective{Sources: make(map[string]string)}
+    
+    var defaults *Values
+    locked := make(map[string]bool)
+    if remote != nil {
+        defaults = &remote.Defaults
+        for _, name := range remote.Locked {
+            locked[name] = true
+        }
+    }
+    
+    for _, f := range fields {
+        if f.set(&builtin) {
+            f.copy(&effective.Values, &builtin)
+            effective.Sources[f.name] = SourceDefault
+        }
+        if defaults != nil && f.set(defaults) {
+            f.copy(&effective.Values, defaults)
+            effective.Sources[f.name] = SourceRemote
+        }
+        
+        userSet := f.set(&user)
+        switch {
+        case locked[f.name] && defaults != nil && f.set(defaults):
+            // A locked ceiling still lets the user choose something lower
+            if f.ceiling && userSet && lower(f, &user, defaults) {
+                f.copy(&effective.Values, &user)
+                effective.Sources[f.name] = SourceUser
+            } else {
+                effective.Sources[f.name] = SourceLocked
+            }
+        case userSet:
+            f.copy(&effective.Values, &user)
+            effective.Sources[f.name] = SourceUser
+        }
+        
+        if policy != nil && f.set(policy) {
+            f.copy(&effective.Values, policy)
+            effective.Sources[f.name] = SourcePolicy
+        }
+    }
+    
+    // Feature flags aren't user settings, so only the server and policy change them
+    effective.Features = make(map[string]bool)
+    for _, layer := range []*Values{&builtin, defaults, policy} {
+        if layer == nil {
+            continue
+        }
+        for name, on := range layer.Features {
+            effective.Features[name] = on
+        }
+    }
+    
+    // Only job types this build can actually run
+    effective.JobTypes = intersect(effective.JobTypes, builtin.JobTypes)
+    
+    return effective
+}
+
+// lower reports whether a's value for a ceiling field is below b's
+func lower(f field, a, b *Values) bool {
+    switch f.name {
+    case "max_cpu_percent":
+        return a.MaxCPUPercent < b.MaxCPUPercent
+    case "max_memory_mb":
+        return a.MaxMemoryMB < b.MaxMemoryMB
+    }
+    return false
+}
+
+func intersect(list, allowed []string) []string {
+    keep := make(map[string]bool, len(allowed))
+    for _, t := range allowed {
+        keep[t] = true
+    }
+    
+    out := []string{}
+    for _, t := range list {
+        if keep[t] {
+            out = append(out, t)
+            delete(keep, t)
+        }
+    }
+    sort.Strings(out)
+    return out
+}
@@ -0,0 +1,88 @@
+package remoteconfig
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestResolvePrecedence(t *testing.T) {
+    builtin := Values{HeartbeatSeconds: 30, JobPollSeconds: 10, MetricsSeconds: 60, MaxCPUPercent: 80,
+        JobTypes: []string{"hash", "wasm"}}
+    remote := &Document{
+        Defaults: Values{HeartbeatSeconds: 45, JobPollSeconds: 20, MetricsSeconds: 120, MaxCPUPercent: 50},
+        Locked:   []string{"job_poll_seconds", "max_cpu_percent"},
+    }
+    user := Values{HeartbeatSeconds: 15, JobPollSeconds: 5, MaxCPUPercent: 90}
+    policy := &Values{MetricsSeconds: 300}
+    
+    eff := resolve(builtin, remote, user, policy)
+    
+    tests := []struct {
+        name   string
+        got    int
+        want   int
+        source string
+    }{
+        {"heartbeat_seconds", eff.HeartbeatSeconds, 15, SourceUser}, // User beats a server default
+        {"job_poll_seconds", eff.JobPollSeconds, 20, SourceLocked},  // But not a locked value
+        {"max_cpu_percent", eff.MaxCPUPercent, 50, SourceLocked},    // A locked ceiling can't be raised
+        {"metrics_seconds", eff.MetricsSeconds, 300, SourcePolicy},  // Policy beats everything
+        {"max_memory_mb", eff.MaxMemoryMB, 0, ""},                   // Nobody set it
+    }
+    for _, test := range tests {
+        if test.got != test.want || eff.Sources[test.name] != test.source {
+            t.Errorf("%s = %d from %q, want %d from %q",
+                test.name, test.got, eff.Sources[test.name], test.want, test.source)
+        }
+    }
+}
+
+func TestLockedCeilingAllowsLowerUserValue(t *testing.T) {
+    remote := &Document{Defaults: Values{MaxCPUPercent: 50}, Locked: []string{"max_cpu_percent"}}
+    
+    eff := resolve(Values{MaxCPUPercent: 80}, remote, Values{MaxCPUPercent: 25}, nil)
+    if eff.MaxCPUPercent != 25 || eff.Sources["max_cpu_percent"] != SourceUser {
+        t.Errorf("max_cpu_percent = %d from %s, want the user's lower 25",
+            eff.MaxCPUPercent, eff.Sources["max_cpu_percent"])
+    }
+}
+
+func TestLockWithoutRemoteValueLeavesUserValue(t *testing.T) {
+    remote := &Document{Locked: []string{"heartbeat_seconds"}}
+    
+    eff := resolve(Values{HeartbeatSeconds: 30}, remote, Values{HeartbeatSeconds: 15}, nil)
+    if eff.HeartbeatSeconds != 15 || eff.Sources["heartbeat_seconds"] != SourceUser {
+        t.Errorf("heartbeat_seconds = %d from %s, want the user's 15",
+            eff.HeartbeatSeconds, eff.Sources["heartbeat_seconds"])
+    }
+}
+
+func TestJobTypesLimitedToBuild(t *testing.T) {
+    remote := &Document{Defaults: Values{JobTypes: []string{"wasm", "gpu", "hash"}}}
+    
+    eff := resolve(Values{JobTypes: []string{"hash", "wasm"}}, remote, Values{}, nil)
+    if want := []string{"hash", "wasm"}; !reflect.DeepEqual(eff.JobTypes, want) {
+        t.Errorf("JobTypes = %v, want %v", eff.JobTypes, want)
+    }
+    if eff.AllowsJobType("gpu") {
+        t.Error("a job type this build can't run was allowed")
+    }
+    
+    // An empty list from the user turns every type off
+    eff = resolve(Values{JobTypes: []string{"hash"}}, remote, Values{JobTypes: []string{}}, nil)
+    if len(eff.JobTypes) != 0 {
+        t.Errorf("JobTypes = %v, want none", eff.JobTypes)
+    }
+}
+
+func TestFeaturesIgnoreUserLayer(t *testing.T) {
+    builtin := Values{Features: map[string]bool{"push": true, "delta": false}}
+    remote := &Document{Defaults: Values{Features: map[string]bool{"delta": true}}}
+    user := Values{Features: map[string]bool{"push": false, "beta": true}}
+    policy := &Values{Features: map[string]bool{"push": false}}
+    
+    eff := resolve(builtin, remote, user, policy)
+    if want := map[string]bool{"push": false, "delta": true}; !reflect.DeepEqual(eff.Features, want) {
+        t.Errorf("Features = %v, want %v", eff.Features, want)
+    }
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package resource
+
+import (
+    "syscall"
+)
+
+// FreeDiskBytes returns the space available to this user on the volume holding path
+func FreeDiskBytes(path string) (uint64, error) {
+    var stat syscall.Statfs_t
+    if err := syscall.Statfs(path, &stat); err != nil {
+        return 0, err
+    }
+    return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,34 @@
+//go:build windows
+
+package resource
+
+import (
+    "syscall"
+    "unsafe"
+)
+
+var (
+    kernel32                = syscall.NewLazyDLL("kernel32.dll")
+    procGetDiskFreeSpaceExW = kernel32.NewProc("GetDiskFreeSpaceExW")
+)
+
+// FreeDiskBytes returns the space available to this user on the volume holding path
+func FreeDiskBytes(path string) (uint64, error) {
+    pathPtr, err := syscall.UTF16PtrFromString(path)
+    if err != nil {
+        return 0, err
+    }
+    
+    var freeToCaller uint64
+    ret, _, callErr := procGetDiskFreeSpaceExW.Call(
+        uintptr(unsafe.Pointer(pathPtr)),
+        uintptr(unsafe.Pointer(&freeToCaller)),
+        0,
+        0,
+    )
+    if ret == 0 {
+        return 0, callErr
+    }
+    
+    return freeToCaller, nil
+}
@@ -13,6 +13,7 @@ type Manager struct {
     lastCheck        time.Time
     currentCPULimit  int
     currentMemLimit  int
+    cpuCeiling       int // Upper bound on the CPU limit, 0 for none
 }
 
 // NewManager creates a resource manager with user preferences
@@ -108,6 +109,9 @@ func (m *Manager) GetLimits() (cpuPercent, memPercent int) {
         maxCPU = 60
         maxMem = 40
     }
+    if m.cpuCeiling > 0 && m.cpuCeiling < maxCPU {
+        maxCPU = m.cpuCeiling
+    }
     
     if m.currentCPULimit > maxCPU {
         m.currentCPULimit = maxCPU
@@ -119,6 +123,12 @@ func (m *Manager) GetLimits() (cpuPercent, memPercent int) {
     return m.currentCPULimit, m.currentMemLimit
 }
 
+// SetCPUCeiling caps the CPU limit whatever the mode and activity allow
+func (m *Manager) SetCPUCeiling(percent int) {
+    m.cpuCeiling = percent
+    m.lastCheck = time.Time{}
+}
+
 // ShouldRunJob determines if we should accept new jobs
 func (m *Manager) ShouldRunJob() bool {
     cpu, _ := m.GetLimits()
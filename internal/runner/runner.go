@@ -13,6 +13,12 @@ type Result struct {
 	Status   string        // "ok" | "error" | "skipped"
 	Duration time.Duration
 	Error    string
+	Usage    Usage         // What the OS counted against the job
+}
+
+// SupportedTypes lists the job types RunJob knows how to execute
+func SupportedTypes() []string {
+	return []string{"sleep", "hash"}
 }
 
 func RunJob(ctx context.Context, jobType string, argsRaw json.RawMessage, timeoutSec int) Result {
@@ -22,6 +28,7 @@ func RunJob(ctx context.Context, jobType string, argsRaw json.RawMessage, timeou
 	defer cancel()
 
 	start := time.Now()
+	stopMeter := meterJob()
 	res := Result{Status: "ok"}
 
 	switch jobType {
@@ -44,11 +51,12 @@ func RunJob(ctx context.Context, jobType string, argsRaw json.RawMessage, timeou
 		if a.Seconds <= 0 { a.Seconds = 10 }
 		buf := make([]byte, 1<<16)
 		for i := range buf { buf[i] = byte(i) }
+	hashing:
 		for time.Since(start) < time.Duration(a.Seconds)*time.Second {
 			select {
 			case <-ctx.Done():
 				res.Status = "error"; res.Error = "timeout/cancelled"
-				break
+				break hashing
 			default:
 				h := sha256.Sum256(buf)
 				_ = hex.EncodeToString(h[:])
@@ -61,6 +69,7 @@ func RunJob(ctx context.Context, jobType string, argsRaw json.RawMessage, timeou
 	}
 
 	res.Duration = time.Since(start)
+	res.Usage = stopMeter()
 	return res
 }
 
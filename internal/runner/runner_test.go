@@ -0,0 +1,39 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCancelStopsJobs(t *testing.T) {
+	for _, jobType := range SupportedTypes() {
+		t.Run(jobType, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+
+			res := RunJob(ctx, jobType, json.RawMessage(`{"seconds":30}`), 60)
+			if res.Status != "error" {
+				t.Errorf("Status = %q, want error for a cancelled job", res.Status)
+			}
+			if res.Duration > 2*time.Second {
+				t.Errorf("cancelled job ran for %v", res.Duration)
+			}
+		})
+	}
+}
+
+func TestTimeoutStopsHashJob(t *testing.T) {
+	res := RunJob(context.Background(), "hash", json.RawMessage(`{"seconds":30}`), 1)
+	if res.Status != "error" || res.Duration > 3*time.Second {
+		t.Errorf("Status = %q after %v, want error soon after the 1s timeout", res.Status, res.Duration)
+	}
+}
+
+func TestHashJobCompletes(t *testing.T) {
+	res := RunJob(context.Background(), "hash", json.RawMessage(`{"seconds":1}`), 10)
+	if res.Status != "ok" {
+		t.Errorf("Status = %q (%s), want ok", res.Status, res.Error)
+	}
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"runtime"
+	"time"
+)
+
+// Usage is what a job cost the machine, as the OS counted it
+type Usage struct {
+	UserCPU    time.Duration
+	SystemCPU  time.Duration
+	PeakRSS    uint64 // Bytes
+	ReadBytes  uint64 // Storage actually read and written, not cache hits
+	WriteBytes uint64
+	NetRxBytes uint64 // Only counted for jobs in their own network namespace; built-in types don't touch the network
+	NetTxBytes uint64
+	Source     string // "thread" when every figure is the job's own, "process" when any is the whole agent's
+}
+
+// CPU is user plus system time
+func (u Usage) CPU() time.Duration {
+	return u.UserCPU + u.SystemCPU
+}
+
+// PeakMemoryMB is PeakRSS rounded up to whole megabytes
+func (u Usage) PeakMemoryMB() int {
+	return int((u.PeakRSS + 1<<20 - 1) >> 20)
+}
+
+// meter counts one job's usage from when it's started until stop
+// Built-in job types run on the caller's goroutine, so meterJob pins that
+// goroutine to its OS thread and each platform counts the thread where it can
+//
+// Job types that run elsewhere bring their own meter: a child process is
+// measured by its rusage and cgroup, a WASM module by the fuel it burns
+type meter interface {
+	stop() Usage
+}
+
+// meterJob starts measuring the calling goroutine; the returned stop must be
+// called from the same goroutine
+func meterJob() (stop func() Usage) {
+	runtime.LockOSThread()
+	m := startMeter()
+	return func() Usage {
+		usage := m.stop()
+		runtime.UnlockOSThread()
+		return usage
+	}
+}
+
+// delta is end-start for counters, which shouldn't go backwards but might wrap
+func delta(end, start uint64) uint64 {
+	if end < start {
+		return 0
+	}
+	return end - start
+}
@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// processMeter counts a job by the agent's rusage, since macOS has no
+// per-thread rusage without cgo
+// The agent does little else while a job runs, so the difference is close
+type processMeter struct {
+	start unix.Rusage
+}
+
+func startMeter() meter {
+	m := &processMeter{}
+	unix.Getrusage(unix.RUSAGE_SELF, &m.start)
+	return m
+}
+
+func (m *processMeter) stop() Usage {
+	var end unix.Rusage
+	unix.Getrusage(unix.RUSAGE_SELF, &end)
+	return Usage{
+		UserCPU:   time.Duration(end.Utime.Nano() - m.start.Utime.Nano()),
+		SystemCPU: time.Duration(end.Stime.Nano() - m.start.Stime.Nano()),
+		PeakRSS:   uint64(end.Maxrss), // Bytes on macOS, and the agent's lifetime peak
+		Source:    "process",
+	}
+}
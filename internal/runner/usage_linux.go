@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// threadMeter counts a job's CPU and I/O by its thread's rusage and /proc io
+// Peak RSS is only kept per process: it's reset at the start so VmHWM is the
+// peak while the job ran, which is the job's since jobs run one at a time
+type threadMeter struct {
+	tid   int
+	start unix.Rusage
+	io    map[string]uint64
+	hwm   bool // Whether the peak was reset, so VmHWM belongs to the job
+}
+
+func startMeter() meter {
+	m := &threadMeter{tid: unix.Gettid()}
+	unix.Getrusage(unix.RUSAGE_THREAD, &m.start)
+	m.io = m.readIO()
+	// Writing 5 to clear_refs resets the peak RSS the kernel keeps (Linux 4.0+)
+	// It's the whole agent's peak, so this is only safe with one job at a time
+	m.hwm = os.WriteFile("/proc/self/clear_refs", []byte("5"), 0) == nil
+	return m
+}
+
+func (m *threadMeter) stop() Usage {
+	var end unix.Rusage
+	unix.Getrusage(unix.RUSAGE_THREAD, &end)
+	io := m.readIO()
+
+	usage := Usage{
+		UserCPU:    time.Duration(end.Utime.Nano() - m.start.Utime.Nano()),
+		SystemCPU:  time.Duration(end.Stime.Nano() - m.start.Stime.Nano()),
+		ReadBytes:  delta(io["read_bytes"], m.io["read_bytes"]),
+		WriteBytes: delta(io["write_bytes"], m.io["write_bytes"]),
+		// CPU and I/O are the thread's, but memory is the whole agent's,
+		// so the figures as a set are only good to process level
+		Source: "process",
+	}
+
+	status := readStatus()
+	if m.hwm {
+		usage.PeakRSS = status["VmHWM"]
+	}
+	if usage.PeakRSS == 0 {
+		// No reset, so the best we have is the agent's lifetime peak
+		var self unix.Rusage
+		unix.Getrusage(unix.RUSAGE_SELF, &self)
+		usage.PeakRSS = uint64(self.Maxrss) << 10
+	}
+	return usage
+}
+
+// readIO reads this thread's I/O counters, e.g. read_bytes: 4096
+// The file is only readable with ptrace access to ourselves, which can be
+// denied by hardening; the byte counts are then left at zero
+func (m *threadMeter) readIO() map[string]uint64 {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/self/task/%d/io", m.tid))
+	if err != nil {
+		return nil
+	}
+	return parseCounters(data, 1)
+}
+
+// readStatus reads the kB sizes in /proc/self/status, as bytes
+func readStatus() map[string]uint64 {
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return nil
+	}
+	return parseCounters(data, 1024)
+}
+
+// parseCounters reads "name: value [unit]" lines, scaling values by scale
+func parseCounters(data []byte, scale uint64) map[string]uint64 {
+	counters := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		name, rest, ok := bytes.Cut(scanner.Bytes(), []byte(":"))
+		if !ok {
+			continue
+		}
+		fields := bytes.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseUint(string(fields[0]), 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[string(name)] = value * scale
+	}
+	return counters
+}
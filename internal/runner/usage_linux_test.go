@@ -0,0 +1,28 @@
+package runner
+
+import "testing"
+
+func TestParseCounters(t *testing.T) {
+	counters := parseCounters([]byte("Name:\tidlenet\nVmHWM:\t  2048 kB\nbogus line\nThreads:\tmany\n"), 1024)
+	if counters["VmHWM"] != 2048*1024 {
+		t.Errorf("VmHWM = %d, want %d", counters["VmHWM"], 2048*1024)
+	}
+	for _, name := range []string{"Name", "Threads", "bogus line"} {
+		if _, ok := counters[name]; ok {
+			t.Errorf("%s parsed from a non-numeric line", name)
+		}
+	}
+}
+
+func TestMeterSeesJobPeak(t *testing.T) {
+	stop := meterJob()
+	buf := make([]byte, 64<<20)
+	for i := 0; i < len(buf); i += 4096 {
+		buf[i] = 1
+	}
+	usage := stop()
+
+	if usage.PeakRSS < uint64(len(buf)) {
+		t.Errorf("PeakRSS = %d, want at least the %d bytes touched", usage.PeakRSS, len(buf))
+	}
+}
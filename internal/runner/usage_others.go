@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+
+package runner
+
+// noMeter is for platforms we don't know how to measure; jobs report no usage
+type noMeter struct{}
+
+func startMeter() meter {
+	return noMeter{}
+}
+
+func (noMeter) stop() Usage {
+	return Usage{}
+}
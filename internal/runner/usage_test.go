@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeakMemoryMBRoundsUp(t *testing.T) {
+	tests := []struct {
+		rss  uint64
+		want int
+	}{
+		{0, 0},
+		{1, 1},
+		{1 << 20, 1},
+		{1<<20 + 1, 2},
+	}
+	for _, test := range tests {
+		if got := (Usage{PeakRSS: test.rss}).PeakMemoryMB(); got != test.want {
+			t.Errorf("PeakMemoryMB(%d) = %d, want %d", test.rss, got, test.want)
+		}
+	}
+}
+
+func TestDeltaNeverGoesNegative(t *testing.T) {
+	if got := delta(10, 4); got != 6 {
+		t.Errorf("delta(10, 4) = %d, want 6", got)
+	}
+	if got := delta(4, 10); got != 0 {
+		t.Errorf("delta(4, 10) = %d, want 0 for a counter that went backwards", got)
+	}
+}
+
+func TestMeterCountsBusyNotIdleTime(t *testing.T) {
+	stop := meterJob()
+	for start := time.Now(); time.Since(start) < 300*time.Millisecond; {
+	}
+	busy := stop()
+
+	stop = meterJob()
+	time.Sleep(300 * time.Millisecond)
+	idle := stop()
+
+	if busy.CPU() < 150*time.Millisecond {
+		t.Errorf("busy CPU = %v, want most of the 300ms spun", busy.CPU())
+	}
+	if idle.CPU() > 100*time.Millisecond {
+		t.Errorf("idle CPU = %v, want next to nothing for a sleep", idle.CPU())
+	}
+	if busy.Source != "process" {
+		t.Errorf("Source = %q, want process since peak memory is the agent's", busy.Source)
+	}
+}
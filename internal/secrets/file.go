@@ -0,0 +1,199 @@
+package secrets
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/json"
+    "fmt"
+    "os"
+    "os/user"
+    "sync"
+)
+
+// fileFormatVersion is bumped if the on-disk layout or key derivation changes
+const fileFormatVersion = 1
+
+// fileStore keeps secrets in a single AES-256-GCM encrypted file readable only
+// by the current user. The key is derived from machine-specific material, so
+// a copied file is useless elsewhere; it does not protect against someone who
+// can already run code as this user on this machine.
+type fileStore struct {
+    path string
+    mu   sync.Mutex
+}
+
+type encryptedFile struct {
+    Version    int    `json:"version"`
+    Salt       []byte `json:"salt"`
+    Nonce      []byte `json:"nonce"`
+    Ciphertext []byte `json:"ciphertext"`
+}
+
+func openFileStore(path string) (*fileStore, error) {
+    store := &fileStore{path: path}
+    
+    // Tighten permissions on files written by older builds
+    if info, err := os.Stat(path); err == nil && info.Mode().Perm() != 0600 {
+        if err := os.Chmod(path, 0600); err != nil {
+            return nil, fmt.Errorf("failed to secure %s: %w", path, err)
+        }
+    }
+    
+    return store, nil
+}
+
+func (f *fileStore) Get(name string) (string, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    
+    values, err := f.load()
+    if err != nil {
+        return "", err
+    }
+    value, ok := values[name]
+    if !ok {
+        return "", ErrNotFound
+    }
+    return value, nil
+}
+
+func (f *fileStore) Set(name, value string) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    
+    values, err := f.load()
+    if err != nil {
+        return err
+    }
+    values[name] = value
+    return f.save(values)
+}
+
+func (f *fileStore) Delete(name string) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    
+    values, err := f.load()
+    if err != nil {
+        return err
+    }
+    if _, ok := values[name]; !ok {
+        return nil
+    }
+    delete(values, name)
+    return f.save(values)
+}
+
+func (f *fileStore) load() (map[string]string, error) {
+    data, err := os.ReadFile(f.path)
+    if os.IsNotExist(err) {
+        return map[string]string{}, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read secrets: %w", err)
+    }
+    
+    var file encryptedFile
+    if err := json.Unmarshal(data, &file); err != nil {
+        return nil, fmt.Errorf("failed to parse secrets: %w", err)
+    }
+    if file.Version != fileFormatVersion {
+        return nil, fmt.Errorf("unsupported secrets file version %d", file.Version)
+    }
+    
+    aead, err := newAEAD(file.Salt)
+    if err != nil {
+        return nil, err
+    }
+    
+    plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, []byte(f.path))
+    if err != nil {
+        return nil, fmt.Errorf("failed to decrypt secrets (was the file copied from another machine?)")
+    }
+    
+    values := map[string]string{}
+    if err := json.Unmarshal(plaintext, &values); err != nil {
+        return nil, fmt.Errorf("failed to parse secrets: %w", err)
+    }
+    return values, nil
+}
+
+func (f *fileStore) save(values map[string]string) error {
+    plaintext, err := json.Marshal(values)
+    if err != nil {
+        return err
+    }
+    
+    // Fresh salt and nonce on every write
+    file := encryptedFile{
+        Version: fileFormatVersion,
+        Salt:    make([]byte, 16),
+    }
+    if _, err := rand.Read(file.Salt); err != nil {
+        return err
+    }
+    
+    aead, err := newAEAD(file.Salt)
+    if err != nil {
+        return err
+    }
+    file.Nonce = make([]byte, aead.NonceSize())
+    if _, err := rand.Read(file.Nonce); err != nil {
+        return err
+    }
+    file.Ciphertext = aead.Seal(nil, file.Nonce, plaintext, []byte(f.path))
+    
+    data, err := json.Marshal(file)
+    if err != nil {
+        return err
+    }
+    
+    tempPath := f.path + ".tmp"
+    if err := os.WriteFile(tempPath, data, 0600); err != nil {
+        return fmt.Errorf("failed to write secrets: %w", err)
+    }
+    if err := os.Rename(tempPath, f.path); err != nil {
+        os.Remove(tempPath)
+        return fmt.Errorf("failed to save secrets: %w", err)
+    }
+    return nil
+}
+
+// newAEAD derives the file key from machine and user identity plus salt
+func newAEAD(salt []byte) (cipher.AEAD, error) {
+    material, err := machineID()
+    if err != nil {
+        return nil, fmt.Errorf("failed to read machine identity: %w", err)
+    }
+    if u, err := user.Current(); err == nil {
+        material += "\x00" + u.Uid
+    }
+    
+    key := hkdfSHA256([]byte(material), salt, []byte("idlenet-agent secrets v1"), 32)
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+// hkdfSHA256 implements RFC 5869 extract-then-expand
+func hkdfSHA256(secret, salt, info []byte, length int) []byte {
+    extract := hmac.New(sha256.New, salt)
+    extract.Write(secret)
+    prk := extract.Sum(nil)
+    
+    var out, previous []byte
+    for counter := byte(1); len(out) < length; counter++ {
+        expand := hmac.New(sha256.New, prk)
+        expand.Write(previous)
+        expand.Write(info)
+        expand.Write([]byte{counter})
+        previous = expand.Sum(nil)
+        out = append(out, previous...)
+    }
+    return out[:length]
+}
@@ -0,0 +1,211 @@
+package secrets
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "time"
+    
+    "github.com/godbus/dbus/v5"
+)
+
+// Secret Service (GNOME Keyring, KWallet) over the session D-Bus
+const (
+    secretsName      = "org.freedesktop.secrets"
+    secretsPath      = dbus.ObjectPath("/org/freedesktop/secrets")
+    secretsInterface = "org.freedesktop.Secret"
+    
+    keyringTimeout = 5 * time.Second
+    noPrompt       = dbus.ObjectPath("/")
+)
+
+// errNeedsPrompt means the keyring is locked and would ask the user to unlock it,
+// which a background agent can't wait for
+var errNeedsPrompt = errors.New("keyring is locked")
+
+type keyring struct {
+    conn *dbus.Conn
+}
+
+// secret mirrors the Secret Service (oayays) struct
+type secret struct {
+    Session     dbus.ObjectPath
+    Parameters  []byte
+    Value       []byte
+    ContentType string
+}
+
+// openKeyring connects to the desktop keyring, if there is one
+func openKeyring() (Store, error) {
+    // Without a session bus address godbus would try to autolaunch one, which
+    // only makes sense inside a desktop session
+    if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+        return nil, errors.New("no D-Bus session")
+    }
+    
+    conn, err := dbus.ConnectSessionBus()
+    if err != nil {
+        return nil, err
+    }
+    
+    k := &keyring{conn: conn}
+    ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+    defer cancel()
+    
+    if _, err := k.collection(ctx); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return k, nil
+}
+
+func (k *keyring) Get(name string) (string, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+    defer cancel()
+    
+    item, err := k.find(ctx, name)
+    if err != nil {
+        return "", err
+    }
+    
+    session, err := k.openSession(ctx)
+    if err != nil {
+        return "", err
+    }
+    defer k.closeSession(session)
+    
+    var value secret
+    err = k.conn.Object(secretsName, item).CallWithContext(ctx, secretsInterface+".Item.GetSecret", 0, session).Store(&value)
+    if err != nil {
+        return "", fmt.Errorf("keyring read failed: %w", err)
+    }
+    return string(value.Value), nil
+}
+
+func (k *keyring) Set(name, value string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+    defer cancel()
+    
+    collection, err := k.collection(ctx)
+    if err != nil {
+        return err
+    }
+    if err := k.unlock(ctx, collection); err != nil {
+        return err
+    }
+    
+    session, err := k.openSession(ctx)
+    if err != nil {
+        return err
+    }
+    defer k.closeSession(session)
+    
+    properties := map[string]dbus.Variant{
+        secretsInterface + ".Item.Label":      dbus.MakeVariant("IdleNet Agent " + name),
+        secretsInterface + ".Item.Attributes": dbus.MakeVariant(attributes(name)),
+    }
+    payload := secret{Session: session, Value: []byte(value), ContentType: "text/plain"}
+    
+    var item, prompt dbus.ObjectPath
+    err = k.conn.Object(secretsName, collection).CallWithContext(ctx, secretsInterface+".Collection.CreateItem", 0, properties, payload, true).Store(&item, &prompt)
+    if err != nil {
+        return fmt.Errorf("keyring write failed: %w", err)
+    }
+    if prompt != noPrompt {
+        return errNeedsPrompt
+    }
+    return nil
+}
+
+func (k *keyring) Delete(name string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+    defer cancel()
+    
+    item, err := k.find(ctx, name)
+    if errors.Is(err, ErrNotFound) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    
+    var prompt dbus.ObjectPath
+    err = k.conn.Object(secretsName, item).CallWithContext(ctx, secretsInterface+".Item.Delete", 0).Store(&prompt)
+    if err != nil {
+        return fmt.Errorf("keyring delete failed: %w", err)
+    }
+    if prompt != noPrompt {
+        return errNeedsPrompt
+    }
+    return nil
+}
+
+// find returns the unlocked item holding name
+func (k *keyring) find(ctx context.Context, name string) (dbus.ObjectPath, error) {
+    var unlocked, locked []dbus.ObjectPath
+    err := k.service().CallWithContext(ctx, secretsInterface+".Service.SearchItems", 0, attributes(name)).Store(&unlocked, &locked)
+    if err != nil {
+        return "", fmt.Errorf("keyring search failed: %w", err)
+    }
+    
+    if len(unlocked) > 0 {
+        return unlocked[0], nil
+    }
+    if len(locked) > 0 {
+        if err := k.unlock(ctx, locked[0]); err != nil {
+            return "", err
+        }
+        return locked[0], nil
+    }
+    return "", ErrNotFound
+}
+
+// collection returns the default keyring
+func (k *keyring) collection(ctx context.Context) (dbus.ObjectPath, error) {
+    var path dbus.ObjectPath
+    if err := k.service().CallWithContext(ctx, secretsInterface+".Service.ReadAlias", 0, "default").Store(&path); err != nil {
+        return "", fmt.Errorf("no Secret Service: %w", err)
+    }
+    if path == noPrompt {
+        return "", errors.New("no default keyring")
+    }
+    return path, nil
+}
+
+// unlock succeeds only if it doesn't need to show an unlock prompt
+func (k *keyring) unlock(ctx context.Context, path dbus.ObjectPath) error {
+    var unlocked []dbus.ObjectPath
+    var prompt dbus.ObjectPath
+    err := k.service().CallWithContext(ctx, secretsInterface+".Service.Unlock", 0, []dbus.ObjectPath{path}).Store(&unlocked, &prompt)
+    if err != nil {
+        return fmt.Errorf("keyring unlock failed: %w", err)
+    }
+    if prompt != noPrompt {
+        return errNeedsPrompt
+    }
+    return nil
+}
+
+// openSession negotiates a "plain" session; the bus is local to this user
+func (k *keyring) openSession(ctx context.Context) (dbus.ObjectPath, error) {
+    var output dbus.Variant
+    var session dbus.ObjectPath
+    err := k.service().CallWithContext(ctx, secretsInterface+".Service.OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session)
+    if err != nil {
+        return "", fmt.Errorf("keyring session failed: %w", err)
+    }
+    return session, nil
+}
+
+func (k *keyring) closeSession(session dbus.ObjectPath) {
+    k.conn.Object(secretsName, session).Call(secretsInterface+".Session.Close", 0)
+}
+
+func (k *keyring) service() dbus.BusObject {
+    return k.conn.Object(secretsName, secretsPath)
+}
+
+func attributes(name string) map[string]string {
+    return map[string]string{"application": "idlenet-agent", "name": name}
+}
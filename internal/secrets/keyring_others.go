@@ -0,0 +1,10 @@
+//go:build !linux
+
+package secrets
+
+import "errors"
+
+// openKeyring reports that no OS keyring integration exists for this platform yet
+func openKeyring() (Store, error) {
+    return nil, errors.New("no keyring support on this platform")
+}
@@ -0,0 +1,20 @@
+package secrets
+
+import (
+    "os"
+    "os/exec"
+    "regexp"
+)
+
+var platformUUID = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+// machineID reads the hardware UUID from IOKit, falling back to the hostname
+func machineID() (string, error) {
+    output, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+    if err == nil {
+        if match := platformUUID.FindSubmatch(output); match != nil {
+            return string(match[1]), nil
+        }
+    }
+    return os.Hostname()
+}
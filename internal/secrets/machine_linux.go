@@ -0,0 +1,18 @@
+package secrets
+
+import (
+    "os"
+    "strings"
+)
+
+// machineID reads the systemd/dbus machine id, falling back to the hostname
+func machineID() (string, error) {
+    for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+        if data, err := os.ReadFile(path); err == nil {
+            if id := strings.TrimSpace(string(data)); id != "" {
+                return id, nil
+            }
+        }
+    }
+    return os.Hostname()
+}
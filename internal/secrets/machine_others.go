@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package secrets
+
+import "os"
+
+// machineID falls back to the hostname where there's no better identifier
+func machineID() (string, error) {
+    return os.Hostname()
+}
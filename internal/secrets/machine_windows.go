@@ -0,0 +1,19 @@
+package secrets
+
+import (
+    "os"
+    
+    "golang.org/x/sys/windows/registry"
+)
+
+// machineID reads the MachineGuid set at Windows install, falling back to the hostname
+func machineID() (string, error) {
+    key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Cryptography`, registry.QUERY_VALUE|registry.WOW64_64KEY)
+    if err == nil {
+        defer key.Close()
+        if guid, _, err := key.GetStringValue("MachineGuid"); err == nil && guid != "" {
+            return guid, nil
+        }
+    }
+    return os.Hostname()
+}
@@ -0,0 +1,97 @@
+package secrets
+
+import (
+    "errors"
+    "path/filepath"
+)
+
+// Names of the secrets the agent keeps
+const (
+    DeviceKey     = "device_key"     // PEM-encoded ed25519 private key
+    RefreshToken  = "refresh_token"  // Long-lived API session token
+    BypassToken   = "vercel_bypass"  // Vercel deployment protection bypass
+    ProxyPassword = "proxy_password" // Password for the configured authenticating proxy
+)
+
+// ErrNotFound is returned by Get when a secret hasn't been stored
+var ErrNotFound = errors.New("secret not found")
+
+// Store keeps credentials out of config.json
+type Store interface {
+    Get(name string) (string, error)
+    Set(name, value string) error
+    Delete(name string) error
+}
+
+// Open returns the best store available: the desktop keyring (Secret Service
+// over D-Bus) when there is one, backed by an encrypted file in dir for
+// headless machines and for secrets written while no keyring was reachable
+func Open(dir string) (Store, error) {
+    file, err := openFileStore(filepath.Join(dir, "secrets.enc"))
+    if err != nil {
+        return nil, err
+    }
+    
+    keyring, err := openKeyring()
+    if err != nil {
+        return file, nil
+    }
+    
+    return &chainStore{primary: keyring, fallback: file}, nil
+}
+
+// chainStore prefers the keyring but still finds secrets left in the file store
+type chainStore struct {
+    primary  Store
+    fallback Store
+}
+
+func (c *chainStore) Get(name string) (string, error) {
+    value, err := c.primary.Get(name)
+    if !errors.Is(err, ErrNotFound) {
+        // A locked or broken keyring isn't the same as no secret, and an
+        // older copy in the file store would be stale
+        return value, err
+    }
+    return c.fallback.Get(name)
+}
+
+func (c *chainStore) Set(name, value string) error {
+    if err := c.primary.Set(name, value); err != nil {
+        return c.fallback.Set(name, value)
+    }
+    
+    // Don't leave an older copy behind where Get could find it later
+    c.fallback.Delete(name)
+    return nil
+}
+
+func (c *chainStore) Delete(name string) error {
+    primaryErr := c.primary.Delete(name)
+    fallbackErr := c.fallback.Delete(name)
+    if primaryErr != nil {
+        return primaryErr
+    }
+    return fallbackErr
+}
+
+// TokenStore adapts a Store to hold the API refresh token
+type TokenStore struct {
+    Store Store
+}
+
+func (t TokenStore) LoadRefreshToken() (string, error) {
+    token, err := t.Store.Get(RefreshToken)
+    if errors.Is(err, ErrNotFound) {
+        return "", nil
+    }
+    return token, err
+}
+
+func (t TokenStore) SaveRefreshToken(token string) error {
+    return t.Store.Set(RefreshToken, token)
+}
+
+func (t TokenStore) ClearRefreshToken() error {
+    return t.Store.Delete(RefreshToken)
+}
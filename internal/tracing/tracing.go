@@ -0,0 +1,166 @@
+// Package tracing follows each job through the agent with OpenTelemetry spans:
+// the poll that found it, running it, recording it and uploading its result
+// Spans go over OTLP/HTTP to a collector when one is configured, and the
+// server's trace context is carried from the job offer to the result so
+// both sides end up in the same trace
+package tracing
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "net/url"
+    "strings"
+    "time"
+    
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/trace"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/logging"
+)
+
+const instrumentation = "github.com/ifruncillo/idlenet-agent"
+
+// DefaultPort is the standard OTLP/HTTP collector port
+const DefaultPort = "4318"
+
+// Options says where spans go and what to tag them with
+type Options struct {
+    Endpoint string // Collector, e.g. "4318", "127.0.0.1:4318" or a URL; "" exports nothing
+    Version  string
+    DeviceID string
+}
+
+// Setup installs the W3C trace context propagator and, if opts has an
+// endpoint, an exporter to it
+// Without an endpoint spans are never recorded, but an offer's trace context
+// still reaches the result headers so the server's own trace stays whole
+// The returned shutdown flushes whatever hasn't been sent yet
+func Setup(opts Options) (shutdown func(context.Context) error, err error) {
+    otel.SetTextMapPropagator(propagation.TraceContext{})
+    
+    if opts.Endpoint == "" {
+        return func(context.Context) error { return nil }, nil
+    }
+    endpoint, err := EndpointURL(opts.Endpoint)
+    if err != nil {
+        return nil, err
+    }
+    
+    exporter, err := otlptracehttp.New(context.Background(),
+        otlptracehttp.WithEndpointURL(endpoint),
+        otlptracehttp.WithTimeout(10*time.Second))
+    if err != nil {
+        return nil, fmt.Errorf("trace exporter: %w", err)
+    }
+    
+    agent, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+        attribute.String("service.name", "idlenet-agent"),
+        attribute.String("service.version", opts.Version),
+        attribute.String("service.instance.id", opts.DeviceID),
+    ))
+    if err != nil {
+        return nil, fmt.Errorf("trace resource: %w", err)
+    }
+    
+    provider := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithResource(agent),
+    )
+    otel.SetTracerProvider(provider)
+    otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+        logging.Subsystem("tracing").Warn("Trace export failed", "error", err)
+    }))
+    return provider.Shutdown, nil
+}
+
+// EndpointURL normalises a configured collector: a bare port is on loopback,
+// a host without a scheme is plain HTTP, and the path defaults to /v1/traces
+func EndpointURL(configured string) (string, error) {
+    if !strings.Contains(configured, "://") {
+        host, port, err := net.SplitHostPort(configured)
+        if err != nil {
+            // A bare port, or a host without one
+            if isPort(configured) {
+                host, port = "127.0.0.1", configured
+            } else {
+                host, port = configured, DefaultPort
+            }
+        }
+        if host == "" {
+            host = "127.0.0.1"
+        }
+        configured = "http://" + net.JoinHostPort(host, port)
+    }
+    
+    u, err := url.Parse(configured)
+    if err != nil {
+        return "", fmt.Errorf("trace endpoint %q: %w", configured, err)
+    }
+    if u.Scheme != "http" && u.Scheme != "https" {
+        return "", fmt.Errorf("trace endpoint %q: scheme must be http or https", configured)
+    }
+    if u.Host == "" {
+        return "", fmt.Errorf("trace endpoint %q: missing host", configured)
+    }
+    if u.Path == "" || u.Path == "/" {
+        u.Path = "/v1/traces"
+    }
+    return u.String(), nil
+}
+
+func isPort(s string) bool {
+    if s == "" {
+        return false
+    }
+    for _, r := range s {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// Tracer is the agent's tracer; its spans go nowhere until Setup has an endpoint
+func Tracer() trace.Tracer {
+    return otel.Tracer(instrumentation)
+}
+
+// Extract returns ctx carrying the trace context the server sent, if any
+func Extract(ctx context.Context, traceParent, traceState string) context.Context {
+    if traceParent == "" {
+        return ctx
+    }
+    carrier := propagation.MapCarrier{"traceparent": traceParent}
+    if traceState != "" {
+        carrier["tracestate"] = traceState
+    }
+    return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// Inject returns ctx's trace context in W3C form, so it can be kept with a
+// result until the outbox sends it
+func Inject(ctx context.Context) (traceParent, traceState string) {
+    carrier := propagation.MapCarrier{}
+    otel.GetTextMapPropagator().Inject(ctx, carrier)
+    return carrier["traceparent"], carrier["tracestate"]
+}
+
+// Fail marks span as failed, with the message scrubbed the same way as the logs
+func Fail(span trace.Span, message string) {
+    span.SetStatus(codes.Error, logging.Scrub(message))
+}
+
+// Finish ends span, failing it first if err is set
+func Finish(span trace.Span, err error) {
+    if err != nil {
+        Fail(span, err.Error())
+    }
+    span.End()
+}
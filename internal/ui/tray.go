@@ -7,11 +7,13 @@ import (
     
     "github.com/getlantern/systray"
     "github.com/ifruncillo/idlenet-agent/internal/config"
+    "github.com/ifruncillo/idlenet-agent/internal/metrics"
 )
 
 // TrayApp manages the system tray interface
 type TrayApp struct {
     cfg           *config.Config
+    tracker       *metrics.Tracker
     statusItem    *systray.MenuItem
     earningsItem  *systray.MenuItem
     settingsItem  *systray.MenuItem
@@ -23,9 +25,11 @@ type TrayApp struct {
 }
 
 // Start initializes the system tray application
-func Start(cfg *config.Config) {
+// Earnings shown come from tracker and are estimates until the server confirms them
+func Start(cfg *config.Config, tracker *metrics.Tracker) {
     app := &TrayApp{
         cfg:          cfg,
+        tracker:      tracker,
         sessionStart: time.Now(),
     }
     
@@ -40,7 +44,7 @@ func (app *TrayApp) onReady() {
     
     // Create menu items
     app.statusItem = systray.AddMenuItem("Status: Running", "Agent status")
-    app.earningsItem = systray.AddMenuItem("Session: $0.00", "Earnings this session")
+    app.earningsItem = systray.AddMenuItem("Session: ~$0.00 (estimate)", "Estimated earnings this session; the server's ledger is what's paid")
     systray.AddSeparator()
     
     // Resource mode submenu
@@ -133,11 +137,10 @@ func (app *TrayApp) updateStatus() {
         runtime := time.Since(app.sessionStart)
         hours := runtime.Hours()
         
-        // Calculate simulated earnings (replace with real calculation)
-        // Assuming $0.10 per hour for demo
-        earnings := hours * 0.10
+        // Priced by the tracker from measured usage and the rate card
+        earnings := app.tracker.Usage().Earnings
         
-        app.earningsItem.SetTitle(fmt.Sprintf("Session: $%.2f (%.1f hrs)", earnings, hours))
+        app.earningsItem.SetTitle(fmt.Sprintf("Session: ~$%.2f estimate (%.1f hrs)", earnings, hours))
         
         if app.isRunning {
             app.statusItem.SetTitle("Status: Running ✓")
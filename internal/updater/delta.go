@@ -0,0 +1,212 @@
+package updater
+
+import (
+    "bytes"
+    "compress/bzip2"
+    "context"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    
+    "github.com/klauspost/compress/zstd"
+)
+
+// ErrNoPatch means the manifest has no patch from the running version
+var ErrNoPatch = errors.New("no patch available for this version")
+
+// DownloadDelta rebuilds the new release from the running executable and a
+// small patch listed in the signed manifest. The result is only returned if it
+// matches the manifest's hash for the full binary exactly.
+func (d *Downloader) DownloadDelta(ctx context.Context, release *GitHubRelease, manifest *Manifest, currentVersion, currentExe string, progress ProgressFunc) (string, error) {
+    assetName := d.getAssetName()
+    
+    entry, ok := manifest.Assets[assetName]
+    if !ok {
+        return "", fmt.Errorf("manifest has no entry for %s", assetName)
+    }
+    
+    patch := entry.PatchFrom(currentVersion)
+    if patch == nil {
+        return "", ErrNoPatch
+    }
+    
+    patchAsset := release.FindAsset(patch.Name)
+    if patchAsset == nil {
+        return "", fmt.Errorf("patch %s missing from release", patch.Name)
+    }
+    
+    oldBinary, err := os.ReadFile(currentExe)
+    if err != nil {
+        return "", fmt.Errorf("failed to read current executable: %w", err)
+    }
+    
+    // A patch is only valid against the exact bytes it was built from
+    oldSum := sha256.Sum256(oldBinary)
+    if !strings.EqualFold(hex.EncodeToString(oldSum[:]), patch.FromSHA256) {
+        return "", fmt.Errorf("current executable doesn't match patch base %s", patch.From)
+    }
+    
+    patchPath := filepath.Join(d.tempDir, patch.Name)
+    if d.VerifyChecksum(patchPath, patch.SHA256) != nil {
+        partPath := patchPath + ".part"
+        if err := d.downloadVerified(ctx, patchAsset.DownloadURL, partPath, patch.Size, patch.SHA256, progress); err != nil {
+            return "", fmt.Errorf("failed to download patch: %w", err)
+        }
+        if err := os.Rename(partPath, patchPath); err != nil {
+            return "", fmt.Errorf("failed to move patch into place: %w", err)
+        }
+    }
+    defer os.Remove(patchPath)
+    
+    patchFile, err := os.Open(patchPath)
+    if err != nil {
+        return "", err
+    }
+    defer patchFile.Close()
+    
+    newBinary, err := applyPatch(patch.Format, oldBinary, patchFile, entry.Size)
+    if err != nil {
+        return "", fmt.Errorf("failed to apply %s patch: %w", patch.Format, err)
+    }
+    
+    if int64(len(newBinary)) != entry.Size {
+        return "", fmt.Errorf("patched binary is %d bytes, manifest says %d", len(newBinary), entry.Size)
+    }
+    newSum := sha256.Sum256(newBinary)
+    if actual := hex.EncodeToString(newSum[:]); !strings.EqualFold(actual, entry.SHA256) {
+        return "", fmt.Errorf("patched binary checksum mismatch: expected %s, got %s", entry.SHA256, actual)
+    }
+    
+    finalPath := filepath.Join(d.tempDir, assetName)
+    partPath := finalPath + ".part"
+    if err := writeFileSync(partPath, newBinary); err != nil {
+        os.Remove(partPath)
+        return "", fmt.Errorf("failed to save patched binary: %w", err)
+    }
+    if err := os.Rename(partPath, finalPath); err != nil {
+        return "", fmt.Errorf("failed to move update into place: %w", err)
+    }
+    
+    return finalPath, nil
+}
+
+// applyPatch reconstructs a binary of newSize bytes from old and a patch stream
+func applyPatch(format string, old []byte, patch io.Reader, newSize int64) ([]byte, error) {
+    switch format {
+    case "bsdiff":
+        data, err := io.ReadAll(patch)
+        if err != nil {
+            return nil, err
+        }
+        return bspatch(old, data)
+    
+    case "zstd":
+        // Produced by `zstd --patch-from=old new`, which uses the old binary as a raw dictionary
+        decoder, err := zstd.NewReader(patch,
+            zstd.WithDecoderDictRaw(0, old),
+            zstd.WithDecoderMaxWindow(uint64(len(old))+uint64(newSize)+(1<<20)))
+        if err != nil {
+            return nil, err
+        }
+        defer decoder.Close()
+        return io.ReadAll(io.LimitReader(decoder, newSize+1))
+    
+    default:
+        return nil, fmt.Errorf("unsupported patch format %q", format)
+    }
+}
+
+// bspatch applies a classic BSDIFF40 patch as produced by bsdiff 4.x
+func bspatch(old, patch []byte) ([]byte, error) {
+    const headerSize = 32
+    if len(patch) < headerSize || string(patch[:8]) != "BSDIFF40" {
+        return nil, fmt.Errorf("not a BSDIFF40 patch")
+    }
+    
+    ctrlLen := offtin(patch[8:16])
+    diffLen := offtin(patch[16:24])
+    newSize := offtin(patch[24:32])
+    if ctrlLen < 0 || diffLen < 0 || newSize < 0 ||
+        headerSize+ctrlLen+diffLen > int64(len(patch)) {
+        return nil, fmt.Errorf("corrupt patch header")
+    }
+    
+    body := patch[headerSize:]
+    ctrl := bzip2.NewReader(bytes.NewReader(body[:ctrlLen]))
+    diff := bzip2.NewReader(bytes.NewReader(body[ctrlLen : ctrlLen+diffLen]))
+    extra := bzip2.NewReader(bytes.NewReader(body[ctrlLen+diffLen:]))
+    
+    out := make([]byte, newSize)
+    oldSize := int64(len(old))
+    var oldPos, newPos int64
+    var buf [8]byte
+    
+    for newPos < newSize {
+        // Each control triple is: bytes to add from diff, bytes to copy from
+        // extra, and how far to seek in the old file afterwards
+        var triple [3]int64
+        for i := range triple {
+            if _, err := io.ReadFull(ctrl, buf[:]); err != nil {
+                return nil, fmt.Errorf("corrupt patch control block: %w", err)
+            }
+            triple[i] = offtin(buf[:])
+        }
+    
+        if triple[0] < 0 || triple[1] < 0 || newPos+triple[0] > newSize {
+            return nil, fmt.Errorf("corrupt patch")
+        }
+        if _, err := io.ReadFull(diff, out[newPos:newPos+triple[0]]); err != nil {
+            return nil, fmt.Errorf("corrupt patch diff block: %w", err)
+        }
+        for i := int64(0); i < triple[0]; i++ {
+            if pos := oldPos + i; pos >= 0 && pos < oldSize {
+                out[newPos+i] += old[pos]
+            }
+        }
+        newPos += triple[0]
+        oldPos += triple[0]
+    
+        if newPos+triple[1] > newSize {
+            return nil, fmt.Errorf("corrupt patch")
+        }
+        if _, err := io.ReadFull(extra, out[newPos:newPos+triple[1]]); err != nil {
+            return nil, fmt.Errorf("corrupt patch extra block: %w", err)
+        }
+        newPos += triple[1]
+        oldPos += triple[2]
+    }
+    
+    return out, nil
+}
+
+// offtin decodes bsdiff's sign-magnitude little-endian 64-bit integers
+func offtin(b []byte) int64 {
+    magnitude := int64(binary.LittleEndian.Uint64(b) &^ (1 << 63))
+    if b[7]&0x80 != 0 {
+        return -magnitude
+    }
+    return magnitude
+}
+
+// writeFileSync writes data and flushes it to disk before returning
+func writeFileSync(path string, data []byte) error {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    if _, err := f.Write(data); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
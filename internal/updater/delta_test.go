@@ -0,0 +1,71 @@
+package updater
+
+import (
+    "bytes"
+    "encoding/base64"
+    "strings"
+    "testing"
+    
+    "github.com/klauspost/compress/zstd"
+)
+
+// bsdiffPatch turns "the quick brown fox" into "the quick red FOX!!" using
+// both diff bytes and extra bytes, and a seek over "brown" in the old file
+const bsdiffPatch = "QlNESUZGNDAuAAAAAAAAACoAAAAAAAAAEwAAAAAAAABCWmg5MUFZJlNZUGulOgAADkAAXhggACGEeoIYDAAbdUSi6+LuSKcKEgoNdKdAQlpoOTFBWSZTWWQ2i6AAAABAAUCAQAAgADDMCTJIJcXckU4UJBkNougAQlpoOTFBWSZTWRPu2AMAAAIRgCAABgAQACAAMM00GZAOeLuSKcKEgJ92wBg="
+
+func TestBspatch(t *testing.T) {
+    patch, _ := base64.StdEncoding.DecodeString(bsdiffPatch)
+    old := []byte("the quick brown fox")
+    
+    got, err := applyPatch("bsdiff", old, bytes.NewReader(patch), 19)
+    if err != nil {
+        t.Fatalf("applyPatch: %v", err)
+    }
+    if string(got) != "the quick red FOX!!" {
+        t.Errorf("patched = %q, want %q", got, "the quick red FOX!!")
+    }
+    
+    // A header promising more output than the control block describes
+    overlong := append([]byte(nil), patch...)
+    overlong[24] = 40
+    
+    tests := []struct {
+        name  string
+        patch []byte
+    }{
+        {"short", patch[:20]},
+        {"magic", append([]byte("BSDIFF41"), patch[8:]...)},
+        {"truncated", patch[:60]},
+        {"overlong", overlong},
+    }
+    for _, test := range tests {
+        if _, err := bspatch(old, test.patch); err == nil {
+            t.Errorf("%s patch applied, want an error", test.name)
+        }
+    }
+}
+
+func TestApplyZstdPatch(t *testing.T) {
+    old := []byte(strings.Repeat("idlenet agent 1.0 ", 500))
+    want := []byte(strings.Repeat("idlenet agent 1.1 ", 500))
+    
+    // The same as `zstd --patch-from=old new`
+    encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDictRaw(0, old))
+    if err != nil {
+        t.Fatal(err)
+    }
+    patch := encoder.EncodeAll(want, nil)
+    encoder.Close()
+    
+    got, err := applyPatch("zstd", old, bytes.NewReader(patch), int64(len(want)))
+    if err != nil {
+        t.Fatalf("applyPatch: %v", err)
+    }
+    if !bytes.Equal(got, want) {
+        t.Errorf("patched binary differs from the new one")
+    }
+    
+    if _, err := applyPatch("xdelta", old, bytes.NewReader(patch), int64(len(want))); err == nil {
+        t.Errorf("unknown format applied, want an error")
+    }
+}
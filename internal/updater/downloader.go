@@ -1,16 +1,34 @@
 package updater
 
 import (
+    "context"
     "crypto/sha256"
     "encoding/hex"
+    "errors"
     "fmt"
+    "hash"
     "io"
     "net/http"
     "os"
     "path/filepath"
     "runtime"
+    "strconv"
+    "strings"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/netconf"
 )
 
+// checksumAssetName is the checksum list published next to each release's binaries
+const checksumAssetName = "SHA256SUMS.txt"
+
+// maxDownloadAttempts bounds how often a single download is resumed after a transient failure
+const maxDownloadAttempts = 5
+
+// ProgressFunc is called as an update downloads so the tray or CLI can show progress
+// total is -1 when the size is not known
+type ProgressFunc func(downloaded, total int64)
+
 // Downloader handles downloading and verifying updates
 type Downloader struct {
     httpClient *http.Client
@@ -25,50 +43,239 @@ func NewDownloader() (*Downloader, error) {
     }
     
     return &Downloader{
-        httpClient: &http.Client{},
-        tempDir:    tempDir,
+        // Binaries can be large on slow links, so there's no client timeout;
+        // the transport bounds the connection phases and the context the transfer
+        httpClient: &http.Client{Transport: netconf.Default()},
+        tempDir: tempDir,
     }, nil
 }
 
 // DownloadUpdate downloads the appropriate binary for this platform
-func (d *Downloader) DownloadUpdate(release *GitHubRelease) (string, error) {
+// Interrupted downloads resume from the partial file on the next call, and
+// the result is only moved into place once its size and SHA256 match the
+// signed manifest, or the release's checksum list in builds without a release key
+func (d *Downloader) DownloadUpdate(ctx context.Context, release *GitHubRelease, manifest *Manifest, progress ProgressFunc) (string, error) {
     // Determine the correct asset name for this platform
     assetName := d.getAssetName()
     
-    // Find the matching asset
-    var downloadURL string
-    for _, asset := range release.Assets {
-        if asset.Name == assetName {
-            downloadURL = asset.DownloadURL
+    asset := release.FindAsset(assetName)
+    if asset == nil {
+        return "", fmt.Errorf("no release found for platform %s/%s", runtime.GOOS, runtime.GOARCH)
+    }
+    
+    var expectedChecksum string
+    if manifest != nil {
+        entry, ok := manifest.Assets[assetName]
+        if !ok {
+            return "", fmt.Errorf("manifest has no entry for %s", assetName)
+        }
+        if entry.Size != asset.Size {
+            return "", fmt.Errorf("release asset is %d bytes, manifest says %d", asset.Size, entry.Size)
+        }
+        expectedChecksum = entry.SHA256
+    } else {
+        var err error
+        if expectedChecksum, err = d.fetchChecksum(ctx, release, assetName); err != nil {
+            return "", err
+        }
+    }
+    
+    finalPath := filepath.Join(d.tempDir, assetName)
+    
+    // A previous run may already have finished this exact download
+    if d.VerifyChecksum(finalPath, expectedChecksum) == nil {
+        if progress != nil {
+            progress(asset.Size, asset.Size)
+        }
+        return finalPath, nil
+    }
+    
+    partPath := finalPath + ".part"
+    if err := d.downloadVerified(ctx, asset.DownloadURL, partPath, asset.Size, expectedChecksum, progress); err != nil {
+        return "", err
+    }
+    
+    if err := os.Rename(partPath, finalPath); err != nil {
+        return "", fmt.Errorf("failed to move update into place: %w", err)
+    }
+    
+    return finalPath, nil
+}
+
+// downloadVerified fills partPath from url, resuming where it left off, and
+// checks the finished file against the expected size and checksum
+func (d *Downloader) downloadVerified(ctx context.Context, url, partPath string, expectedSize int64, expectedChecksum string, progress ProgressFunc) error {
+    out, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to create temp file: %w", err)
+    }
+    defer out.Close()
+    
+    // Re-hash whatever a previous attempt left behind so the final
+    // checksum covers the whole file without reading it twice at the end
+    hasher := sha256.New()
+    offset, err := io.Copy(hasher, out)
+    if err != nil {
+        return fmt.Errorf("failed to read partial download: %w", err)
+    }
+    
+    if expectedSize > 0 && offset > expectedSize {
+        if offset, err = restartFile(out, hasher); err != nil {
+            return err
+        }
+    }
+    
+    var lastErr error
+    for attempt := 0; attempt < maxDownloadAttempts; attempt++ {
+        if attempt > 0 {
+            select {
+            case <-ctx.Done():
+                return ctx.Err()
+            case <-time.After(time.Duration(attempt) * 2 * time.Second):
+            }
+        }
+    
+        var done bool
+        offset, done, lastErr = d.fetchRange(ctx, url, out, hasher, offset, expectedSize, progress)
+        if lastErr == nil && done {
             break
         }
+        if lastErr != nil && !isRetryable(lastErr) {
+            return lastErr
+        }
+    }
+    if lastErr != nil {
+        return fmt.Errorf("download failed after %d attempts: %w", maxDownloadAttempts, lastErr)
     }
     
-    if downloadURL == "" {
-        return "", fmt.Errorf("no release found for platform %s/%s", runtime.GOOS, runtime.GOARCH)
+    if expectedSize > 0 && offset != expectedSize {
+        return fmt.Errorf("size mismatch: expected %d bytes, got %d", expectedSize, offset)
+    }
+    
+    actualChecksum := hex.EncodeToString(hasher.Sum(nil))
+    if !strings.EqualFold(actualChecksum, expectedChecksum) {
+        // A corrupt partial file would fail the same way forever, so start over next time
+        out.Close()
+        os.Remove(partPath)
+        return fmt.Errorf("checksum mismatch: expected %s, got %s", expectedChecksum, actualChecksum)
+    }
+    
+    if err := out.Sync(); err != nil {
+        return fmt.Errorf("failed to flush update: %w", err)
     }
     
-    // Download to temp file
-    tempFile := filepath.Join(d.tempDir, assetName)
+    return nil
+}
+
+// fetchRange requests everything from offset onwards and appends it to out
+// It returns the new offset and whether the file is complete
+func (d *Downloader) fetchRange(ctx context.Context, url string, out *os.File, hasher hash.Hash, offset, expectedSize int64, progress ProgressFunc) (int64, bool, error) {
+    if expectedSize > 0 && offset == expectedSize {
+        return offset, true, nil
+    }
     
-    resp, err := d.httpClient.Get(downloadURL)
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
     if err != nil {
-        return "", fmt.Errorf("download failed: %w", err)
+        return offset, false, err
+    }
+    req.Header.Set("User-Agent", "IdleNet-Agent-Updater")
+    if offset > 0 {
+        req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+    }
+    
+    resp, err := d.httpClient.Do(req)
+    if err != nil {
+        return offset, false, retryable(err)
     }
     defer resp.Body.Close()
     
-    out, err := os.Create(tempFile)
+    switch resp.StatusCode {
+    case http.StatusPartialContent:
+        start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+        if err != nil || start != offset {
+            // The server answered a different range than we asked for; start clean
+            if offset, err = restartFile(out, hasher); err != nil {
+                return offset, false, err
+            }
+            return offset, false, retryable(fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range")))
+        }
+    
+    case http.StatusOK:
+        // Server ignored the Range header and is sending the whole file
+        if offset > 0 {
+            if offset, err = restartFile(out, hasher); err != nil {
+                return offset, false, err
+            }
+        }
+    
+    case http.StatusRequestedRangeNotSatisfiable:
+        // Our partial file is at least as long as the remote one; let the
+        // size and checksum checks decide whether it is actually complete
+        if expectedSize <= 0 || offset >= expectedSize {
+            return offset, true, nil
+        }
+        if offset, err = restartFile(out, hasher); err != nil {
+            return offset, false, err
+        }
+        return offset, false, retryable(fmt.Errorf("server rejected resume range"))
+    
+    default:
+        err := fmt.Errorf("download returned status %d", resp.StatusCode)
+        if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+            return offset, false, retryable(err)
+        }
+        return offset, false, err
+    }
+    
+    total := expectedSize
+    if total <= 0 {
+        total = -1
+        if resp.ContentLength >= 0 {
+            total = offset + resp.ContentLength
+        }
+    }
+    
+    body := &progressReader{r: resp.Body, done: offset, total: total, report: progress}
+    if progress != nil {
+        progress(offset, total)
+    }
+    
+    written, err := io.Copy(io.MultiWriter(out, hasher), body)
+    offset += written
     if err != nil {
-        return "", fmt.Errorf("failed to create temp file: %w", err)
+        if ctx.Err() != nil {
+            return offset, false, ctx.Err()
+        }
+        return offset, false, retryable(fmt.Errorf("failed to save update: %w", err))
+    }
+    
+    return offset, true, nil
+}
+
+// fetchChecksum looks up the expected SHA256 of assetName in the release's checksum list
+func (d *Downloader) fetchChecksum(ctx context.Context, release *GitHubRelease, assetName string) (string, error) {
+    sums := release.FindAsset(checksumAssetName)
+    if sums == nil {
+        return "", fmt.Errorf("release %s has no %s, refusing unverified update", release.TagName, checksumAssetName)
     }
-    defer out.Close()
     
-    _, err = io.Copy(out, resp.Body)
+    body, err := d.fetchSmall(ctx, sums.DownloadURL)
     if err != nil {
-        return "", fmt.Errorf("failed to save update: %w", err)
+        return "", fmt.Errorf("failed to fetch checksums: %w", err)
+    }
+    
+    // sha256sum format: "<hex>  <name>", with an optional '*' marking binary mode
+    for _, line := range strings.Split(string(body), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) != 2 {
+            continue
+        }
+        if strings.TrimPrefix(fields[1], "*") == assetName {
+            return strings.ToLower(fields[0]), nil
+        }
     }
     
-    return tempFile, nil
+    return "", fmt.Errorf("no checksum listed for %s", assetName)
 }
 
 // getAssetName returns the expected asset name for this platform
@@ -94,7 +301,7 @@ func (d *Downloader) VerifyChecksum(filepath, expectedChecksum string) error {
     }
     
     actualChecksum := hex.EncodeToString(hasher.Sum(nil))
-    if actualChecksum != expectedChecksum {
+    if !strings.EqualFold(actualChecksum, expectedChecksum) {
         return fmt.Errorf("checksum mismatch: expected %s, got %s", 
             expectedChecksum, actualChecksum)
     }
@@ -105,4 +312,63 @@ func (d *Downloader) VerifyChecksum(filepath, expectedChecksum string) error {
 // CleanupTemp removes temporary download files
 func (d *Downloader) CleanupTemp() error {
     return os.RemoveAll(d.tempDir)
-}
\ No newline at end of file
+}
+
+// restartFile empties a partial download so it can be fetched from the beginning
+func restartFile(out *os.File, hasher hash.Hash) (int64, error) {
+    if err := out.Truncate(0); err != nil {
+        return 0, fmt.Errorf("failed to reset partial download: %w", err)
+    }
+    if _, err := out.Seek(0, io.SeekStart); err != nil {
+        return 0, fmt.Errorf("failed to reset partial download: %w", err)
+    }
+    hasher.Reset()
+    return 0, nil
+}
+
+// contentRangeStart parses the first byte position out of "bytes start-end/size"
+func contentRangeStart(header string) (int64, error) {
+    spec, ok := strings.CutPrefix(header, "bytes ")
+    if !ok {
+        return 0, fmt.Errorf("unsupported Content-Range %q", header)
+    }
+    start, _, ok := strings.Cut(spec, "-")
+    if !ok {
+        return 0, fmt.Errorf("malformed Content-Range %q", header)
+    }
+    return strconv.ParseInt(start, 10, 64)
+}
+
+// progressReader reports bytes as they flow through a download
+type progressReader struct {
+    r      io.Reader
+    done   int64
+    total  int64
+    report ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+    n, err := p.r.Read(b)
+    if n > 0 && p.report != nil {
+        p.done += int64(n)
+        p.report(p.done, p.total)
+    }
+    return n, err
+}
+
+// retryableError marks failures that are worth resuming after
+type retryableError struct {
+    err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+func retryable(err error) error {
+    return &retryableError{err: err}
+}
+
+func isRetryable(err error) bool {
+    var r *retryableError
+    return errors.As(err, &r)
+}
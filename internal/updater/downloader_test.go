@@ -0,0 +1,155 @@
+package updater
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// rangeServer serves content, answering the first request for a range with
+// misbehave if it's set and honouring ranges properly after that
+type rangeServer struct {
+    content   []byte
+    misbehave func(w http.ResponseWriter, r *http.Request)
+    
+    mu     sync.Mutex
+    ranges []string // Range header of each request, "" when there was none
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    s.mu.Lock()
+    s.ranges = append(s.ranges, r.Header.Get("Range"))
+    misbehave := s.misbehave
+    if r.Header.Get("Range") != "" {
+        s.misbehave = nil
+    }
+    s.mu.Unlock()
+    
+    if misbehave != nil && r.Header.Get("Range") != "" {
+        misbehave(w, r)
+        return
+    }
+    http.ServeContent(w, r, "update", time.Time{}, bytes.NewReader(s.content))
+}
+
+func (s *rangeServer) requests() string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return strings.Join(s.ranges, ",")
+}
+
+// download runs downloadVerified against s with partial already in the part file
+func download(t *testing.T, s *rangeServer, partial []byte, checksum string) (string, error) {
+    t.Helper()
+    srv := httptest.NewServer(s)
+    t.Cleanup(srv.Close)
+    
+    d := &Downloader{httpClient: srv.Client(), tempDir: t.TempDir()}
+    partPath := filepath.Join(d.tempDir, "update.part")
+    if partial != nil {
+        if err := os.WriteFile(partPath, partial, 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if checksum == "" {
+        sum := sha256.Sum256(s.content)
+        checksum = hex.EncodeToString(sum[:])
+    }
+    return partPath, d.downloadVerified(context.Background(), srv.URL, partPath, int64(len(s.content)), checksum, nil)
+}
+
+func checkDownloaded(t *testing.T, partPath string, want []byte) {
+    t.Helper()
+    got, err := os.ReadFile(partPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(got, want) {
+        t.Errorf("downloaded %d bytes that don't match the %d served", len(got), len(want))
+    }
+}
+
+func testContent() []byte {
+    return bytes.Repeat([]byte("0123456789abcdef"), 4096)
+}
+
+func TestDownloadResumesPartialFile(t *testing.T) {
+    s := &rangeServer{content: testContent()}
+    partPath, err := download(t, s, s.content[:10000], "")
+    if err != nil {
+        t.Fatalf("downloadVerified: %v", err)
+    }
+    checkDownloaded(t, partPath, s.content)
+    if got := s.requests(); got != "bytes=10000-" {
+        t.Errorf("requests = %q, want a single resume from byte 10000", got)
+    }
+}
+
+func TestDownloadRestartsWhenRangeIgnored(t *testing.T) {
+    s := &rangeServer{content: testContent()}
+    s.misbehave = func(w http.ResponseWriter, r *http.Request) {
+        w.Write(s.content)
+    }
+    
+    // What's on disk is stale, so appending to it would corrupt the file
+    partPath, err := download(t, s, bytes.Repeat([]byte("x"), 10000), "")
+    if err != nil {
+        t.Fatalf("downloadVerified: %v", err)
+    }
+    checkDownloaded(t, partPath, s.content)
+}
+
+func TestDownloadRestartsAfterRejectedRange(t *testing.T) {
+    s := &rangeServer{content: testContent()}
+    s.misbehave = func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+    }
+    
+    partPath, err := download(t, s, s.content[:10000], "")
+    if err != nil {
+        t.Fatalf("downloadVerified: %v", err)
+    }
+    checkDownloaded(t, partPath, s.content)
+    if got := s.requests(); got != "bytes=10000-," {
+        t.Errorf("requests = %q, want the resume and then the whole file", got)
+    }
+}
+
+func TestDownloadRestartsOnContentRangeMismatch(t *testing.T) {
+    s := &rangeServer{content: testContent()}
+    s.misbehave = func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(s.content)-1, len(s.content)))
+        w.WriteHeader(http.StatusPartialContent)
+        w.Write(s.content)
+    }
+    
+    partPath, err := download(t, s, s.content[:10000], "")
+    if err != nil {
+        t.Fatalf("downloadVerified: %v", err)
+    }
+    checkDownloaded(t, partPath, s.content)
+    if got := s.requests(); got != "bytes=10000-," {
+        t.Errorf("requests = %q, want the resume and then the whole file", got)
+    }
+}
+
+func TestDownloadDiscardsCorruptFile(t *testing.T) {
+    s := &rangeServer{content: testContent()}
+    partPath, err := download(t, s, nil, strings.Repeat("0", 64))
+    if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+        t.Fatalf("downloadVerified = %v, want a checksum mismatch", err)
+    }
+    if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+        t.Errorf("corrupt partial file kept: %v", err)
+    }
+}
@@ -1,8 +1,12 @@
 package updater
 
 import (
+    "context"
+    "errors"
     "fmt"
-    "time"
+    "net/http"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/logging"
 )
 
 // UpdateManager coordinates the entire update process
@@ -33,9 +37,17 @@ func NewUpdateManager(currentVersion string) (*UpdateManager, error) {
     }, nil
 }
 
+// SetHTTPTransport routes release checks and downloads through rt, so
+// updates use the same proxy and TLS settings as the API
+func (um *UpdateManager) SetHTTPTransport(rt http.RoundTripper) {
+    um.versionChecker.httpClient.Transport = rt
+    um.downloader.httpClient.Transport = rt
+}
+
 // CheckAndUpdate checks for updates and applies them if available
 func (um *UpdateManager) CheckAndUpdate(autoApply bool) error {
-    fmt.Println("Checking for updates...")
+    logger := logging.Subsystem("updater")
+    logger.Info("Checking for updates")
     
     release, hasUpdate, err := um.versionChecker.CheckForUpdate()
     if err != nil {
@@ -43,27 +55,27 @@ func (um *UpdateManager) CheckAndUpdate(autoApply bool) error {
     }
     
     if !hasUpdate {
-        fmt.Println("You're running the latest version")
+        logger.Info("You're running the latest version", "version", um.currentVersion)
         return nil
     }
     
-    fmt.Printf("New version available: %s (current: %s)\n", 
-        release.TagName, um.currentVersion)
+    logger.Info("New version available", "version", release.TagName, "current", um.currentVersion)
     
     if !autoApply {
-        fmt.Println("Run with --update flag to apply update")
+        logger.Info("Run with --update flag to apply update")
         return nil
     }
     
-    // Download the update
-    fmt.Println("Downloading update...")
-    updatePath, err := um.downloader.DownloadUpdate(release)
+    // Download the update; the progress line is for someone at the console
+    logger.Info("Downloading update", "version", release.TagName)
+    updatePath, err := um.download(context.Background(), release, ConsoleProgress())
+    fmt.Println()
     if err != nil {
         return fmt.Errorf("failed to download update: %w", err)
     }
     
     // Apply the update
-    fmt.Println("Applying update...")
+    logger.Info("Applying update", "version", release.TagName)
     if err := um.selfUpdater.ApplyUpdate(updatePath); err != nil {
         // Try to rollback on failure
         um.selfUpdater.Rollback()
@@ -73,21 +85,54 @@ func (um *UpdateManager) CheckAndUpdate(autoApply bool) error {
     return nil
 }
 
-// BackgroundUpdateCheck runs periodic update checks
-func (um *UpdateManager) BackgroundUpdateCheck(interval time.Duration) {
-    ticker := time.NewTicker(interval)
-    defer ticker.Stop()
+// download fetches the new release, preferring a small patch against the
+// running binary and falling back to the full asset if anything doesn't match
+func (um *UpdateManager) download(ctx context.Context, release *GitHubRelease, progress ProgressFunc) (string, error) {
+    manifest, err := um.downloader.FetchManifest(ctx, release)
+    if err != nil {
+        // A manifest that exists but can't be verified is a reason to stop, not to guess
+        return "", err
+    }
+    
+    if manifest != nil {
+        path, err := um.downloader.DownloadDelta(ctx, release, manifest, 
+            um.currentVersion, um.selfUpdater.currentExePath, progress)
+        if err == nil {
+            return path, nil
+        }
+        if ctx.Err() != nil {
+            return "", ctx.Err()
+        }
+        if !errors.Is(err, ErrNoPatch) {
+            logging.Subsystem("updater").Warn("Delta update failed, downloading full binary", "error", err)
+        }
+    }
+    
+    return um.downloader.DownloadUpdate(ctx, release, manifest, progress)
+}
+
+// ConsoleProgress returns a ProgressFunc that redraws a single progress line on stdout
+func ConsoleProgress() ProgressFunc {
+    lastPercent := -1
+    var lastMB int64 = -1
     
-    for range ticker.C {
-        release, hasUpdate, err := um.versionChecker.CheckForUpdate()
-        if err != nil {
-            continue
+    return func(downloaded, total int64) {
+        if total > 0 {
+            percent := int(downloaded * 100 / total)
+            if percent == lastPercent {
+                return
+            }
+            lastPercent = percent
+            fmt.Printf("\r  %3d%% (%.1f / %.1f MB)", percent, 
+                float64(downloaded)/(1<<20), float64(total)/(1<<20))
+            return
         }
-        
-        if hasUpdate {
-            fmt.Printf("\n[Update Available] Version %s is ready to install\n", 
-                release.TagName)
-            fmt.Println("Restart the agent to apply the update")
+    
+        mb := downloaded >> 20
+        if mb == lastMB {
+            return
         }
+        lastMB = mb
+        fmt.Printf("\r  %d MB", mb)
     }
 }
\ No newline at end of file
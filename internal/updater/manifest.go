@@ -0,0 +1,129 @@
+package updater
+
+import (
+    "context"
+    "crypto/ed25519"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+)
+
+const (
+    manifestAssetName  = "manifest.json"
+    signatureAssetName = "manifest.json.sig"
+)
+
+// releasePublicKey is the base64 ed25519 key release manifests are signed with
+// Set at build time: -ldflags "-X github.com/ifruncillo/idlenet-agent/internal/updater.releasePublicKey=..."
+var releasePublicKey = ""
+
+// Manifest is the signed description of a release's binaries and the patches
+// that lead to them from earlier versions
+type Manifest struct {
+    Version string                   `json:"version"`
+    Assets  map[string]ManifestAsset `json:"assets"`
+}
+
+// ManifestAsset describes one platform binary in a release
+type ManifestAsset struct {
+    SHA256  string  `json:"sha256"`
+    Size    int64   `json:"size"`
+    Patches []Patch `json:"patches,omitempty"`
+}
+
+// Patch turns the binary of an earlier version into this one
+type Patch struct {
+    From       string `json:"from"`        // Version the patch applies to
+    FromSHA256 string `json:"from_sha256"` // Exact binary the patch was built against
+    Name       string `json:"name"`        // Release asset holding the patch
+    Format     string `json:"format"`      // "bsdiff" or "zstd"
+    SHA256     string `json:"sha256"`
+    Size       int64  `json:"size"`
+}
+
+// PatchFrom returns the patch that starts from the given version, if any
+func (a *ManifestAsset) PatchFrom(version string) *Patch {
+    version = strings.TrimPrefix(version, "v")
+    for i := range a.Patches {
+        if strings.TrimPrefix(a.Patches[i].From, "v") == version {
+            return &a.Patches[i]
+        }
+    }
+    return nil
+}
+
+// FetchManifest downloads and verifies the release manifest
+// It returns nil without error only when this build has no key to check one
+// with, in which case callers fall back to the release's checksum list. With a
+// key, a release missing its manifest or signature is refused: otherwise
+// deleting two assets would be enough to skip the signature check
+func (d *Downloader) FetchManifest(ctx context.Context, release *GitHubRelease) (*Manifest, error) {
+    if releasePublicKey == "" {
+        return nil, nil
+    }
+    
+    manifestAsset := release.FindAsset(manifestAssetName)
+    signatureAsset := release.FindAsset(signatureAssetName)
+    if manifestAsset == nil || signatureAsset == nil {
+        return nil, fmt.Errorf("release %s has no signed manifest, refusing unverified update", release.TagName)
+    }
+    
+    publicKey, err := base64.StdEncoding.DecodeString(releasePublicKey)
+    if err != nil || len(publicKey) != ed25519.PublicKeySize {
+        return nil, fmt.Errorf("invalid release public key")
+    }
+    
+    body, err := d.fetchSmall(ctx, manifestAsset.DownloadURL)
+    if err != nil {
+        return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+    }
+    
+    encodedSig, err := d.fetchSmall(ctx, signatureAsset.DownloadURL)
+    if err != nil {
+        return nil, fmt.Errorf("failed to fetch manifest signature: %w", err)
+    }
+    
+    signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSig)))
+    if err != nil {
+        return nil, fmt.Errorf("malformed manifest signature: %w", err)
+    }
+    
+    if !ed25519.Verify(ed25519.PublicKey(publicKey), body, signature) {
+        return nil, fmt.Errorf("manifest signature verification failed")
+    }
+    
+    var manifest Manifest
+    if err := json.Unmarshal(body, &manifest); err != nil {
+        return nil, fmt.Errorf("failed to parse manifest: %w", err)
+    }
+    
+    if manifest.Version != release.TagName {
+        return nil, fmt.Errorf("manifest is for %s, not %s", manifest.Version, release.TagName)
+    }
+    
+    return &manifest, nil
+}
+
+// fetchSmall reads a small release asset such as a manifest or checksum list into memory
+func (d *Downloader) fetchSmall(ctx context.Context, url string) ([]byte, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("User-Agent", "IdleNet-Agent-Updater")
+    
+    resp, err := d.httpClient.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("status %d", resp.StatusCode)
+    }
+    
+    return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
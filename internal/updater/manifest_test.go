@@ -0,0 +1,134 @@
+package updater
+
+import (
+    "context"
+    "crypto/ed25519"
+    "encoding/base64"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+// withReleaseKey compiles in a fresh release key for the length of the test
+func withReleaseKey(t *testing.T) ed25519.PrivateKey {
+    t.Helper()
+    public, private, err := ed25519.GenerateKey(nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    saved := releasePublicKey
+    releasePublicKey = base64.StdEncoding.EncodeToString(public)
+    t.Cleanup(func() { releasePublicKey = saved })
+    return private
+}
+
+// serveRelease publishes assets under their names and returns a release listing them
+func serveRelease(t *testing.T, tag string, assets map[string][]byte) (*Downloader, *GitHubRelease) {
+    t.Helper()
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        data, ok := assets[r.URL.Path[1:]]
+        if !ok {
+            http.NotFound(w, r)
+            return
+        }
+        w.Write(data)
+    }))
+    t.Cleanup(srv.Close)
+    
+    release := &GitHubRelease{TagName: tag}
+    for name, data := range assets {
+        release.Assets = append(release.Assets, ReleaseAsset{Name: name, DownloadURL: srv.URL + "/" + name, Size: int64(len(data))})
+    }
+    return &Downloader{httpClient: srv.Client(), tempDir: t.TempDir()}, release
+}
+
+func sign(key ed25519.PrivateKey, body []byte) []byte {
+    return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, body)) + "\n")
+}
+
+func TestFetchManifestVerifiesSignature(t *testing.T) {
+    key := withReleaseKey(t)
+    body := []byte(`{"version":"v1.2.0","assets":{"idlenet-linux-amd64":{"sha256":"ab","size":3}}}`)
+    
+    d, release := serveRelease(t, "v1.2.0", map[string][]byte{
+        manifestAssetName:  body,
+        signatureAssetName: sign(key, body),
+    })
+    manifest, err := d.FetchManifest(context.Background(), release)
+    if err != nil || manifest == nil {
+        t.Fatalf("FetchManifest = %v, %v; want the manifest", manifest, err)
+    }
+    if manifest.Assets["idlenet-linux-amd64"].Size != 3 {
+        t.Errorf("manifest = %+v", manifest)
+    }
+    
+    tampered := []byte(`{"version":"v1.2.0","assets":{"idlenet-linux-amd64":{"sha256":"cd","size":3}}}`)
+    d, release = serveRelease(t, "v1.2.0", map[string][]byte{
+        manifestAssetName:  tampered,
+        signatureAssetName: sign(key, body),
+    })
+    if _, err := d.FetchManifest(context.Background(), release); err == nil {
+        t.Errorf("tampered manifest accepted")
+    }
+    
+    // A genuine manifest moved onto another release
+    d, release = serveRelease(t, "v1.3.0", map[string][]byte{
+        manifestAssetName:  body,
+        signatureAssetName: sign(key, body),
+    })
+    if _, err := d.FetchManifest(context.Background(), release); err == nil {
+        t.Errorf("manifest for v1.2.0 accepted for v1.3.0")
+    }
+}
+
+func TestFetchManifestRequiresSignedManifest(t *testing.T) {
+    key := withReleaseKey(t)
+    body := []byte(`{"version":"v1.2.0"}`)
+    
+    tests := []struct {
+        name   string
+        assets map[string][]byte
+    }{
+        {"no manifest", map[string][]byte{signatureAssetName: sign(key, body)}},
+        {"no signature", map[string][]byte{manifestAssetName: body}},
+        {"neither", map[string][]byte{checksumAssetName: []byte("ab  idlenet-linux-amd64\n")}},
+    }
+    for _, test := range tests {
+        d, release := serveRelease(t, "v1.2.0", test.assets)
+        if manifest, err := d.FetchManifest(context.Background(), release); err == nil {
+            t.Errorf("%s: FetchManifest = %v, nil; want an error", test.name, manifest)
+        }
+    }
+    
+    // Builds without a key have nothing to verify with and use the checksum list
+    releasePublicKey = ""
+    d, release := serveRelease(t, "v1.2.0", tests[2].assets)
+    if manifest, err := d.FetchManifest(context.Background(), release); manifest != nil || err != nil {
+        t.Errorf("FetchManifest without a key = %v, %v; want nil, nil", manifest, err)
+    }
+}
+
+func TestPatchFrom(t *testing.T) {
+    asset := ManifestAsset{Patches: []Patch{
+        {From: "v1.0.0", Name: "a"},
+        {From: "1.1.0", Name: "b"},
+    }}
+    tests := []struct {
+        version string
+        want    string
+    }{
+        {"1.0.0", "a"},
+        {"v1.1.0", "b"},
+        {"v1.1.1", ""},
+        {"1.0", ""},
+    }
+    for _, test := range tests {
+        got := ""
+        if patch := asset.PatchFrom(test.version); patch != nil {
+            got = patch.Name
+        }
+        if got != test.want {
+            t.Errorf("PatchFrom(%s) = %q, want %q", test.version, got, test.want)
+        }
+    }
+}
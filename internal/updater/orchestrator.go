@@ -0,0 +1,393 @@
+package updater
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/logging"
+)
+
+// State describes where the background updater is in its cycle
+type State string
+
+const (
+    StateIdle        State = "idle"        // Up to date, nothing to do
+    StateChecking    State = "checking"    // Asking GitHub for a newer release
+    StateDownloading State = "downloading" // Fetching the new binary
+    StateReady       State = "ready"       // Downloaded, waiting for an idle or maintenance window
+    StateDraining    State = "draining"    // Not taking new jobs, waiting for running ones to finish
+    StateApplying    State = "applying"    // Replacing the executable
+    StateFailed      State = "failed"      // Last attempt failed; retried on the next check
+)
+
+// Status is a snapshot of the orchestrator suitable for heartbeats and the UI
+type Status struct {
+    State            State
+    CurrentVersion   string
+    AvailableVersion string
+    Progress         float64 // Download progress from 0 to 1
+    Error            string
+    Since            time.Time
+}
+
+// OrchestratorOptions tunes when updates are checked for and applied
+type OrchestratorOptions struct {
+    CheckInterval time.Duration      // How often to look for a new release
+    IdleFor       time.Duration      // How long the user must be idle before applying
+    Window        *MaintenanceWindow // Optional window in which updates may apply regardless of idleness
+    DrainTimeout  time.Duration      // How long to wait for jobs before giving up and trying again later
+}
+
+// Orchestrator downloads updates in the background and applies them only when
+// no jobs are running and the machine is idle or inside its maintenance window
+type Orchestrator struct {
+    manager     *UpdateManager
+    opts        OrchestratorOptions
+    runningJobs func() int
+    isIdle      func(time.Duration) (bool, error)
+    restart     chan struct{}
+    
+    mu        sync.RWMutex
+    status    Status
+    accepting bool
+    forced    bool // Set by UpdateNow to skip the idle/window check
+    pending   *GitHubRelease
+    path      string
+    nextDrain time.Time // After a drain times out, don't try again before this
+    now       chan struct{}
+}
+
+// NewOrchestrator creates an orchestrator around an update manager
+// runningJobs reports jobs in flight, including any being claimed from the
+// server, and isIdle reports whether the user has been away for at least the
+// given duration
+func NewOrchestrator(manager *UpdateManager, opts OrchestratorOptions, runningJobs func() int, isIdle func(time.Duration) (bool, error)) *Orchestrator {
+    if opts.CheckInterval <= 0 {
+        opts.CheckInterval = 6 * time.Hour
+    }
+    if opts.IdleFor <= 0 {
+        opts.IdleFor = 10 * time.Minute
+    }
+    if opts.DrainTimeout <= 0 {
+        opts.DrainTimeout = 30 * time.Minute
+    }
+    
+    return &Orchestrator{
+        manager:     manager,
+        opts:        opts,
+        runningJobs: runningJobs,
+        isIdle:      isIdle,
+        accepting:   true,
+        restart:     make(chan struct{}, 1),
+        now:         make(chan struct{}, 1),
+        status: Status{
+            State:          StateIdle,
+            CurrentVersion: manager.currentVersion,
+            Since:          time.Now(),
+        },
+    }
+}
+
+// Restart fires once an update has been installed. The agent should stop
+// taking work, shut down cleanly and then call Relaunch
+func (o *Orchestrator) Restart() <-chan struct{} {
+    return o.restart
+}
+
+// Relaunch replaces the process with the installed update
+// It only returns if that fails
+func (o *Orchestrator) Relaunch() error {
+    return o.manager.selfUpdater.Restart()
+}
+
+// AcceptingJobs reports whether new jobs may start; false while draining for an update
+func (o *Orchestrator) AcceptingJobs() bool {
+    o.mu.RLock()
+    defer o.mu.RUnlock()
+    return o.accepting
+}
+
+// Status returns the current update state
+func (o *Orchestrator) Status() Status {
+    o.mu.RLock()
+    defer o.mu.RUnlock()
+    return o.status
+}
+
+// UpdateNow checks for an update immediately and applies it as soon as
+// running jobs have drained, without waiting for an idle or maintenance window
+func (o *Orchestrator) UpdateNow() {
+    o.mu.Lock()
+    o.forced = true
+    o.mu.Unlock()
+    
+    select {
+    case o.now <- struct{}{}:
+    default:
+    }
+}
+
+// Run checks for updates until ctx is cancelled or an update has been
+// installed, in which case Restart fires
+func (o *Orchestrator) Run(ctx context.Context) {
+    // Give startup (registration, first heartbeat) a moment before hitting GitHub
+    check := time.NewTimer(time.Minute)
+    defer check.Stop()
+    
+    poll := time.NewTicker(30 * time.Second)
+    defer poll.Stop()
+    
+    for {
+        select {
+        case <-ctx.Done():
+            return
+    
+        case <-check.C:
+            o.checkAndDownload(ctx)
+            check.Reset(o.opts.CheckInterval)
+    
+        case <-o.now:
+            o.checkAndDownload(ctx)
+            if o.readyToApply() {
+                if o.drainAndApply(ctx) {
+                    return
+                }
+            } else {
+                // Nothing to apply; don't let the request linger until a later release
+                o.mu.Lock()
+                o.forced = false
+                o.mu.Unlock()
+            }
+    
+        case <-poll.C:
+            if o.readyToApply() && o.drainAndApply(ctx) {
+                return
+            }
+        }
+    }
+}
+
+// checkAndDownload looks for a newer release and fetches it if there is one
+func (o *Orchestrator) checkAndDownload(ctx context.Context) {
+    o.mu.RLock()
+    busy := o.pending != nil
+    o.mu.RUnlock()
+    if busy {
+        return
+    }
+    
+    o.setState(StateChecking, "", nil)
+    
+    release, hasUpdate, err := o.manager.versionChecker.CheckForUpdate()
+    if err != nil {
+        o.setState(StateFailed, "", err)
+        return
+    }
+    if !hasUpdate {
+        o.setState(StateIdle, "", nil)
+        return
+    }
+    
+    o.setState(StateDownloading, release.TagName, nil)
+    
+    path, err := o.manager.download(ctx, release, o.trackProgress)
+    if err != nil {
+        o.setState(StateFailed, release.TagName, err)
+        return
+    }
+    
+    o.mu.Lock()
+    o.pending = release
+    o.path = path
+    o.mu.Unlock()
+    
+    o.setState(StateReady, release.TagName, nil)
+}
+
+// readyToApply reports whether a downloaded update may be installed right now
+func (o *Orchestrator) readyToApply() bool {
+    o.mu.RLock()
+    ready := o.pending != nil && o.status.State == StateReady
+    forced := o.forced
+    nextDrain := o.nextDrain
+    o.mu.RUnlock()
+    if !ready {
+        return false
+    }
+    if forced {
+        return true
+    }
+    if time.Now().Before(nextDrain) {
+        return false
+    }
+    
+    return o.windowOpen()
+}
+
+// windowOpen reports whether we're in the maintenance window or the user is away
+func (o *Orchestrator) windowOpen() bool {
+    if o.opts.Window != nil && o.opts.Window.Contains(time.Now()) {
+        return true
+    }
+    
+    idle, err := o.isIdle(o.opts.IdleFor)
+    return err == nil && idle
+}
+
+// drainAndApply stops new jobs, waits for running ones and installs the update
+// It reports whether the update was installed and the agent should restart
+func (o *Orchestrator) drainAndApply(ctx context.Context) bool {
+    // Stop accepting before counting jobs, so a job being claimed right now
+    // is either counted below or turned away by AcceptingJobs
+    o.mu.Lock()
+    o.accepting = false
+    forced := o.forced
+    o.forced = false
+    release, path := o.pending, o.path
+    o.mu.Unlock()
+    
+    o.setState(StateDraining, release.TagName, nil)
+    
+    // Jobs can't be checkpointed, so if they outlast the timeout we let them
+    // finish, take jobs again and try another time
+    deadline := time.NewTimer(o.opts.DrainTimeout)
+    defer deadline.Stop()
+    
+    for o.runningJobs() > 0 {
+        select {
+        case <-ctx.Done():
+            o.resume(StateReady, release.TagName, nil)
+            return false
+        case <-deadline.C:
+            logging.Subsystem("updater").Info("Jobs still running, will try the update again later",
+                "version", release.TagName, "waited", o.opts.DrainTimeout)
+            o.mu.Lock()
+            o.nextDrain = time.Now().Add(o.opts.DrainTimeout)
+            o.mu.Unlock()
+            o.resume(StateReady, release.TagName, nil)
+            return false
+        case <-time.After(5 * time.Second):
+        }
+    }
+    
+    // The user may have come back or the window closed while jobs finished
+    if !forced && !o.windowOpen() {
+        o.resume(StateReady, release.TagName, nil)
+        return false
+    }
+    
+    o.setState(StateApplying, release.TagName, nil)
+    
+    if err := o.manager.selfUpdater.Install(path); err != nil {
+        o.manager.selfUpdater.Rollback()
+        o.mu.Lock()
+        o.pending = nil
+        o.mu.Unlock()
+        o.resume(StateFailed, release.TagName, fmt.Errorf("failed to apply update: %w", err))
+        return false
+    }
+    
+    // Stay drained; the agent shuts down and restarts into the new version
+    o.restart <- struct{}{}
+    return true
+}
+
+// resume lets jobs start again after a drain that didn't end in an update
+func (o *Orchestrator) resume(state State, version string, err error) {
+    o.mu.Lock()
+    o.accepting = true
+    o.mu.Unlock()
+    o.setState(state, version, err)
+}
+
+func (o *Orchestrator) trackProgress(downloaded, total int64) {
+    if total <= 0 {
+        return
+    }
+    o.mu.Lock()
+    o.status.Progress = float64(downloaded) / float64(total)
+    o.mu.Unlock()
+}
+
+func (o *Orchestrator) setState(state State, version string, err error) {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    
+    if o.status.State != state {
+        o.status.Since = time.Now()
+        logState(state, version, err)
+    }
+    o.status.State = state
+    o.status.AvailableVersion = version
+    o.status.Error = ""
+    if err != nil {
+        o.status.Error = err.Error()
+    }
+    if state != StateDownloading {
+        o.status.Progress = 0
+    }
+}
+
+// logState records a change of state; routine checks are only worth a debug line
+func logState(state State, version string, err error) {
+    logger := logging.Subsystem("updater")
+    switch {
+    case err != nil:
+        logger.Warn("Update "+string(state), "version", version, "error", err)
+    case state == StateChecking || state == StateIdle:
+        logger.Debug("Update "+string(state), "version", version)
+    default:
+        logger.Info("Update "+string(state), "version", version)
+    }
+}
+
+// MaintenanceWindow is a daily local-time range such as 02:00-05:00
+// Windows may wrap past midnight, e.g. 23:00-01:00
+type MaintenanceWindow struct {
+    start time.Duration // Offset from local midnight
+    end   time.Duration
+}
+
+// ParseMaintenanceWindow parses "HH:MM-HH:MM"; an empty string means no window
+func ParseMaintenanceWindow(s string) (*MaintenanceWindow, error) {
+    s = strings.TrimSpace(s)
+    if s == "" {
+        return nil, nil
+    }
+    
+    from, to, ok := strings.Cut(s, "-")
+    if !ok {
+        return nil, fmt.Errorf("invalid maintenance window %q: expected HH:MM-HH:MM", s)
+    }
+    
+    start, err := parseClock(from)
+    if err != nil {
+        return nil, fmt.Errorf("invalid maintenance window %q: %w", s, err)
+    }
+    end, err := parseClock(to)
+    if err != nil {
+        return nil, fmt.Errorf("invalid maintenance window %q: %w", s, err)
+    }
+    
+    return &MaintenanceWindow{start: start, end: end}, nil
+}
+
+// Contains reports whether t falls inside the window
+func (w *MaintenanceWindow) Contains(t time.Time) bool {
+    offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+    if w.start <= w.end {
+        return offset >= w.start && offset < w.end
+    }
+    return offset >= w.start || offset < w.end
+}
+
+func parseClock(s string) (time.Duration, error) {
+    t, err := time.Parse("15:04", strings.TrimSpace(s))
+    if err != nil {
+        return 0, err
+    }
+    return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
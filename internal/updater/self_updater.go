@@ -8,7 +8,8 @@ import (
     "os/exec"
     "path/filepath"
     "runtime"
-    "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/logging"
 )
 
 // SelfUpdater handles the self-replacement process
@@ -30,8 +31,18 @@ func NewSelfUpdater() (*SelfUpdater, error) {
     }, nil
 }
 
-// ApplyUpdate replaces the current executable with the new one
+// ApplyUpdate replaces the current executable with the new one and restarts into it
 func (su *SelfUpdater) ApplyUpdate(newExePath string) error {
+    if err := su.Install(newExePath); err != nil {
+        return err
+    }
+    return su.Restart()
+}
+
+// Install puts the new executable in place, keeping a backup of the current one
+// The process keeps running the old code until Restart, so the agent can shut
+// down cleanly in between
+func (su *SelfUpdater) Install(newExePath string) error {
     // Step 1: Create backup of current executable
     if err := su.createBackup(); err != nil {
         return fmt.Errorf("failed to create backup: %w", err)
@@ -40,10 +51,23 @@ func (su *SelfUpdater) ApplyUpdate(newExePath string) error {
     // Step 2: Replace executable
     if runtime.GOOS == "windows" {
         // Windows requires special handling
-        return su.applyUpdateWindows(newExePath)
+        return su.installWindows(newExePath)
     }
     
-    return su.applyUpdateUnix(newExePath)
+    return su.installUnix(newExePath)
+}
+
+// Restart replaces this process with the installed executable and doesn't
+// return unless that fails
+func (su *SelfUpdater) Restart() error {
+    if runtime.GOOS == "windows" {
+        return su.restartWindows()
+    }
+    
+    args := os.Args
+    env := os.Environ()
+    
+    return syscall.Exec(su.currentExePath, args, env)
 }
 
 // createBackup creates a backup of the current executable
@@ -64,8 +88,9 @@ func (su *SelfUpdater) createBackup() error {
     return err
 }
 
-// applyUpdateWindows handles Windows-specific update process
-func (su *SelfUpdater) applyUpdateWindows(newExePath string) error {
+// installWindows writes the script that swaps the executable in once this
+// process has exited; a running executable can't be replaced on Windows
+func (su *SelfUpdater) installWindows(newExePath string) error {
     // Create a batch file that will:
     // 1. Wait for current process to exit
     // 2. Replace the executable
@@ -79,28 +104,29 @@ move /y "%s" "%s"
 start "" "%s"
 del "%%~f0"
 `, newExePath, su.currentExePath, su.currentExePath)
-    
-    batchPath := filepath.Join(os.TempDir(), "idlenet_update.bat")
-    if err := os.WriteFile(batchPath, []byte(batchContent), 0755); err != nil {
-        return err
-    }
-    
-    // Execute the batch file
-    cmd := exec.Command("cmd", "/c", batchPath)
+
+    return os.WriteFile(su.batchPath(), []byte(batchContent), 0755)
+}
+
+// restartWindows starts the update script and exits so it can replace us
+func (su *SelfUpdater) restartWindows() error {
+    cmd := exec.Command("cmd", "/c", su.batchPath())
     if err := cmd.Start(); err != nil {
         return err
     }
     
-    // Exit the current process
-    fmt.Println("Update will be applied on restart...")
-    time.Sleep(2 * time.Second)
+    logging.Subsystem("updater").Info("Update will be applied on restart")
     os.Exit(0)
     
     return nil
 }
 
-// applyUpdateUnix handles Unix-like systems update process
-func (su *SelfUpdater) applyUpdateUnix(newExePath string) error {
+func (su *SelfUpdater) batchPath() string {
+    return filepath.Join(os.TempDir(), "idlenet_update.bat")
+}
+
+// installUnix renames the new executable over the current one
+func (su *SelfUpdater) installUnix(newExePath string) error {
     // Make new executable permission match current
     currentInfo, err := os.Stat(su.currentExePath)
     if err != nil {
@@ -111,16 +137,7 @@ func (su *SelfUpdater) applyUpdateUnix(newExePath string) error {
         return err
     }
     
-    // Replace the executable
-    if err := os.Rename(newExePath, su.currentExePath); err != nil {
-        return err
-    }
-    
-    // Restart the process
-    args := os.Args
-    env := os.Environ()
-    
-    return syscall.Exec(su.currentExePath, args, env)
+    return os.Rename(newExePath, su.currentExePath)
 }
 
 // Rollback restores the backup if update failed
@@ -6,20 +6,35 @@ import (
     "net/http"
     "strings"
     "time"
+    
+    "github.com/ifruncillo/idlenet-agent/internal/netconf"
 )
 
 // GitHubRelease represents the structure of a GitHub release
 type GitHubRelease struct {
     TagName string `json:"tag_name"`
     Name    string `json:"name"`
-    Assets  []struct {
-        Name        string `json:"name"`
-        DownloadURL string `json:"browser_download_url"`
-        Size        int    `json:"size"`
-    } `json:"assets"`
+    Assets  []ReleaseAsset `json:"assets"`
     PublishedAt time.Time `json:"published_at"`
 }
 
+// ReleaseAsset is a single downloadable file attached to a release
+type ReleaseAsset struct {
+    Name        string `json:"name"`
+    DownloadURL string `json:"browser_download_url"`
+    Size        int64  `json:"size"`
+}
+
+// FindAsset returns the asset with the given name, or nil if the release doesn't have one
+func (r *GitHubRelease) FindAsset(name string) *ReleaseAsset {
+    for i := range r.Assets {
+        if r.Assets[i].Name == name {
+            return &r.Assets[i]
+        }
+    }
+    return nil
+}
+
 // VersionChecker checks for new releases on GitHub
 type VersionChecker struct {
     currentVersion string
@@ -35,7 +50,8 @@ func NewVersionChecker(currentVersion string) *VersionChecker {
         repoOwner:      "ifruncillo",
         repoName:       "idlenet-agent",
         httpClient: &http.Client{
-            Timeout: 10 * time.Second,
+            Transport: netconf.Default(),
+            Timeout:   10 * time.Second,
         },
     }
 }